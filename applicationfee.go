@@ -0,0 +1,116 @@
+package goblstripe
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// FromApplicationFees converts the application fees a Connect platform collected over
+// a period into GOBL invoices, one per connected account, issued by the platform
+// account to the connected account. The customer is built from the business profile
+// of the connected account, so the fees should be retrieved with the account expanded.
+// Each fee is a line, net of the amounts refunded, and fully refunded fees are left
+// out. Application fees carry no tax, so they follow the rules of the services of
+// cross-border invoices: the fees invoiced to businesses in other member states are
+// issued under reverse charge and the ones invoiced outside the EU are outside the
+// scope of VAT, while the rest include the standard rate of the platform's regime, as
+// the amount collected is all the connected account pays. Fees created outside of the
+// period are ignored and, when no period is given, it spans the fees given. Invoices
+// follow the order in which the connected accounts first appear.
+func FromApplicationFees(fees []*stripe.ApplicationFee, platform *stripe.Account, period *stripe.Period) ([]*bill.Invoice, error) {
+	regimeDef, err := regimeFromAccount(platform)
+	if err != nil {
+		return nil, err
+	}
+	if period == nil {
+		period = applicationFeesPeriod(fees)
+	}
+
+	var ids []string
+	accounts := make(map[string]*stripe.Account)
+	groups := make(map[string]*balanceFees)
+	for _, fee := range fees {
+		if fee == nil || fee.Created < period.Start || fee.Created > period.End {
+			continue
+		}
+		if fee.Account == nil || fee.Account.ID == "" {
+			return nil, fmt.Errorf("application fee %s has no connected account", fee.ID)
+		}
+		amount := fee.Amount - fee.AmountRefunded
+		if amount == 0 {
+			continue
+		}
+
+		id := fee.Account.ID
+		group, ok := groups[id]
+		if !ok {
+			ids = append(ids, id)
+			accounts[id] = fee.Account
+			group = &balanceFees{currency: fee.Currency, taxIncluded: true}
+			groups[id] = group
+		} else if fee.Currency != group.currency {
+			return nil, fmt.Errorf("application fees of account %s in different currencies: %s and %s", id, group.currency, fee.Currency)
+		}
+
+		price := CurrencyAmount(amount, FromCurrency(fee.Currency))
+		group.lines = append(group.lines, &bill.Line{
+			Quantity: num.MakeAmount(1, 0),
+			Item: &org.Item{
+				Name:  applicationFeeName(fee),
+				Price: &price,
+			},
+		})
+		group.base += amount
+	}
+
+	invs := make([]*bill.Invoice, 0, len(ids))
+	for _, id := range ids {
+		connected := accounts[id]
+		customer := NewSupplierFromAccount(connected)
+		if customer == nil {
+			return nil, fmt.Errorf("connected account %s has no expanded business profile", id)
+		}
+
+		inv := newFeesInvoice(groups[id], regimeDef, period, NewSupplierFromAccount(platform), customer, connected.Country)
+		inv.Code = cbc.Code(string(newFeesCode(period, regimeDef)) + "-" + id)
+		inv.Meta = cbc.Meta{
+			MetaKeyStripeDocID:   id,
+			MetaKeyStripeDocType: StripeDocTypeApplicationFees,
+		}
+		invs = append(invs, inv)
+	}
+
+	return invs, nil
+}
+
+// applicationFeeName returns the name of the line of an application fee, referencing
+// the charge it was collected on.
+func applicationFeeName(fee *stripe.ApplicationFee) string {
+	if fee.Charge != nil && fee.Charge.ID != "" {
+		return "Application fee for charge " + fee.Charge.ID
+	}
+	return "Application fee " + fee.ID
+}
+
+// applicationFeesPeriod returns the period spanning the creation of a list of
+// application fees.
+func applicationFeesPeriod(fees []*stripe.ApplicationFee) *stripe.Period {
+	period := new(stripe.Period)
+	for _, fee := range fees {
+		if fee == nil {
+			continue
+		}
+		if period.Start == 0 || fee.Created < period.Start {
+			period.Start = fee.Created
+		}
+		if fee.Created > period.End {
+			period.End = fee.Created
+		}
+	}
+	return period
+}
@@ -0,0 +1,184 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func validConnectedAccount() *stripe.Account {
+	return &stripe.Account{
+		ID:      "acct_1QkqJbQhcl5B85Yl",
+		Country: "FR",
+		BusinessProfile: &stripe.AccountBusinessProfile{
+			Name: "Boulangerie Martin",
+			SupportAddress: &stripe.Address{
+				City:       "Paris",
+				Country:    "FR",
+				Line1:      "12 Rue de Rivoli",
+				PostalCode: "75004",
+			},
+		},
+		Settings: &stripe.AccountSettings{
+			Invoices: &stripe.AccountSettingsInvoices{
+				DefaultAccountTaxIDs: []*stripe.TaxID{
+					{
+						Created: 1736351225,
+						Type:    stripe.TaxIDTypeEUVAT,
+						Value:   "FR44732829320",
+						Country: "FR",
+					},
+				},
+			},
+		},
+	}
+}
+
+func validStripeApplicationFees() []*stripe.ApplicationFee {
+	connected := validConnectedAccount()
+	return []*stripe.ApplicationFee{
+		{
+			ID:       "fee_1QkqKWQhcl5B85YlhS2aJ8Bq",
+			Account:  connected,
+			Amount:   200,
+			Charge:   &stripe.Charge{ID: "ch_3QkqKWQhcl5B85Yl0aL7bsGv"},
+			Created:  1737738363,
+			Currency: stripe.CurrencyEUR,
+		},
+		{
+			ID:             "fee_1QkqMaQhcl5B85YlwV3dE8Tf",
+			Account:        connected,
+			Amount:         500,
+			AmountRefunded: 100,
+			Charge:         &stripe.Charge{ID: "ch_3QkqMaQhcl5B85Yl1c8GmTqH"},
+			Created:        1737824763,
+			Currency:       stripe.CurrencyEUR,
+		},
+		{
+			// Fully refunded
+			ID:             "fee_1QkqNbQhcl5B85YlqT7Kx2Pe",
+			Account:        connected,
+			Amount:         300,
+			AmountRefunded: 300,
+			Refunded:       true,
+			Created:        1737911163,
+			Currency:       stripe.CurrencyEUR,
+		},
+	}
+}
+
+func TestFromApplicationFees(t *testing.T) {
+	platform := validStripeAccount()
+	platform.Country = "DE"
+	period := &stripe.Period{
+		Start: 1735689600, // 2025-01-01
+		End:   1738281600, // 2025-01-31
+	}
+
+	t.Run("reverse charge", func(t *testing.T) {
+		invs, err := goblstripe.FromApplicationFees(validStripeApplicationFees(), platform, period)
+		require.NoError(t, err)
+		require.Len(t, invs, 1)
+
+		inv := invs[0]
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, "FEES-20250101-20250131-acct_1QkqJbQhcl5B85Yl", inv.Code.String())
+		assert.Equal(t, "application_fees", inv.Meta[goblstripe.MetaKeyStripeDocType])
+		assert.Equal(t, "Test Account", inv.Supplier.Name)
+		assert.Equal(t, "Boulangerie Martin", inv.Customer.Name)
+		assert.True(t, inv.HasTags(tax.TagReverseCharge))
+
+		require.Len(t, inv.Lines, 2)
+		assert.Equal(t, "Application fee for charge ch_3QkqKWQhcl5B85Yl0aL7bsGv", inv.Lines[0].Item.Name)
+		assert.Equal(t, "4.00", inv.Lines[1].Item.Price.String())
+		assert.Equal(t, "6.00", inv.Totals.Payable.String())
+		assert.Equal(t, "0.00", inv.Totals.Due.String())
+	})
+
+	t.Run("per connected account", func(t *testing.T) {
+		other := validConnectedAccount()
+		other.ID = "acct_1QkqLcQhcl5B85Yl"
+		other.Country = "DE"
+		other.BusinessProfile.Name = "Bäckerei Müller"
+		fees := append(validStripeApplicationFees(), &stripe.ApplicationFee{
+			ID:       "fee_1QkqPdQhcl5B85YlbM1sV9Nc",
+			Account:  other,
+			Amount:   150,
+			Created:  1737997563,
+			Currency: stripe.CurrencyEUR,
+		})
+
+		invs, err := goblstripe.FromApplicationFees(fees, platform, nil)
+		require.NoError(t, err)
+		require.Len(t, invs, 2)
+
+		// Domestic fees include the platform's standard VAT
+		inv := invs[1]
+		assert.Equal(t, "Bäckerei Müller", inv.Customer.Name)
+		assert.False(t, inv.HasTags(tax.TagReverseCharge))
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+		require.Len(t, inv.Lines, 1)
+		assert.Equal(t, tax.CategoryVAT, inv.Lines[0].Taxes[0].Category)
+		assert.Equal(t, tax.RateGeneral, inv.Lines[0].Taxes[0].Rate)
+		assert.Equal(t, "19%", inv.Lines[0].Taxes[0].Percent.String())
+		assert.Equal(t, "0.24", inv.Totals.Tax.String())
+		assert.Equal(t, "1.50", inv.Totals.Payable.String())
+	})
+
+	t.Run("consumer in another country", func(t *testing.T) {
+		fees := validStripeApplicationFees()
+		fees[0].Account.Settings = nil
+
+		invs, err := goblstripe.FromApplicationFees(fees, platform, period)
+		require.NoError(t, err)
+		require.Len(t, invs, 1)
+
+		inv := invs[0]
+		require.NoError(t, inv.Calculate())
+		assert.False(t, inv.HasTags(tax.TagReverseCharge))
+		assert.Equal(t, tax.RateGeneral, inv.Lines[0].Taxes[0].Rate)
+		assert.Equal(t, "0.96", inv.Totals.Tax.String())
+		assert.Equal(t, "6.00", inv.Totals.Payable.String())
+	})
+
+	t.Run("account outside the EU", func(t *testing.T) {
+		fees := validStripeApplicationFees()
+		fees[0].Account.Country = "US"
+		fees[0].Account.BusinessProfile.SupportAddress.Country = "US"
+		fees[0].Account.Settings = nil
+
+		invs, err := goblstripe.FromApplicationFees(fees, platform, period)
+		require.NoError(t, err)
+		require.Len(t, invs, 1)
+
+		inv := invs[0]
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+		assert.False(t, inv.HasTags(tax.TagReverseCharge))
+		assert.Equal(t, tax.KeyOutsideScope, inv.Lines[0].Taxes[0].Key)
+		assert.Equal(t, "0.00", inv.Totals.Tax.String())
+		assert.Equal(t, "6.00", inv.Totals.Payable.String())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := goblstripe.FromApplicationFees(validStripeApplicationFees(), nil, period)
+		assert.ErrorContains(t, err, "missing account country")
+
+		fees := validStripeApplicationFees()
+		fees[0].Account = nil
+		_, err = goblstripe.FromApplicationFees(fees, platform, period)
+		assert.ErrorContains(t, err, "has no connected account")
+
+		fees = validStripeApplicationFees()
+		fees[1].Currency = stripe.CurrencyUSD
+		_, err = goblstripe.FromApplicationFees(fees, platform, period)
+		assert.ErrorContains(t, err, "different currencies")
+	})
+}
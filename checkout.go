@@ -0,0 +1,146 @@
+package goblstripe
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// FromCheckoutSession converts a completed Stripe Checkout Session that didn't create a
+// Stripe invoice into a GOBL invoice, recording the amount paid as an advance. The
+// invoice is tagged as simplified unless the customer provided a tax ID. The session
+// should be retrieved with the line items, the total details breakdown and the payment
+// intent (with its latest charge) expanded. Checkout sessions don't carry the account
+// country, so the account is required to determine the regime and the supplier.
+//
+// Payment intents created outside of Checkout are not converted, as they have no line
+// or tax details to build an invoice from.
+func FromCheckoutSession(cs *stripe.CheckoutSession, account *stripe.Account) (*bill.Invoice, error) {
+	if cs == nil {
+		return nil, fmt.Errorf("missing checkout session")
+	}
+	if cs.Invoice != nil {
+		return nil, fmt.Errorf("checkout session %s created invoice %s, use FromInvoice instead", cs.ID, cs.Invoice.ID)
+	}
+	if cs.Mode == stripe.CheckoutSessionModeSetup {
+		return nil, fmt.Errorf("checkout session %s is in setup mode", cs.ID)
+	}
+	if cs.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+		return nil, fmt.Errorf("checkout session %s has not been paid", cs.ID)
+	}
+	if cs.LineItems == nil {
+		return nil, fmt.Errorf("checkout session %s has no expanded line items", cs.ID)
+	}
+	if account == nil || account.Country == "" {
+		return nil, fmt.Errorf("missing account country")
+	}
+
+	inv, err := FromInvoice(newInvoiceFromCheckoutSession(cs, account), account)
+	if err != nil {
+		return inv, err
+	}
+	inv.Meta[MetaKeyStripeDocType] = StripeDocTypeCheckoutSession
+
+	return inv, nil
+}
+
+// newInvoiceFromCheckoutSession builds the Stripe invoice that a checkout session would
+// have created, so it can be converted with the invoice logic.
+func newInvoiceFromCheckoutSession(cs *stripe.CheckoutSession, account *stripe.Account) *stripe.Invoice {
+	doc := &stripe.Invoice{
+		ID:               cs.ID,
+		AccountCountry:   account.Country,
+		Created:          cs.Created,
+		PeriodStart:      cs.Created,
+		PeriodEnd:        cs.Created,
+		Currency:         cs.Currency,
+		Metadata:         cs.Metadata,
+		Subtotal:         cs.AmountSubtotal,
+		Total:            cs.AmountTotal,
+		CustomerShipping: cs.ShippingDetails,
+		Paid:             cs.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid,
+		Lines:            &stripe.InvoiceLineItemList{},
+	}
+	if doc.Paid {
+		doc.AmountPaid = cs.AmountTotal
+	}
+	if pi := cs.PaymentIntent; pi != nil {
+		doc.PaymentIntent = pi
+		if pi.LatestCharge != nil && pi.LatestCharge.Created != 0 {
+			doc.Charge = pi.LatestCharge
+		}
+	}
+
+	// The details given during checkout are the ones that apply to the sale
+	if cd := cs.CustomerDetails; cd != nil {
+		doc.CustomerName = cd.Name
+		doc.CustomerEmail = cd.Email
+		doc.CustomerPhone = cd.Phone
+		doc.CustomerAddress = cd.Address
+		if cd.TaxExempt != "" {
+			exempt := stripe.CustomerTaxExempt(cd.TaxExempt)
+			doc.CustomerTaxExempt = &exempt
+		}
+		for _, id := range cd.TaxIDs {
+			idType := stripe.TaxIDType(id.Type)
+			doc.CustomerTaxIDs = append(doc.CustomerTaxIDs, &stripe.InvoiceCustomerTaxID{
+				Type:  &idType,
+				Value: id.Value,
+			})
+		}
+	} else if cs.Customer != nil && cs.Customer.Created != 0 {
+		doc.Customer = cs.Customer
+	}
+
+	for _, li := range cs.LineItems.Data {
+		doc.Lines.Data = append(doc.Lines.Data, newInvoiceLineFromLineItem(li))
+	}
+	if line := newShippingLineFromCheckoutSession(cs); line != nil {
+		doc.Lines.Data = append(doc.Lines.Data, line)
+	}
+
+	if cs.TotalDetails != nil && cs.TotalDetails.Breakdown != nil {
+		for _, t := range cs.TotalDetails.Breakdown.Taxes {
+			doc.TotalTaxAmounts = append(doc.TotalTaxAmounts, &stripe.InvoiceTotalTaxAmount{
+				Amount:           t.Amount,
+				Inclusive:        t.Rate != nil && t.Rate.Inclusive,
+				TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReason(t.TaxabilityReason),
+				TaxableAmount:    t.TaxableAmount,
+				TaxRate:          t.Rate,
+			})
+		}
+	} else {
+		doc.TotalTaxAmounts = lineTaxAmounts(doc.Lines.Data)
+	}
+
+	return doc
+}
+
+// newShippingLineFromCheckoutSession creates an invoice line item for the shipping cost
+// of a checkout session, which is not part of its line items.
+func newShippingLineFromCheckoutSession(cs *stripe.CheckoutSession) *stripe.InvoiceLineItem {
+	sc := cs.ShippingCost
+	if sc == nil || sc.AmountSubtotal == 0 {
+		return nil
+	}
+	line := &stripe.InvoiceLineItem{
+		Amount:      sc.AmountSubtotal,
+		Currency:    cs.Currency,
+		Description: "Shipping",
+		Quantity:    1,
+	}
+	if sc.ShippingRate != nil && sc.ShippingRate.DisplayName != "" {
+		line.Description = sc.ShippingRate.DisplayName
+	}
+	for _, t := range sc.Taxes {
+		line.TaxAmounts = append(line.TaxAmounts, &stripe.InvoiceTotalTaxAmount{
+			Amount:           t.Amount,
+			Inclusive:        t.Rate != nil && t.Rate.Inclusive,
+			TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReason(t.TaxabilityReason),
+			TaxableAmount:    t.TaxableAmount,
+			TaxRate:          t.Rate,
+		})
+	}
+	return line
+}
@@ -0,0 +1,162 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func validStripeCheckoutSession() *stripe.CheckoutSession {
+	rate := &stripe.TaxRate{
+		Created:             1736351225,
+		TaxType:             stripe.TaxRateTaxTypeVAT,
+		Country:             "DE",
+		EffectivePercentage: 19.0,
+		Percentage:          19.0,
+		Inclusive:           true,
+	}
+	charge := validStripeCharge()
+	charge.Invoice = nil
+	return &stripe.CheckoutSession{
+		ID:             "cs_test_a1Kq4KWQhcl5B85YlT9bRf2Ct",
+		Object:         "checkout.session",
+		Mode:           stripe.CheckoutSessionModePayment,
+		Status:         stripe.CheckoutSessionStatusComplete,
+		PaymentStatus:  stripe.CheckoutSessionPaymentStatusPaid,
+		Created:        1737738363,
+		Currency:       stripe.CurrencyEUR,
+		AmountSubtotal: 2000,
+		AmountTotal:    2000,
+		CustomerDetails: &stripe.CheckoutSessionCustomerDetails{
+			Name:  "Jane Doe",
+			Email: "jane@example.com",
+			Address: &stripe.Address{
+				City:       "Berlin",
+				Country:    "DE",
+				Line1:      "Unter den Linden 1",
+				PostalCode: "10117",
+			},
+			TaxExempt: stripe.CheckoutSessionCustomerDetailsTaxExemptNone,
+		},
+		LineItems: &stripe.LineItemList{
+			Data: []*stripe.LineItem{
+				{
+					ID:             "li_1QkqKWQhcl5B85YlP4sZ6dYe",
+					Description:    "E-book",
+					AmountSubtotal: 2000,
+					AmountTax:      319,
+					AmountTotal:    2000,
+					Currency:       stripe.CurrencyEUR,
+					Quantity:       1,
+					Price: &stripe.Price{
+						BillingScheme: stripe.PriceBillingSchemePerUnit,
+						Currency:      stripe.CurrencyEUR,
+						UnitAmount:    2000,
+					},
+					Taxes: []*stripe.LineItemTax{
+						{
+							Amount:           319,
+							Rate:             rate,
+							TaxabilityReason: stripe.LineItemTaxTaxabilityReasonStandardRated,
+							TaxableAmount:    1681,
+						},
+					},
+				},
+			},
+		},
+		TotalDetails: &stripe.CheckoutSessionTotalDetails{
+			AmountTax: 319,
+			Breakdown: &stripe.CheckoutSessionTotalDetailsBreakdown{
+				Taxes: []*stripe.CheckoutSessionTotalDetailsBreakdownTax{
+					{
+						Amount:           319,
+						Rate:             rate,
+						TaxabilityReason: stripe.CheckoutSessionTotalDetailsBreakdownTaxTaxabilityReasonStandardRated,
+						TaxableAmount:    1681,
+					},
+				},
+			},
+		},
+		PaymentIntent: &stripe.PaymentIntent{
+			ID:           "pi_3QkqKWQhcl5B85Yl0bXGZ5nD",
+			Status:       stripe.PaymentIntentStatusSucceeded,
+			LatestCharge: charge,
+		},
+	}
+}
+
+func TestFromCheckoutSession(t *testing.T) {
+	account := validStripeAccount()
+	account.Country = "DE"
+
+	t.Run("simplified", func(t *testing.T) {
+		inv, err := goblstripe.FromCheckoutSession(validStripeCheckoutSession(), account)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, "cs_test_a1Kq4KWQhcl5B85YlT9bRf2Ct", inv.Code.String())
+		assert.Equal(t, "checkout_session", inv.Meta[goblstripe.MetaKeyStripeDocType])
+		assert.True(t, inv.HasTags(tax.TagSimplified))
+		assert.Equal(t, "Jane Doe", inv.Customer.Name)
+		assert.Equal(t, "Test Account", inv.Supplier.Name)
+		require.NotNil(t, inv.Tax)
+		assert.Equal(t, tax.CategoryVAT, inv.Tax.PricesInclude)
+
+		require.Len(t, inv.Lines, 1)
+		assert.Equal(t, "E-book", inv.Lines[0].Item.Name)
+		assert.Equal(t, "20.00", inv.Totals.TotalWithTax.String())
+		assert.Equal(t, "3.19", inv.Totals.Tax.String())
+
+		require.Len(t, inv.Payment.Advances, 1)
+		assert.Equal(t, "20.00", inv.Payment.Advances[0].Amount.String())
+		assert.Equal(t, pay.MeansKeyCard, inv.Payment.Advances[0].Key)
+		assert.Equal(t, "0.00", inv.Totals.Due.String())
+	})
+
+	t.Run("standard with tax ID and shipping", func(t *testing.T) {
+		cs := validStripeCheckoutSession()
+		cs.CustomerDetails.TaxIDs = []*stripe.CheckoutSessionCustomerDetailsTaxID{
+			{Type: stripe.CheckoutSessionCustomerDetailsTaxIDTypeEUVAT, Value: "DE282741168"},
+		}
+		cs.ShippingCost = &stripe.CheckoutSessionShippingCost{
+			AmountSubtotal: 500,
+			AmountTotal:    500,
+			ShippingRate:   &stripe.ShippingRate{DisplayName: "Express"},
+		}
+		cs.AmountTotal = 2500
+
+		inv, err := goblstripe.FromCheckoutSession(cs, account)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+
+		assert.False(t, inv.HasTags(tax.TagSimplified))
+		assert.Equal(t, "282741168", inv.Customer.TaxID.Code.String())
+		require.Len(t, inv.Lines, 2)
+		assert.Equal(t, "Express", inv.Lines[1].Item.Name)
+		assert.Equal(t, "25.00", inv.Totals.Payable.String())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := goblstripe.FromCheckoutSession(nil, account)
+		assert.ErrorContains(t, err, "missing checkout session")
+
+		cs := validStripeCheckoutSession()
+		cs.Invoice = &stripe.Invoice{ID: "in_1QkqKVQhcl5B85YlT32LIsNm"}
+		_, err = goblstripe.FromCheckoutSession(cs, account)
+		assert.ErrorContains(t, err, "use FromInvoice instead")
+
+		cs = validStripeCheckoutSession()
+		cs.PaymentStatus = stripe.CheckoutSessionPaymentStatusUnpaid
+		_, err = goblstripe.FromCheckoutSession(cs, account)
+		assert.ErrorContains(t, err, "has not been paid")
+
+		_, err = goblstripe.FromCheckoutSession(validStripeCheckoutSession(), nil)
+		assert.ErrorContains(t, err, "missing account country")
+	})
+}
@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/invopop/gobl"
 	goblstripe "github.com/invopop/gobl.stripe"
 	"github.com/invopop/gobl/bill"
 	"github.com/spf13/cobra"
 	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/form"
 )
 
 type convertOpts struct {
@@ -43,10 +46,6 @@ func (c *convertOpts) runE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("direction must be either 'from-stripe' or 'to-stripe'")
 	}
 
-	if c.direction == "to-stripe" {
-		return fmt.Errorf("conversion to Stripe format is not yet supported")
-	}
-
 	input, err := openInput(cmd, args)
 	if err != nil {
 		return err
@@ -58,7 +57,10 @@ func (c *convertOpts) runE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("reading input: %w", err)
 	}
 
-	// Currently only supporting Stripe to GOBL conversion
+	if c.direction == "to-stripe" {
+		return c.goblToStripe(inData)
+	}
+
 	return c.stripeToGobl(inData)
 }
 
@@ -108,3 +110,128 @@ func (c *convertOpts) stripeToGobl(data []byte) error {
 	// Write to output file
 	return saveJSON(goblInvoice)
 }
+
+// stripeRequests contains the Stripe API request params generated from a GOBL document,
+// each one form encoded as expected by the Stripe API so they can be replayed.
+type stripeRequests struct {
+	code         string
+	Customer     map[string]string   `json:"customer,omitempty"`
+	TaxRates     []map[string]string `json:"tax_rates,omitempty"`
+	Coupons      []map[string]string `json:"coupons,omitempty"`
+	Invoice      map[string]string   `json:"invoice,omitempty"`
+	InvoiceItems []map[string]string `json:"invoice_items,omitempty"`
+	CreditNote   map[string]string   `json:"credit_note,omitempty"`
+}
+
+func (c *convertOpts) goblToStripe(data []byte) error {
+	inv, err := parseGOBLInvoice(data)
+	if err != nil {
+		return err
+	}
+
+	if err := inv.Calculate(); err != nil {
+		return fmt.Errorf("failed to calculate GOBL invoice: %v", err)
+	}
+
+	reqs := &stripeRequests{
+		code: inv.Code.String(),
+	}
+	if reqs.code == "" {
+		reqs.code = inv.UUID.String()
+	}
+	if cus := goblstripe.ToCustomerParams(inv.Customer, goblstripe.WithSupplier(inv.Supplier)); cus != nil {
+		if cus.Shipping == nil && inv.Delivery != nil {
+			cus.Shipping = goblstripe.ToCustomerShippingParams(inv.Delivery.Receiver)
+		}
+		reqs.Customer = formParams(cus)
+	}
+
+	// Tax rates get their IDs from Stripe when created, so the items reference them by
+	// their position in the tax rates list instead.
+	taxRates := goblstripe.NewTaxRateRegistry(nil)
+	for i, params := range taxRates.Missing(inv) {
+		reqs.TaxRates = append(reqs.TaxRates, formParams(params))
+		taxRates.Add(taxRateFromParams(fmt.Sprintf("{{tax_rates.%d}}", i), params))
+	}
+
+	switch inv.Type {
+	case bill.InvoiceTypeCreditNote:
+		cn, err := goblstripe.ToCreditNote(inv, nil, goblstripe.WithTaxRateRegistry(taxRates))
+		if err != nil {
+			return fmt.Errorf("failed to convert to Stripe: %v", err)
+		}
+		reqs.CreditNote = formParams(cn)
+	default:
+		// Coupons use IDs derived from their terms, so they can be created before the
+		// invoice even if identical ones already exist.
+		for _, cp := range goblstripe.NewCouponRegistry(nil).Missing(inv) {
+			reqs.Coupons = append(reqs.Coupons, formParams(cp))
+		}
+		params, items, err := goblstripe.ToInvoice(inv, goblstripe.WithTaxRateRegistry(taxRates))
+		if err != nil {
+			return fmt.Errorf("failed to convert to Stripe: %v", err)
+		}
+		// The customer and the invoice also get their IDs from Stripe, so they are
+		// referenced with placeholders in the same way.
+		params.Customer = stripe.String("{{customer}}")
+		reqs.Invoice = formParams(params)
+		for _, item := range items {
+			item.Customer = stripe.String("{{customer}}")
+			item.Invoice = stripe.String("{{invoice}}")
+			reqs.InvoiceItems = append(reqs.InvoiceItems, formParams(item))
+		}
+	}
+
+	return saveJSON(reqs)
+}
+
+// taxRateFromParams builds the Stripe tax rate that creating the params would return,
+// with the given ID.
+func taxRateFromParams(id string, params *stripe.TaxRateParams) *stripe.TaxRate {
+	return &stripe.TaxRate{
+		ID:           id,
+		Active:       true,
+		Country:      stripe.StringValue(params.Country),
+		DisplayName:  stripe.StringValue(params.DisplayName),
+		Inclusive:    stripe.BoolValue(params.Inclusive),
+		Jurisdiction: stripe.StringValue(params.Jurisdiction),
+		Metadata:     params.Metadata,
+		Percentage:   stripe.Float64Value(params.Percentage),
+		State:        stripe.StringValue(params.State),
+		TaxType:      stripe.TaxRateTaxType(stripe.StringValue(params.TaxType)),
+	}
+}
+
+// parseGOBLInvoice reads a GOBL invoice either wrapped in an envelope or on its own.
+func parseGOBLInvoice(data []byte) (*bill.Invoice, error) {
+	obj, err := gobl.Parse(data)
+	if err != nil {
+		// Documents without a schema are assumed to be bare invoices.
+		inv := new(bill.Invoice)
+		if err := json.Unmarshal(data, inv); err != nil {
+			return nil, fmt.Errorf("failed to parse GOBL invoice: %v", err)
+		}
+		return inv, nil
+	}
+
+	if env, ok := obj.(*gobl.Envelope); ok {
+		obj = env.Extract()
+	}
+
+	inv, ok := obj.(*bill.Invoice)
+	if !ok {
+		return nil, fmt.Errorf("unsupported GOBL document: expected an invoice")
+	}
+	return inv, nil
+}
+
+// formParams encodes Stripe params into the flat key/value pairs sent to the Stripe API.
+func formParams(params interface{}) map[string]string {
+	values := &form.Values{}
+	form.AppendTo(values, params)
+	out := make(map[string]string)
+	for k, v := range values.ToValues() {
+		out[k] = strings.Join(v, ",")
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	stripefixture "github.com/invopop/gobl.stripe/fixture"
+	"github.com/invopop/gobl/bill"
+	"github.com/spf13/cobra"
+)
+
+type fixtureOpts struct {
+	*rootOpts
+}
+
+func fixture(o *rootOpts) *fixtureOpts {
+	return &fixtureOpts{rootOpts: o}
+}
+
+func (f *fixtureOpts) cmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fixture <infile>",
+		Short: "Generate a Stripe invoice or credit note fixture from a GOBL document",
+		Long:  "Generate the fully expanded Stripe invoice (or credit note) JSON object that results from creating a GOBL document in Stripe with manual tax rates, to use as a test fixture.",
+		RunE:  f.runE,
+	}
+
+	return cmd
+}
+
+func (f *fixtureOpts) runE(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 || len(args) > 1 {
+		return fmt.Errorf("expected only one argument, the command usage is `gobl.stripe fixture <infile>`")
+	}
+
+	input, err := openInput(cmd, args)
+	if err != nil {
+		return err
+	}
+	defer input.Close() // nolint:errcheck
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	inv, err := parseGOBLInvoice(data)
+	if err != nil {
+		return err
+	}
+	if err := inv.Calculate(); err != nil {
+		return fmt.Errorf("failed to calculate GOBL invoice: %v", err)
+	}
+
+	if inv.Type == bill.InvoiceTypeCreditNote {
+		doc, err := stripefixture.ToCreditNote(inv)
+		if err != nil {
+			return fmt.Errorf("failed to generate fixture: %v", err)
+		}
+		return saveJSON(doc)
+	}
+
+	doc, err := stripefixture.ToInvoice(inv)
+	if err != nil {
+		return fmt.Errorf("failed to generate fixture: %v", err)
+	}
+	return saveJSON(doc)
+}
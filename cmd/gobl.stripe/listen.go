@@ -28,6 +28,8 @@ type listenOpts struct {
 	stripeKey     string
 	webhookSecret string
 	convertToGOBL bool
+	// cancelUncollectible also cancels the invoices marked as uncollectible
+	cancelUncollectible bool
 	//directory string
 }
 
@@ -39,7 +41,7 @@ func (l *listenOpts) cmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "listen",
 		Short: "Listen to Stripe invoice/credit_note events",
-		Long:  "Listen to Stripe invoice/credit_note events, save them as Stripe Invoice/ Credit Note JSON and convert it to GOBL json",
+		Long:  "Listen to Stripe invoice/credit_note events, save them as Stripe Invoice/ Credit Note JSON and convert it to GOBL json. Paid invoices are converted into GOBL payment receipts.",
 		RunE:  l.runE,
 	}
 
@@ -47,6 +49,7 @@ func (l *listenOpts) cmd() *cobra.Command {
 	cmd.Flags().StringVarP(&l.stripeKey, "stripe-key", "k", " ", "Stripe secret key")
 	cmd.Flags().StringVarP(&l.webhookSecret, "webhook-secret", "s", " ", "Stripe webhook secret")
 	cmd.Flags().BoolVarP(&l.convertToGOBL, "convert", "c", true, "Convert Stripe invoices to GOBL format")
+	cmd.Flags().BoolVar(&l.cancelUncollectible, "cancel-uncollectible", false, "Cancel invoices marked as uncollectible with a GOBL credit note")
 
 	return cmd
 }
@@ -109,6 +112,16 @@ func (l *listenOpts) handleWebhook(w http.ResponseWriter, req *http.Request) {
 		l.processInvoice(w, event)
 	case "credit_note.created":
 		l.processCreditNote(w, event)
+	case "invoice.paid":
+		l.processPayment(w, event)
+	case "invoice.voided":
+		l.processVoidedInvoice(w, event)
+	case "invoice.marked_uncollectible":
+		if !l.cancelUncollectible {
+			log.Printf("Invoice marked as uncollectible not cancelled: %s\n", event.ID)
+			break
+		}
+		l.processVoidedInvoice(w, event)
 	default:
 		log.Printf("Unhandled event type: %s\n", event.Type)
 	}
@@ -181,6 +194,8 @@ func createInvoiceExpandParams() *stripe.InvoiceParams {
 	params.AddExpand("lines.data.price.product")
 	params.AddExpand("total_tax_amounts.tax_rate")
 	params.AddExpand("payment_intent")
+	params.AddExpand("quote")
+	params.AddExpand("from_invoice.invoice")
 	return params
 }
 
@@ -197,6 +212,115 @@ func convertInvoiceToGOBL(invoiceNew *stripe.Invoice) (*bill.Invoice, error) {
 	return gi, nil
 }
 
+// processVoidedInvoice saves an invoice that was voided or marked as uncollectible and
+// converts it into the GOBL credit note that cancels it. The invoice is saved with its
+// new status in the file name, so the invoice saved when it was finalized is kept.
+func (l *listenOpts) processVoidedInvoice(w http.ResponseWriter, event stripe.Event) {
+	var invoiceReceived stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &invoiceReceived); err != nil {
+		handleError(w, "Failed to parse invoice", err, http.StatusBadRequest)
+		return
+	}
+
+	params := createInvoiceExpandParams()
+
+	invoiceExpanded, err := invoice.Get(invoiceReceived.ID, params)
+	if err != nil {
+		handleError(w, "Failed to get invoice", err, http.StatusBadRequest)
+		return
+	}
+
+	filename := "stripe_" + invoiceExpanded.ID + "_" + string(invoiceExpanded.Status) + ".json"
+	if err := writeJSON(filename, "Stripe Invoice", invoiceExpanded); err != nil {
+		handleError(w, "Failed to save Stripe JSON", err, http.StatusInternalServerError)
+	}
+
+	if l.convertToGOBL {
+		gi, err := convertVoidedInvoiceToGOBL(invoiceExpanded)
+		if err != nil {
+			handleError(w, "Failed to convert voided invoice to GOBL", err, http.StatusInternalServerError)
+			return
+		}
+
+		if err := saveJSON(gi); err != nil {
+			handleError(w, "Failed to save GOBL JSON", err, http.StatusInternalServerError)
+		}
+	}
+}
+
+func convertVoidedInvoiceToGOBL(invoiceVoided *stripe.Invoice) (*bill.Invoice, error) {
+	// Uncollectible invoices only get here with --cancel-uncollectible
+	gi, err := goblstripe.FromVoidedInvoice(invoiceVoided, nil, goblstripe.WithUncollectible())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gi.Calculate(); err != nil {
+		return nil, err
+	}
+
+	return gi, nil
+}
+
+// processPayment saves the charge that paid an invoice and converts it into a GOBL
+// payment receipt. Invoices paid without a charge (e.g. out of band) are skipped.
+func (l *listenOpts) processPayment(w http.ResponseWriter, event stripe.Event) {
+	var invoiceReceived stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &invoiceReceived); err != nil {
+		handleError(w, "Failed to parse invoice", err, http.StatusBadRequest)
+		return
+	}
+
+	params := createInvoiceExpandParams()
+	params.AddExpand("charge")
+	params.AddExpand("customer.tax_ids")
+
+	invoiceExpanded, err := invoice.Get(invoiceReceived.ID, params)
+	if err != nil {
+		handleError(w, "Failed to get invoice", err, http.StatusBadRequest)
+		return
+	}
+
+	if invoiceExpanded.Charge == nil {
+		log.Printf("Invoice %s paid without a charge, no payment to convert\n", invoiceExpanded.ID)
+		return
+	}
+	charge := invoiceExpanded.Charge
+	charge.Invoice = invoiceExpanded
+	if charge.Customer == nil || charge.Customer.Created == 0 {
+		charge.Customer = invoiceExpanded.Customer
+	}
+
+	if err := saveJSON(charge); err != nil {
+		handleError(w, "Failed to save Stripe JSON", err, http.StatusInternalServerError)
+	}
+
+	if l.convertToGOBL {
+		pmt, err := convertChargeToGOBL(charge)
+		if err != nil {
+			handleError(w, "Failed to convert payment to GOBL", err, http.StatusInternalServerError)
+			return
+		}
+
+		if err := saveJSON(pmt); err != nil {
+			handleError(w, "Failed to save GOBL JSON", err, http.StatusInternalServerError)
+		}
+	}
+}
+
+func convertChargeToGOBL(charge *stripe.Charge) (*bill.Payment, error) {
+	pmt, err := goblstripe.FromCharge(charge, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pmt.Calculate(); err != nil {
+		return nil, err
+	}
+
+	return pmt, nil
+}
+
 func (l *listenOpts) processCreditNote(w http.ResponseWriter, event stripe.Event) {
 	var creditNoteReceived stripe.CreditNote
 	if err := json.Unmarshal(event.Data.Raw, &creditNoteReceived); err != nil {
@@ -57,10 +57,24 @@ func saveJSON(data interface{}) error {
 	case *stripe.CreditNote:
 		filename = "stripe_" + v.ID + ".json"
 		prefix = "Stripe Credit Note"
+	case *bill.Payment:
+		filename = "gobl_payment_" + v.Code.String() + ".json"
+		prefix = "GOBL Payment"
+	case *stripe.Charge:
+		filename = "stripe_" + v.ID + ".json"
+		prefix = "Stripe Charge"
+	case *stripeRequests:
+		filename = "stripe_params_" + v.code + ".json"
+		prefix = "Stripe Params"
 	default:
 		return fmt.Errorf("unsupported type for JSON saving")
 	}
 
+	return writeJSON(filename, prefix, data)
+}
+
+// writeJSON saves the data as indented JSON in the given file.
+func writeJSON(filename, prefix string, data interface{}) error {
 	fullJSON, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal %s: %v", prefix, err)
@@ -0,0 +1,620 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/uuid"
+	"github.com/spf13/cobra"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/client"
+	"github.com/stripe/stripe-go/v81/form"
+)
+
+type pushOpts struct {
+	*rootOpts
+	stripeKey string
+	apiBase   string
+	finalize  bool
+}
+
+func push(o *rootOpts) *pushOpts {
+	return &pushOpts{rootOpts: o}
+}
+
+func (p *pushOpts) cmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "push <infile>",
+		Short: "Create or update the Stripe objects of a GOBL invoice or credit note",
+		Long:  "Create or update the customer, products, prices, tax rates, coupons, invoice items and invoice (or credit note) of a GOBL document through the Stripe API. The GOBL UUID is stored in the Stripe metadata and used for the idempotency keys, so pushing the same document again never duplicates anything.",
+		RunE:  p.runE,
+	}
+
+	cmd.Flags().StringVarP(&p.stripeKey, "stripe-key", "k", "", "Stripe secret key (defaults to the STRIPE_SECRET_KEY environment variable)")
+	cmd.Flags().StringVar(&p.apiBase, "api-base", "", "Stripe API base URL, e.g. for a local stripe-mock (defaults to the STRIPE_API_BASE environment variable or the Stripe API)")
+	cmd.Flags().BoolVarP(&p.finalize, "finalize", "f", true, "Finalize the Stripe invoice once created")
+
+	return cmd
+}
+
+func (p *pushOpts) runE(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 || len(args) > 1 {
+		return fmt.Errorf("expected only one argument, the command usage is `gobl.stripe push <infile>`")
+	}
+
+	if err := p.loadConfig(); err != nil {
+		return err
+	}
+
+	input, err := openInput(cmd, args)
+	if err != nil {
+		return err
+	}
+	defer input.Close() // nolint:errcheck
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	inv, err := parseGOBLInvoice(data)
+	if err != nil {
+		return err
+	}
+	if err := inv.Calculate(); err != nil {
+		return fmt.Errorf("failed to calculate GOBL invoice: %v", err)
+	}
+	if inv.UUID.IsZero() {
+		return fmt.Errorf("the GOBL document must have a UUID to be pushed to Stripe")
+	}
+
+	ps := &pusher{
+		sc:       p.client(),
+		inv:      inv,
+		finalize: p.finalize,
+	}
+	if inv.Type == bill.InvoiceTypeCreditNote {
+		return ps.pushCreditNote()
+	}
+	return ps.pushInvoice()
+}
+
+// loadConfig loads the Stripe secret key and API base URL first from the arguments,
+// then from the environment variables
+func (p *pushOpts) loadConfig() error {
+	if p.stripeKey == "" {
+		p.stripeKey = os.Getenv("STRIPE_SECRET_KEY")
+		if p.stripeKey == "" {
+			return fmt.Errorf("stripe secret key must be provided as an argument or in the STRIPE_SECRET_KEY environment variable")
+		}
+	}
+	if p.apiBase == "" {
+		p.apiBase = os.Getenv("STRIPE_API_BASE")
+	}
+	if p.apiBase == "" {
+		p.apiBase = stripe.APIURL
+	}
+	return nil
+}
+
+// client creates a Stripe API client that sends all the requests to the API base URL.
+func (p *pushOpts) client() *client.API {
+	backend := stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL: stripe.String(strings.TrimSuffix(p.apiBase, "/")),
+	})
+	return client.New(p.stripeKey, &stripe.Backends{
+		API:     backend,
+		Connect: backend,
+		Uploads: backend,
+	})
+}
+
+// pusher sends the Stripe objects of a single GOBL document to the Stripe API.
+type pusher struct {
+	sc       *client.API
+	inv      *bill.Invoice
+	finalize bool
+}
+
+func (ps *pusher) pushInvoice() error {
+	inv := ps.inv
+
+	cus, err := ps.upsertCustomer()
+	if err != nil {
+		return err
+	}
+
+	existing, err := ps.findInvoice(cus)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.Status != stripe.InvoiceStatusDraft {
+		log.Printf("GOBL invoice %s already pushed as Stripe invoice %s (%s)\n", inv.UUID, existing.ID, existing.Status)
+		return nil
+	}
+
+	taxRates, err := ps.taxRateRegistry()
+	if err != nil {
+		return err
+	}
+
+	coupons, err := ps.couponRegistry()
+	if err != nil {
+		return err
+	}
+
+	params, items, err := goblstripe.ToInvoice(inv,
+		goblstripe.WithTaxRateRegistry(taxRates),
+		goblstripe.WithCouponRegistry(coupons),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to convert to Stripe: %v", err)
+	}
+
+	if err := ps.setItemPrices(items); err != nil {
+		return err
+	}
+
+	var doc *stripe.Invoice
+	if existing != nil {
+		doc, err = ps.updateDraftInvoice(existing, params)
+	} else {
+		doc, err = ps.createInvoice(cus, params)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, item := range items {
+		item.Customer = stripe.String(cus.ID)
+		item.Invoice = stripe.String(doc.ID)
+		if existing == nil {
+			item.SetIdempotencyKey(ps.idempotencyKey("invoice-item", fmt.Sprint(i)))
+		}
+		if _, err := ps.sc.InvoiceItems.New(item); err != nil {
+			return fmt.Errorf("failed to create Stripe invoice item: %w", err)
+		}
+	}
+
+	if ps.finalize {
+		fp := &stripe.InvoiceFinalizeInvoiceParams{}
+		fp.SetIdempotencyKey(ps.idempotencyKey("finalize", doc.ID))
+		doc, err = ps.sc.Invoices.FinalizeInvoice(doc.ID, fp)
+		if err != nil {
+			return fmt.Errorf("failed to finalize Stripe invoice: %w", err)
+		}
+	}
+
+	log.Printf("GOBL invoice %s pushed as Stripe invoice %s (%s)\n", inv.UUID, doc.ID, doc.Status)
+	return nil
+}
+
+// findInvoice looks for a Stripe invoice of the customer created from the same GOBL
+// document. Unlike search, listing always includes the invoices just created.
+func (ps *pusher) findInvoice(cus *stripe.Customer) (*stripe.Invoice, error) {
+	iter := ps.sc.Invoices.List(&stripe.InvoiceListParams{Customer: stripe.String(cus.ID)})
+	for iter.Next() {
+		if doc := iter.Invoice(); doc.Metadata[goblstripe.MetaKeyGOBLUUID] == ps.inv.UUID.String() {
+			return doc, nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list Stripe invoices: %w", err)
+	}
+	return nil, nil
+}
+
+func (ps *pusher) createInvoice(cus *stripe.Customer, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	params.Customer = stripe.String(cus.ID)
+	params.AutoAdvance = stripe.Bool(false)
+	params.PendingInvoiceItemsBehavior = stripe.String("exclude")
+	params.SetIdempotencyKey(ps.idempotencyKey("invoice"))
+	doc, err := ps.sc.Invoices.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Stripe invoice: %w", err)
+	}
+	return doc, nil
+}
+
+// updateDraftInvoice updates a draft invoice pushed before and removes its items, so
+// they can be replaced by the current ones.
+func (ps *pusher) updateDraftInvoice(doc *stripe.Invoice, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	params.Currency = nil // Can't be changed once created
+	doc, err := ps.sc.Invoices.Update(doc.ID, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update Stripe invoice: %w", err)
+	}
+
+	lp := &stripe.InvoiceItemListParams{Invoice: stripe.String(doc.ID)}
+	iter := ps.sc.InvoiceItems.List(lp)
+	for iter.Next() {
+		if _, err := ps.sc.InvoiceItems.Del(iter.InvoiceItem().ID, nil); err != nil {
+			return nil, fmt.Errorf("failed to delete Stripe invoice item: %w", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list Stripe invoice items: %w", err)
+	}
+	return doc, nil
+}
+
+// upsertCustomer creates the customer of the document, or updates the one created from
+// the same GOBL party before.
+func (ps *pusher) upsertCustomer() (*stripe.Customer, error) {
+	params := goblstripe.ToCustomerParams(ps.inv.Customer, goblstripe.WithSupplier(ps.inv.Supplier))
+	if params == nil {
+		return nil, fmt.Errorf("the GOBL invoice must have a customer to be pushed to Stripe")
+	}
+	if params.Shipping == nil && ps.inv.Delivery != nil {
+		params.Shipping = goblstripe.ToCustomerShippingParams(ps.inv.Delivery.Receiver)
+	}
+	key, err := customerKey(ps.inv.Customer)
+	if err != nil {
+		return nil, err
+	}
+	params.Metadata[goblstripe.MetaKeyGOBLUUID] = key
+
+	cus, err := ps.findCustomer(key, stripe.StringValue(params.Email))
+	if err != nil {
+		return nil, err
+	}
+
+	if cus == nil {
+		// Keyed on the customer, not the document, so pushing several documents of a new
+		// customer at once only creates it once.
+		params.SetIdempotencyKey(strings.Join([]string{"gobl", key, "customer", paramsHash(params)}, "-"))
+		cus, err := ps.sc.Customers.New(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Stripe customer: %w", err)
+		}
+		return cus, nil
+	}
+
+	// Tax IDs can't be updated, only added to the customer.
+	taxIDs := params.TaxIDData
+	params.TaxIDData = nil
+	cus, err = ps.sc.Customers.Update(cus.ID, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update Stripe customer: %w", err)
+	}
+	for _, tID := range taxIDs {
+		if customerHasTaxID(cus, tID) {
+			continue
+		}
+		_, err := ps.sc.TaxIDs.New(&stripe.TaxIDParams{
+			Customer: stripe.String(cus.ID),
+			Type:     tID.Type,
+			Value:    tID.Value,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add Stripe customer tax ID: %w", err)
+		}
+	}
+	return cus, nil
+}
+
+// findCustomer looks for the Stripe customer with the given GOBL customer key in its
+// metadata. Customers are listed by email, which always includes the ones just created,
+// and only searched by metadata when there is no email. Search results can lag behind
+// by a few seconds, which the idempotency key of the customer creation covers.
+func (ps *pusher) findCustomer(key, email string) (*stripe.Customer, error) {
+	if email != "" {
+		iter := ps.sc.Customers.List(&stripe.CustomerListParams{Email: stripe.String(email)})
+		for iter.Next() {
+			if c := iter.Customer(); c.Metadata[goblstripe.MetaKeyGOBLUUID] == key {
+				return c, nil
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list Stripe customers: %w", err)
+		}
+		return nil, nil
+	}
+
+	sp := &stripe.CustomerSearchParams{}
+	sp.Query = fmt.Sprintf("metadata['%s']:'%s'", goblstripe.MetaKeyGOBLUUID, key)
+	iter := ps.sc.Customers.Search(sp)
+	for iter.Next() {
+		if c := iter.Customer(); c.Metadata[goblstripe.MetaKeyGOBLUUID] == key {
+			return c, nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search Stripe customers: %w", err)
+	}
+	return nil, nil
+}
+
+// customerNamespace is the UUID namespace of the customer keys derived from tax IDs.
+const customerNamespace uuid.UUID = "4f6a1b2e-8c3d-5e7f-9a0b-1c2d3e4f5a6b"
+
+// customerKey returns the UUID that identifies a GOBL customer in Stripe: the party's
+// own UUID or, when it has none, one derived from its tax ID, so the same customer is
+// found again from any of its documents.
+func customerKey(party *org.Party) (string, error) {
+	if !party.UUID.IsZero() {
+		return party.UUID.String(), nil
+	}
+	if party.TaxID != nil && party.TaxID.Code != "" {
+		return uuid.V5(customerNamespace, []byte(party.TaxID.String())).String(), nil
+	}
+	return "", fmt.Errorf("the GOBL customer must have a UUID or a tax ID to be pushed to Stripe")
+}
+
+func customerHasTaxID(cus *stripe.Customer, tID *stripe.CustomerTaxIDDataParams) bool {
+	if cus.TaxIDs == nil {
+		return false
+	}
+	for _, t := range cus.TaxIDs.Data {
+		if string(t.Type) == stripe.StringValue(tID.Type) && t.Value == stripe.StringValue(tID.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// taxRateRegistry loads the active tax rates of the account and creates the ones
+// missing for the document.
+func (ps *pusher) taxRateRegistry() (*goblstripe.TaxRateRegistry, error) {
+	reg := goblstripe.NewTaxRateRegistry(nil)
+	iter := ps.sc.TaxRates.List(&stripe.TaxRateListParams{Active: stripe.Bool(true)})
+	for iter.Next() {
+		reg.Add(iter.TaxRate())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list Stripe tax rates: %w", err)
+	}
+
+	for _, params := range reg.Missing(ps.inv) {
+		params.SetIdempotencyKey(ps.idempotencyKey("tax-rate", paramsHash(params)))
+		rate, err := ps.sc.TaxRates.New(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Stripe tax rate: %w", err)
+		}
+		reg.Add(rate)
+	}
+	return reg, nil
+}
+
+// couponRegistry loads the coupons of the account and creates the ones missing for
+// the document discounts.
+func (ps *pusher) couponRegistry() (*goblstripe.CouponRegistry, error) {
+	reg := goblstripe.NewCouponRegistry(nil)
+	iter := ps.sc.Coupons.List(&stripe.CouponListParams{})
+	for iter.Next() {
+		reg.Add(iter.Coupon())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list Stripe coupons: %w", err)
+	}
+
+	for _, params := range reg.Missing(ps.inv) {
+		params.SetIdempotencyKey(ps.idempotencyKey("coupon", stripe.StringValue(params.ID)))
+		c, err := ps.sc.Coupons.New(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Stripe coupon: %w", err)
+		}
+		reg.Add(c)
+	}
+	return reg, nil
+}
+
+// setItemPrices creates or updates the products and prices of the lines with an item
+// reference, and uses those prices on the invoice items billed per unit.
+func (ps *pusher) setItemPrices(items []*stripe.InvoiceItemParams) error {
+	i := 0
+	for _, line := range ps.inv.Lines {
+		if line == nil || line.Item == nil {
+			continue
+		}
+		item := items[i]
+		i += 1 + lineChargeItems(line) // Line charges follow the item of their line
+		if line.Item.Ref == "" {
+			continue
+		}
+		if err := ps.upsertProduct(line.Item); err != nil {
+			return err
+		}
+		pp := goblstripe.ToPriceParams(line.Item, ps.inv.Currency, pricesInclude(ps.inv))
+		if !samePrice(pp, item) {
+			// Lump sum lines don't use the unit price of the item.
+			continue
+		}
+		price, err := ps.upsertPrice(pp)
+		if err != nil {
+			return err
+		}
+		item.Price = stripe.String(price.ID)
+		item.Currency = nil
+		item.UnitAmount = nil
+		item.UnitAmountDecimal = nil
+	}
+	return nil
+}
+
+// lineChargeItems returns the number of invoice items added for the charges of a line.
+func lineChargeItems(line *bill.Line) int {
+	n := 0
+	for _, c := range line.Charges {
+		if c != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (ps *pusher) upsertProduct(item *org.Item) error {
+	params := goblstripe.ToProductParams(item)
+	_, err := ps.sc.Products.Get(item.Ref.String(), nil)
+	if isNotFound(err) {
+		params.SetIdempotencyKey(ps.idempotencyKey("product", item.Ref.String()))
+		if _, err := ps.sc.Products.New(params); err != nil {
+			return fmt.Errorf("failed to create Stripe product: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get Stripe product: %w", err)
+	}
+	params.ID = nil
+	if _, err := ps.sc.Products.Update(item.Ref.String(), params); err != nil {
+		return fmt.Errorf("failed to update Stripe product: %w", err)
+	}
+	return nil
+}
+
+// upsertPrice returns the active price with the same lookup key and amount, or creates
+// a new one that takes over the lookup key.
+func (ps *pusher) upsertPrice(params *stripe.PriceParams) (*stripe.Price, error) {
+	lp := &stripe.PriceListParams{
+		Active:     stripe.Bool(true),
+		LookupKeys: []*string{params.LookupKey},
+	}
+	iter := ps.sc.Prices.List(lp)
+	for iter.Next() {
+		if p := iter.Price(); priceMatches(p, params) {
+			return p, nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list Stripe prices: %w", err)
+	}
+
+	params.SetIdempotencyKey(ps.idempotencyKey("price", paramsHash(params)))
+	p, err := ps.sc.Prices.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Stripe price: %w", err)
+	}
+	return p, nil
+}
+
+func (ps *pusher) pushCreditNote() error {
+	cn := ps.inv
+
+	original, err := ps.findOriginalInvoice()
+	if err != nil {
+		return err
+	}
+
+	lp := &stripe.CreditNoteListParams{Invoice: stripe.String(original.ID)}
+	iter := ps.sc.CreditNotes.List(lp)
+	for iter.Next() {
+		if doc := iter.CreditNote(); doc.Metadata[goblstripe.MetaKeyGOBLUUID] == cn.UUID.String() {
+			log.Printf("GOBL credit note %s already pushed as Stripe credit note %s\n", cn.UUID, doc.ID)
+			return nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to list Stripe credit notes: %w", err)
+	}
+
+	taxRates, err := ps.taxRateRegistry()
+	if err != nil {
+		return err
+	}
+
+	params, err := goblstripe.ToCreditNote(cn, original, goblstripe.WithTaxRateRegistry(taxRates))
+	if err != nil {
+		return fmt.Errorf("failed to convert to Stripe: %v", err)
+	}
+	params.SetIdempotencyKey(ps.idempotencyKey("credit-note"))
+	doc, err := ps.sc.CreditNotes.New(params)
+	if err != nil {
+		return fmt.Errorf("failed to create Stripe credit note: %w", err)
+	}
+
+	log.Printf("GOBL credit note %s pushed as Stripe credit note %s\n", cn.UUID, doc.ID)
+	return nil
+}
+
+// findOriginalInvoice looks for the Stripe invoice referenced by the credit note, either
+// by its Stripe ID or by its number.
+func (ps *pusher) findOriginalInvoice() (*stripe.Invoice, error) {
+	for _, ref := range ps.inv.Preceding {
+		if ref == nil {
+			continue
+		}
+		if strings.HasPrefix(ref.Code.String(), goblstripe.StripeInvoiceIDPrefix) {
+			doc, err := ps.sc.Invoices.Get(ref.Code.String(), nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get Stripe invoice: %w", err)
+			}
+			return doc, nil
+		}
+		number := ref.Code.String()
+		if ref.Series != "" {
+			number = ref.Series.String() + "-" + number
+		}
+		sp := &stripe.InvoiceSearchParams{}
+		sp.Query = fmt.Sprintf("number:'%s'", number)
+		iter := ps.sc.Invoices.Search(sp)
+		for iter.Next() {
+			if doc := iter.Invoice(); doc.Number == number {
+				return doc, nil
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to search Stripe invoices: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("no Stripe invoice found for the preceding documents of the credit note")
+}
+
+// idempotencyKey builds the idempotency key of a request from the GOBL UUID, so the
+// same request is never applied twice for the same document.
+func (ps *pusher) idempotencyKey(parts ...string) string {
+	return strings.Join(append([]string{"gobl", ps.inv.UUID.String()}, parts...), "-")
+}
+
+// paramsHash returns a short hash of the form encoded params.
+func paramsHash(params stripe.ParamsContainer) string {
+	values := &form.Values{}
+	form.AppendTo(values, params)
+	sum := sha256.Sum256([]byte(values.Encode()))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func pricesInclude(inv *bill.Invoice) cbc.Code {
+	if inv.Tax == nil {
+		return ""
+	}
+	return inv.Tax.PricesInclude
+}
+
+// samePrice checks if the invoice item is billed at the unit amount of the price.
+func samePrice(pp *stripe.PriceParams, item *stripe.InvoiceItemParams) bool {
+	if pp.UnitAmount != nil || item.UnitAmount != nil {
+		return stripe.Int64Value(pp.UnitAmount) == stripe.Int64Value(item.UnitAmount) && item.UnitAmount != nil
+	}
+	return stripe.Float64Value(pp.UnitAmountDecimal) == stripe.Float64Value(item.UnitAmountDecimal)
+}
+
+// priceMatches checks if an existing Stripe price has the same terms as the params.
+func priceMatches(p *stripe.Price, params *stripe.PriceParams) bool {
+	if !strings.EqualFold(string(p.Currency), stripe.StringValue(params.Currency)) {
+		return false
+	}
+	if string(p.TaxBehavior) != stripe.StringValue(params.TaxBehavior) {
+		return false
+	}
+	if params.UnitAmount != nil {
+		return p.UnitAmount == *params.UnitAmount
+	}
+	return p.UnitAmountDecimal == stripe.Float64Value(params.UnitAmountDecimal)
+}
+
+func isNotFound(err error) bool {
+	var serr *stripe.Error
+	return errors.As(err, &serr) && serr.HTTPStatusCode == http.StatusNotFound
+}
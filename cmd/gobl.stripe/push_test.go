@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/client"
+	"github.com/stripe/stripe-go/v81/form"
+)
+
+// stubBackend is a Stripe API backend that keeps the objects of the account in memory
+// and records the requests sent to it, along with their idempotency keys.
+type stubBackend struct {
+	requests  []string
+	keys      map[string]string
+	customers []*stripe.Customer
+	invoices  []*stripe.Invoice
+	items     []*stripe.InvoiceItem
+	taxRates  []*stripe.TaxRate
+}
+
+func newStubBackend() *stubBackend {
+	return &stubBackend{keys: make(map[string]string)}
+}
+
+func (b *stubBackend) Call(method, path, _ string, params stripe.ParamsContainer, v stripe.LastResponseSetter) error {
+	req := method + " " + path
+	b.requests = append(b.requests, req)
+	if method == "DELETE" {
+		// Deletions are sent without params.
+		return b.deleteItem(path)
+	}
+	if p := params.GetParams(); p.IdempotencyKey != nil {
+		b.keys[req] = *p.IdempotencyKey
+	}
+
+	switch {
+	case req == "POST /v1/customers":
+		cp := params.(*stripe.CustomerParams)
+		cus := &stripe.Customer{
+			ID:       fmt.Sprintf("cus_%d", len(b.customers)+1),
+			Email:    stripe.StringValue(cp.Email),
+			Metadata: cp.Metadata,
+			TaxIDs:   &stripe.TaxIDList{},
+		}
+		for _, tID := range cp.TaxIDData {
+			cus.TaxIDs.Data = append(cus.TaxIDs.Data, &stripe.TaxID{
+				Type:  stripe.TaxIDType(stripe.StringValue(tID.Type)),
+				Value: stripe.StringValue(tID.Value),
+			})
+		}
+		b.customers = append(b.customers, cus)
+		*v.(*stripe.Customer) = *cus
+	case strings.HasPrefix(req, "POST /v1/customers/"):
+		cus := b.customer(strings.TrimPrefix(path, "/v1/customers/"))
+		if cus == nil {
+			return fmt.Errorf("no such customer: %s", path)
+		}
+		*v.(*stripe.Customer) = *cus
+	case req == "POST /v1/invoiceitems":
+		ip := params.(*stripe.InvoiceItemParams)
+		b.items = append(b.items, &stripe.InvoiceItem{
+			ID:      fmt.Sprintf("ii_%d", len(b.items)+1),
+			Invoice: &stripe.Invoice{ID: stripe.StringValue(ip.Invoice)},
+		})
+	case req == "POST /v1/tax_rates":
+		tp := params.(*stripe.TaxRateParams)
+		rate := &stripe.TaxRate{
+			ID:          fmt.Sprintf("txr_%d", len(b.taxRates)+1),
+			Active:      true,
+			Country:     stripe.StringValue(tp.Country),
+			DisplayName: stripe.StringValue(tp.DisplayName),
+			Inclusive:   stripe.BoolValue(tp.Inclusive),
+			Metadata:    tp.Metadata,
+			Percentage:  stripe.Float64Value(tp.Percentage),
+			TaxType:     stripe.TaxRateTaxType(stripe.StringValue(tp.TaxType)),
+		}
+		b.taxRates = append(b.taxRates, rate)
+		*v.(*stripe.TaxRate) = *rate
+	case req == "POST /v1/invoices":
+		ip := params.(*stripe.InvoiceParams)
+		doc := &stripe.Invoice{
+			ID:       fmt.Sprintf("in_%d", len(b.invoices)+1),
+			Customer: &stripe.Customer{ID: stripe.StringValue(ip.Customer)},
+			Metadata: ip.Metadata,
+			Status:   stripe.InvoiceStatusDraft,
+		}
+		b.invoices = append(b.invoices, doc)
+		*v.(*stripe.Invoice) = *doc
+	case strings.HasSuffix(req, "/finalize"):
+		doc := b.invoice(strings.TrimSuffix(strings.TrimPrefix(path, "/v1/invoices/"), "/finalize"))
+		if doc == nil {
+			return fmt.Errorf("no such invoice: %s", path)
+		}
+		doc.Status = stripe.InvoiceStatusOpen
+		*v.(*stripe.Invoice) = *doc
+	case strings.HasPrefix(req, "POST /v1/invoices/"):
+		doc := b.invoice(strings.TrimPrefix(path, "/v1/invoices/"))
+		if doc == nil {
+			return fmt.Errorf("no such invoice: %s", path)
+		}
+		*v.(*stripe.Invoice) = *doc
+	default:
+		return fmt.Errorf("unexpected request: %s", req)
+	}
+	return nil
+}
+
+func (b *stubBackend) CallRaw(method, path, _ string, _ *form.Values, _ *stripe.Params, v stripe.LastResponseSetter) error {
+	req := method + " " + path
+	b.requests = append(b.requests, req)
+	switch req {
+	case "GET /v1/customers":
+		v.(*stripe.CustomerList).Data = b.customers
+	case "GET /v1/customers/search":
+		v.(*stripe.CustomerSearchResult).Data = b.customers
+	case "GET /v1/invoices":
+		v.(*stripe.InvoiceList).Data = b.invoices
+	case "GET /v1/invoiceitems":
+		v.(*stripe.InvoiceItemList).Data = b.items
+	case "GET /v1/tax_rates":
+		v.(*stripe.TaxRateList).Data = b.taxRates
+	case "GET /v1/coupons":
+	default:
+		return fmt.Errorf("unexpected request: %s", req)
+	}
+	return nil
+}
+
+func (b *stubBackend) CallStreaming(_, _, _ string, _ stripe.ParamsContainer, _ stripe.StreamingLastResponseSetter) error {
+	return fmt.Errorf("streaming not supported")
+}
+
+func (b *stubBackend) CallMultipart(_, _, _, _ string, _ *bytes.Buffer, _ *stripe.Params, _ stripe.LastResponseSetter) error {
+	return fmt.Errorf("multipart not supported")
+}
+
+func (b *stubBackend) SetMaxNetworkRetries(_ int64) {}
+
+func (b *stubBackend) customer(id string) *stripe.Customer {
+	for _, c := range b.customers {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (b *stubBackend) invoice(id string) *stripe.Invoice {
+	for _, doc := range b.invoices {
+		if doc.ID == id {
+			return doc
+		}
+	}
+	return nil
+}
+
+func (b *stubBackend) deleteItem(path string) error {
+	id := strings.TrimPrefix(path, "/v1/invoiceitems/")
+	for i, item := range b.items {
+		if item.ID == id {
+			b.items = append(b.items[:i], b.items[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no such invoice item: %s", id)
+}
+
+// count returns the number of requests sent with the given method and path.
+func (b *stubBackend) count(req string) int {
+	n := 0
+	for _, r := range b.requests {
+		if r == req {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestPusher(b *stubBackend, inv *bill.Invoice) *pusher {
+	sc := client.New("sk_test_123", &stripe.Backends{API: b, Connect: b, Uploads: b})
+	return &pusher{sc: sc, inv: inv, finalize: true}
+}
+
+func pushTestInvoice(t *testing.T) *bill.Invoice {
+	t.Helper()
+	inv := &bill.Invoice{
+		Regime:   tax.WithRegime("DE"),
+		Series:   "SAMPLE",
+		Code:     "0001",
+		Currency: currency.EUR,
+		Supplier: &org.Party{
+			Name:  "Test Account",
+			TaxID: &tax.Identity{Country: "DE", Code: "813495425"},
+		},
+		Customer: &org.Party{
+			Name:   "Test Customer",
+			TaxID:  &tax.Identity{Country: "DE", Code: "282741168"},
+			Emails: []*org.Email{{Address: "billing@example.com"}},
+		},
+		IssueDate: cal.MakeDate(2025, 1, 15),
+		Lines: []*bill.Line{
+			{
+				Quantity: num.MakeAmount(2, 0),
+				Item: &org.Item{
+					Name:  "Pro Plan",
+					Price: num.NewAmount(5000, 2),
+				},
+				Taxes: tax.Set{
+					{Category: tax.CategoryVAT, Rate: tax.RateGeneral},
+				},
+			},
+		},
+	}
+	inv.UUID = uuid.V7()
+	require.NoError(t, inv.Calculate())
+	return inv
+}
+
+func TestPushInvoice(t *testing.T) {
+	t.Run("create", func(t *testing.T) {
+		b := newStubBackend()
+		inv := pushTestInvoice(t)
+		require.NoError(t, newTestPusher(b, inv).pushInvoice())
+
+		require.Len(t, b.customers, 1)
+		require.Len(t, b.invoices, 1)
+		assert.Len(t, b.items, 1)
+		assert.Len(t, b.taxRates, 1)
+		assert.Equal(t, inv.UUID.String(), b.invoices[0].Metadata[goblstripe.MetaKeyGOBLUUID])
+		assert.Equal(t, stripe.InvoiceStatusOpen, b.invoices[0].Status)
+		assert.Equal(t, "gobl-"+inv.UUID.String()+"-invoice", b.keys["POST /v1/invoices"])
+		assert.Equal(t, 0, b.count("GET /v1/customers/search"), "customers with an email are listed, not searched")
+	})
+
+	t.Run("update draft", func(t *testing.T) {
+		b := newStubBackend()
+		inv := pushTestInvoice(t)
+		p := newTestPusher(b, inv)
+		p.finalize = false
+		require.NoError(t, p.pushInvoice())
+		require.NoError(t, p.pushInvoice())
+
+		assert.Len(t, b.customers, 1)
+		assert.Len(t, b.invoices, 1)
+		assert.Len(t, b.items, 1, "the items of the draft are replaced")
+		assert.Equal(t, 1, b.count("POST /v1/invoices"))
+		assert.Equal(t, 1, b.count("POST /v1/invoices/in_1"))
+		assert.Equal(t, 1, b.count("DELETE /v1/invoiceitems/ii_1"))
+		assert.Equal(t, 1, b.count("POST /v1/customers/cus_1"))
+	})
+
+	t.Run("already exists", func(t *testing.T) {
+		b := newStubBackend()
+		inv := pushTestInvoice(t)
+		require.NoError(t, newTestPusher(b, inv).pushInvoice())
+		created := len(b.requests)
+		require.NoError(t, newTestPusher(b, inv).pushInvoice())
+
+		assert.Len(t, b.invoices, 1)
+		assert.Len(t, b.items, 1)
+		for _, req := range b.requests[created:] {
+			assert.False(t, strings.HasPrefix(req, "POST /v1/invoice"), "unexpected request %s", req)
+		}
+	})
+
+	t.Run("same customer from another document", func(t *testing.T) {
+		b := newStubBackend()
+		for range 2 {
+			inv := pushTestInvoice(t)
+			inv.Customer.Emails = nil
+			require.NoError(t, newTestPusher(b, inv).pushInvoice())
+		}
+
+		assert.Len(t, b.customers, 1)
+		assert.Len(t, b.invoices, 2)
+		assert.Equal(t, 2, b.count("GET /v1/customers/search"))
+	})
+}
+
+func TestCustomerKey(t *testing.T) {
+	t.Run("party UUID", func(t *testing.T) {
+		party := &org.Party{Name: "Test Customer"}
+		party.UUID = uuid.V7()
+		key, err := customerKey(party)
+		require.NoError(t, err)
+		assert.Equal(t, party.UUID.String(), key)
+	})
+
+	t.Run("tax ID", func(t *testing.T) {
+		newParty := func() *org.Party {
+			return &org.Party{
+				Name:  "Test Customer",
+				TaxID: &tax.Identity{Country: "DE", Code: "282741168"},
+			}
+		}
+		a, err := customerKey(newParty())
+		require.NoError(t, err)
+		b, err := customerKey(newParty())
+		require.NoError(t, err)
+		assert.Equal(t, a, b)
+	})
+
+	t.Run("no stable key", func(t *testing.T) {
+		_, err := customerKey(&org.Party{Name: "Test Customer"})
+		assert.ErrorContains(t, err, "must have a UUID or a tax ID")
+	})
+}
@@ -24,6 +24,8 @@ func (o *rootOpts) cmd() *cobra.Command {
 	cmd.AddCommand(versionCmd())
 	cmd.AddCommand(listen(o).cmd())
 	cmd.AddCommand(convert(o).cmd())
+	cmd.AddCommand(push(o).cmd())
+	cmd.AddCommand(fixture(o).cmd())
 
 	return cmd
 }
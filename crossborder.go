@@ -0,0 +1,179 @@
+package goblstripe
+
+import (
+	"strings"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/catalogues/cef"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// Legal notes required when supplying goods without VAT to another member state or
+// outside the EU.
+const (
+	intraCommunityNote = "Intra-community supply exempt under Article 138 of Directive 2006/112/EC."
+	exportNote         = "Export exempt under Article 146 of Directive 2006/112/EC."
+)
+
+// crossBorderNotes maps the GOBL tax keys of cross-border supplies to the legal note
+// that justifies them.
+var crossBorderNotes = map[cbc.Key]string{
+	tax.KeyIntraCommunity: intraCommunityNote,
+	tax.KeyExport:         exportNote,
+}
+
+// itemKeyFromProduct returns the GOBL item key of a Stripe product from its tax code:
+// tangible goods codes (txcd_3xxxxxxx and the general txcd_99999999) map to goods,
+// while digital products (txcd_1xxxxxxx) and services (txcd_2xxxxxxx) map to services.
+// Products without a tax code, or with any other code (e.g. nontaxable), get no key.
+func itemKeyFromProduct(prod *stripe.Product) cbc.Key {
+	if prod == nil || prod.TaxCode == nil {
+		return ""
+	}
+	code := prod.TaxCode.ID
+	switch {
+	case code == "txcd_99999999", strings.HasPrefix(code, "txcd_3"):
+		return org.ItemKeyGoods
+	case strings.HasPrefix(code, "txcd_1"), strings.HasPrefix(code, "txcd_2"):
+		return org.ItemKeyServices
+	}
+	return ""
+}
+
+// applyCrossBorderKeys sets the GOBL tax keys of the untaxed VAT combos of an invoice
+// issued by an EU supplier to a customer in another country. Goods supplied to a
+// business in another member state are intra-community supplies, goods shipped outside
+// the EU are exports, services supplied to a business in another member state are
+// reverse charges, and services supplied outside the EU are outside the scope of EU
+// VAT, unless Stripe already reported them as reverse charges. The
+// customer's country is taken from its address, or else from its tax ID, and lines
+// are goods when the item key says so (see itemKeyFromProduct). The combos take the
+// supplier's country and the VATEX exemption code, and the reverse-charge tag and
+// legal notes are updated to match the keys of the lines.
+func applyCrossBorderKeys(inv *bill.Invoice, regimeDef *tax.RegimeDef) {
+	country := customerCountry(inv.Customer)
+	if regimeDef == nil || country == "" {
+		return
+	}
+	b2b := inv.Customer.TaxID != nil && inv.Customer.TaxID.Code != ""
+
+	changed := false
+	for _, line := range inv.Lines {
+		goods := line.Item != nil && line.Item.Key == org.ItemKeyGoods
+		key := crossBorderKey(regimeDef, country, b2b, goods)
+		if key == "" {
+			continue
+		}
+		for _, tc := range line.Taxes {
+			if tc.Category != tax.CategoryVAT || !isUntaxedCombo(tc) || tc.Key == key {
+				continue
+			}
+			if key == tax.KeyOutsideScope && tc.Key == tax.KeyReverseCharge {
+				continue
+			}
+			tc.Key = key
+			tc.Country = regimeDef.Country
+			tc.Rate = ""
+			tc.Percent = nil
+			tc.Ext = tc.Ext.Delete(cef.ExtKeyVATEX)
+			if code := taxExemptionCode(nil, key, tc.Country); code != "" {
+				tc.Ext = tc.Ext.Set(cef.ExtKeyVATEX, code)
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	keys := make(map[cbc.Key]bool)
+	for _, line := range inv.Lines {
+		for _, tc := range line.Taxes {
+			keys[tc.Key] = true
+		}
+	}
+	if keys[tax.KeyReverseCharge] {
+		if !inv.HasTags(tax.TagReverseCharge) {
+			inv.Tags.List = append(inv.Tags.List, tax.TagReverseCharge)
+		}
+	} else {
+		inv.Tags.RemoveTags(tax.TagReverseCharge)
+	}
+	for _, key := range []cbc.Key{tax.KeyIntraCommunity, tax.KeyExport} {
+		if keys[key] && !hasNoteFrom(inv.Notes, key) {
+			inv.Notes = append(inv.Notes, &org.Note{
+				Key:  org.NoteKeyLegal,
+				Src:  key,
+				Text: crossBorderNotes[key],
+			})
+		}
+	}
+}
+
+// crossBorderKey returns the GOBL tax key of a supply without VAT from an EU supplier
+// to a customer in another country, following the rules of applyCrossBorderKeys. It
+// returns an empty key for domestic supplies, suppliers outside the EU and supplies to
+// consumers in other member states, which are taxed as domestic ones.
+func crossBorderKey(regimeDef *tax.RegimeDef, country l10n.Code, b2b, goods bool) cbc.Key {
+	eu := l10n.Union(l10n.EU)
+	if !eu.HasMember(regimeDef.Country.Code()) || country == "" || country == regimeDef.Country.Code() {
+		return ""
+	}
+	inEU := eu.HasMember(country)
+	switch {
+	case goods && inEU && b2b:
+		return tax.KeyIntraCommunity
+	case goods && !inEU:
+		return tax.KeyExport
+	case !goods && inEU && b2b:
+		return tax.KeyReverseCharge
+	case !goods && !inEU:
+		return tax.KeyOutsideScope
+	}
+	return ""
+}
+
+// customerCountry returns the country of a customer from its first address with a
+// country, or else from its tax ID.
+func customerCountry(customer *org.Party) l10n.Code {
+	if customer == nil {
+		return ""
+	}
+	for _, addr := range customer.Addresses {
+		if addr != nil && addr.Country != "" {
+			return addr.Country.Code()
+		}
+	}
+	if customer.TaxID != nil {
+		return customer.TaxID.Country.Code()
+	}
+	return ""
+}
+
+// isUntaxedCombo checks if a tax combo charges no tax because of where the customer is:
+// it is a reverse charge, outside the scope of the tax, zero rated or has a zero
+// percentage. Exempt combos are left alone, as the exemption applies to the product
+// itself wherever it is supplied.
+func isUntaxedCombo(tc *tax.Combo) bool {
+	switch tc.Key {
+	case tax.KeyReverseCharge, tax.KeyOutsideScope, tax.KeyZero:
+		return true
+	case "", tax.KeyStandard:
+		return tc.Percent != nil && tc.Percent.IsZero()
+	}
+	return false
+}
+
+// hasNoteFrom checks if a list of notes already includes a note from the given source.
+func hasNoteFrom(notes []*org.Note, src cbc.Key) bool {
+	for _, n := range notes {
+		if n != nil && n.Src == src {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// crossBorderStripeInvoice returns an untaxed invoice from a German supplier to a
+// customer in the given country, for a product with the given Stripe tax code.
+func crossBorderStripeInvoice(country, taxID, taxCode string, reason stripe.InvoiceTotalTaxAmountTaxabilityReason) *stripe.Invoice {
+	doc := minimalStripeInvoice()
+	doc.CustomerName = "Customer Ltd"
+	doc.CustomerAddress = &stripe.Address{Country: country}
+	if taxID != "" {
+		idType := stripe.TaxIDTypeEUVAT
+		doc.CustomerTaxIDs = []*stripe.InvoiceCustomerTaxID{{Type: &idType, Value: taxID}}
+	}
+	taxAmounts := []*stripe.InvoiceTotalTaxAmount{
+		{
+			TaxabilityReason: reason,
+			TaxRate: &stripe.TaxRate{
+				Country: country,
+				TaxType: stripe.TaxRateTaxTypeVAT,
+				Created: 1737738363,
+			},
+		},
+	}
+	line := doc.Lines.Data[0]
+	line.Price.Product = &stripe.Product{
+		Name:    "Test Item",
+		TaxCode: &stripe.TaxCode{ID: taxCode},
+	}
+	line.TaxAmounts = taxAmounts
+	doc.TotalTaxAmounts = taxAmounts
+	return doc
+}
+
+func TestCrossBorderKeys(t *testing.T) {
+	account := validStripeAccount()
+	account.Country = "DE"
+
+	t.Run("intra-community supply of goods", func(t *testing.T) {
+		doc := crossBorderStripeInvoice("FR", "FR44732829320", "txcd_99999999", stripe.InvoiceTotalTaxAmountTaxabilityReasonReverseCharge)
+		inv, err := goblstripe.FromInvoice(doc, account)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, org.ItemKeyGoods, inv.Lines[0].Item.Key)
+		tc := inv.Lines[0].Taxes[0]
+		assert.Equal(t, tax.KeyIntraCommunity, tc.Key)
+		assert.Equal(t, "VATEX-EU-IC", tc.Ext["cef-vatex"].String())
+		assert.False(t, inv.HasTags(tax.TagReverseCharge))
+		require.Len(t, inv.Notes, 1)
+		assert.Equal(t, org.NoteKeyLegal, inv.Notes[0].Key)
+		assert.Equal(t, tax.KeyIntraCommunity, inv.Notes[0].Src)
+
+		// The legal note is generated again when converting back, so it isn't sent
+		params, _, err := goblstripe.ToInvoice(inv)
+		require.NoError(t, err)
+		assert.Empty(t, stripe.StringValue(params.Description))
+		assert.Empty(t, stripe.StringValue(params.Footer))
+	})
+
+	t.Run("export of goods", func(t *testing.T) {
+		doc := crossBorderStripeInvoice("US", "", "txcd_30011000", stripe.InvoiceTotalTaxAmountTaxabilityReasonNotCollecting)
+		inv, err := goblstripe.FromInvoice(doc, account)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+
+		tc := inv.Lines[0].Taxes[0]
+		assert.Equal(t, tax.KeyExport, tc.Key)
+		assert.Equal(t, "VATEX-EU-G", tc.Ext["cef-vatex"].String())
+		require.Len(t, inv.Notes, 1)
+		assert.Equal(t, tax.KeyExport, inv.Notes[0].Src)
+	})
+
+	t.Run("services to a business", func(t *testing.T) {
+		doc := crossBorderStripeInvoice("FR", "FR44732829320", "txcd_10103001", stripe.InvoiceTotalTaxAmountTaxabilityReasonNotCollecting)
+		inv, err := goblstripe.FromInvoice(doc, account)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, org.ItemKeyServices, inv.Lines[0].Item.Key)
+		tc := inv.Lines[0].Taxes[0]
+		assert.Equal(t, tax.KeyReverseCharge, tc.Key)
+		assert.Empty(t, tc.Ext)
+		assert.True(t, inv.HasTags(tax.TagReverseCharge))
+		// Only the regime's own reverse charge note
+		require.Len(t, inv.Notes, 1)
+		assert.Equal(t, tax.TagReverseCharge, inv.Notes[0].Src)
+	})
+
+	t.Run("services to a business outside the EU", func(t *testing.T) {
+		doc := crossBorderStripeInvoice("US", "", "txcd_10103001", stripe.InvoiceTotalTaxAmountTaxabilityReasonNotCollecting)
+		idType := stripe.TaxIDTypeUSEIN
+		doc.CustomerTaxIDs = []*stripe.InvoiceCustomerTaxID{{Type: &idType, Value: "12-3456789"}}
+		inv, err := goblstripe.FromInvoice(doc, account)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		require.Len(t, inv.Customer.Identities, 1)
+		tc := inv.Lines[0].Taxes[0]
+		assert.Equal(t, tax.KeyOutsideScope, tc.Key)
+		assert.False(t, inv.HasTags(tax.TagReverseCharge))
+		assert.Empty(t, inv.Notes)
+
+		// Also with a VAT number from outside the EU
+		doc = crossBorderStripeInvoice("GB", "", "txcd_10103001", stripe.InvoiceTotalTaxAmountTaxabilityReasonNotCollecting)
+		idType = stripe.TaxIDTypeGBVAT
+		doc.CustomerTaxIDs = []*stripe.InvoiceCustomerTaxID{{Type: &idType, Value: "GB844281425"}}
+		inv, err = goblstripe.FromInvoice(doc, account)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		require.NotNil(t, inv.Customer.TaxID)
+		assert.Equal(t, tax.KeyOutsideScope, inv.Lines[0].Taxes[0].Key)
+		assert.False(t, inv.HasTags(tax.TagReverseCharge))
+	})
+
+	t.Run("zero rated services outside the EU", func(t *testing.T) {
+		doc := crossBorderStripeInvoice("US", "", "txcd_10103001", stripe.InvoiceTotalTaxAmountTaxabilityReasonZeroRated)
+		inv, err := goblstripe.FromInvoice(doc, account)
+		require.NoError(t, err)
+
+		assert.Equal(t, tax.KeyOutsideScope, inv.Lines[0].Taxes[0].Key)
+		assert.False(t, inv.HasTags(tax.TagReverseCharge))
+	})
+
+	t.Run("goods to a consumer in another member state", func(t *testing.T) {
+		doc := crossBorderStripeInvoice("FR", "", "txcd_99999999", stripe.InvoiceTotalTaxAmountTaxabilityReasonNotCollecting)
+		inv, err := goblstripe.FromInvoice(doc, account)
+		require.NoError(t, err)
+
+		assert.Equal(t, tax.KeyOutsideScope, inv.Lines[0].Taxes[0].Key)
+		assert.Empty(t, inv.Notes)
+	})
+
+	t.Run("domestic customer", func(t *testing.T) {
+		doc := crossBorderStripeInvoice("DE", "DE282741168", "txcd_99999999", stripe.InvoiceTotalTaxAmountTaxabilityReasonZeroRated)
+		inv, err := goblstripe.FromInvoice(doc, account)
+		require.NoError(t, err)
+
+		assert.Equal(t, tax.KeyZero, inv.Lines[0].Taxes[0].Key)
+	})
+}
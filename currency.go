@@ -44,6 +44,11 @@ func FromCurrency(curr stripe.Currency) currency.Code {
 	return currency.Code(strings.ToUpper(string(curr)))
 }
 
+// ToCurrency converts a GOBL currency code into a stripe currency.
+func ToCurrency(curr currency.Code) stripe.Currency {
+	return stripe.Currency(strings.ToLower(string(curr)))
+}
+
 // ToStripeInt converts a GOBL amount into a Stripe int64.
 func ToStripeInt(amount *num.Amount, curr currency.Code) int64 {
 	r := amount.Rescale(2)
@@ -53,6 +58,28 @@ func ToStripeInt(amount *num.Amount, curr currency.Code) int64 {
 	return r.Value()
 }
 
+// toStripeDecimal converts a GOBL amount into a Stripe decimal amount expressed in
+// the currency's smallest unit, keeping any precision beyond the subunits. This is
+// the format expected by the `*_decimal` fields in the Stripe API.
+func toStripeDecimal(amount num.Amount, curr currency.Code) float64 {
+	factor := num.MakeAmount(100, 0)
+	if slices.Contains(zeroDecimalCurrencies, curr) {
+		factor = num.MakeAmount(1, 0)
+	}
+	return amount.Multiply(factor).Float64()
+}
+
+// toStripeUnitAmount converts a GOBL price into the Stripe unit amount. Only one of the
+// returned values is set: the decimal unit amount is used when the price has more
+// precision than the currency's subunits.
+func toStripeUnitAmount(price num.Amount, curr currency.Code) (*int64, *float64) {
+	unit := ToStripeInt(&price, curr)
+	if CurrencyAmount(unit, curr).Equals(price) {
+		return stripe.Int64(unit), nil
+	}
+	return nil, stripe.Float64(toStripeDecimal(price, curr))
+}
+
 // CurrencyAmount creates a currency amount object from a value and a currency code.
 func CurrencyAmount(val int64, curr currency.Code) num.Amount {
 	var exp uint32 = 2
@@ -0,0 +1,226 @@
+package goblstripe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// couponIDPrefix is used for the IDs of the coupons created from GOBL discounts.
+const couponIDPrefix = "gobl-"
+
+// couponNameMaxLength is the maximum length of a Stripe coupon name.
+const couponNameMaxLength = 40
+
+// ToLineDiscountCouponParams converts a GOBL line discount into the params of a Stripe
+// coupon to apply to the line's invoice item. Stripe applies several discounts one after
+// the other, while GOBL applies every line discount to the line sum, so percentages are
+// only kept when the discount is the only one in the line and has no custom base.
+// Otherwise, the amount calculated by GOBL is used so the tax bases stay the same.
+func ToLineDiscountCouponParams(line *bill.Line, discount *bill.LineDiscount, curr currency.Code) *stripe.CouponParams {
+	if line == nil || discount == nil {
+		return nil
+	}
+	var percent *num.Percentage
+	if len(line.Discounts) == 1 && discount.Base == nil {
+		percent = discount.Percent
+	}
+	return newCouponParams(percent, lineDiscountAmount(line, discount, curr), discount.Reason, curr)
+}
+
+// ToDiscountCouponParams converts a GOBL document level discount into the params of a
+// Stripe coupon to apply to the whole invoice. As with line discounts, percentages are
+// only kept when the discount has no custom base; the rest use the calculated amount.
+// Stripe spreads invoice discounts over all the lines proportionally, so the tax bases
+// only stay the same when the discount applies to all the taxes of the invoice.
+func ToDiscountCouponParams(discount *bill.Discount, curr currency.Code) *stripe.CouponParams {
+	if discount == nil {
+		return nil
+	}
+	var percent *num.Percentage
+	if discount.Base == nil {
+		percent = discount.Percent
+	}
+	amount := discount.Amount
+	if amount.IsZero() && discount.Percent != nil && discount.Base != nil {
+		amount = discount.Percent.Of(*discount.Base)
+	}
+	return newCouponParams(percent, amount, discount.Reason, curr)
+}
+
+// newCouponParams creates the params of a one-off Stripe coupon. The ID is derived
+// from the terms of the coupon, so coupons with the same terms are always reused.
+func newCouponParams(percent *num.Percentage, amount num.Amount, reason string, curr currency.Code) *stripe.CouponParams {
+	params := &stripe.CouponParams{
+		Duration: stripe.String(string(stripe.CouponDurationOnce)),
+	}
+	switch {
+	case percent != nil && !percent.IsZero():
+		params.PercentOff = stripe.Float64(toStripePercentage(percent))
+	case !amount.IsZero():
+		params.AmountOff = stripe.Int64(ToStripeInt(&amount, curr))
+		params.Currency = stripe.String(string(ToCurrency(curr)))
+	default:
+		return nil
+	}
+	if name := toCouponName(reason); name != "" {
+		params.Name = stripe.String(name)
+	}
+	params.ID = stripe.String(couponID(params))
+	return params
+}
+
+// toCouponName trims the discount reason to the maximum length of a coupon name.
+func toCouponName(reason string) string {
+	name := strings.TrimSpace(reason)
+	if r := []rune(name); len(r) > couponNameMaxLength {
+		name = string(r[:couponNameMaxLength])
+	}
+	return name
+}
+
+// couponID builds a deterministic coupon ID from the terms of the coupon.
+func couponID(params *stripe.CouponParams) string {
+	terms := strings.Join([]string{
+		strconv.FormatFloat(stripe.Float64Value(params.PercentOff), 'f', -1, 64),
+		strconv.FormatInt(stripe.Int64Value(params.AmountOff), 10),
+		stripe.StringValue(params.Currency),
+		stripe.StringValue(params.Duration),
+		stripe.StringValue(params.Name),
+	}, "|")
+	sum := sha256.Sum256([]byte(terms))
+	return couponIDPrefix + hex.EncodeToString(sum[:])[:16]
+}
+
+// CouponRegistry keeps track of the coupons defined in a Stripe account, so that GOBL
+// discounts are applied with existing coupons instead of creating duplicate ones.
+type CouponRegistry struct {
+	coupons []*stripe.Coupon
+}
+
+// NewCouponRegistry creates a coupon registry from a list of existing Stripe coupons,
+// typically the result of listing the coupons of the account.
+func NewCouponRegistry(coupons []*stripe.Coupon) *CouponRegistry {
+	r := new(CouponRegistry)
+	for _, c := range coupons {
+		r.Add(c)
+	}
+	return r
+}
+
+// Add registers a Stripe coupon, such as one just created from the params returned by
+// Missing. Coupons that are no longer valid are ignored.
+func (r *CouponRegistry) Add(coupon *stripe.Coupon) {
+	if coupon == nil || !coupon.Valid || coupon.Deleted {
+		return
+	}
+	r.coupons = append(r.coupons, coupon)
+}
+
+// Lookup returns the ID of the registered coupon with the same terms as the params, or
+// an empty string when there is none.
+func (r *CouponRegistry) Lookup(params *stripe.CouponParams) string {
+	if r == nil || params == nil {
+		return ""
+	}
+	for _, c := range r.coupons {
+		if couponMatches(c, params) {
+			return c.ID
+		}
+	}
+	return ""
+}
+
+// Missing returns the params of the coupons needed for the discounts of a GOBL invoice
+// that are not yet registered, without duplicates. They need to be created in Stripe
+// before creating the invoice.
+func (r *CouponRegistry) Missing(inv *bill.Invoice) []*stripe.CouponParams {
+	var missing []*stripe.CouponParams
+	seen := make(map[string]bool)
+	add := func(params *stripe.CouponParams) {
+		if params == nil || r.Lookup(params) != "" || seen[*params.ID] {
+			return
+		}
+		seen[*params.ID] = true
+		missing = append(missing, params)
+	}
+	for _, line := range inv.Lines {
+		if line == nil {
+			continue
+		}
+		for _, d := range line.Discounts {
+			add(ToLineDiscountCouponParams(line, d, inv.Currency))
+		}
+	}
+	for _, d := range inv.Discounts {
+		add(ToDiscountCouponParams(d, inv.Currency))
+	}
+	return missing
+}
+
+// couponIDFor returns the ID of the registered coupon with the same terms as the
+// params or, when there is none, the ID of the coupon that will be created from them.
+func (r *CouponRegistry) couponIDFor(params *stripe.CouponParams) string {
+	if id := r.Lookup(params); id != "" {
+		return id
+	}
+	return stripe.StringValue(params.ID)
+}
+
+// toInvoiceItemDiscountsParams returns the coupons to apply to the invoice item of a line.
+func (r *CouponRegistry) toInvoiceItemDiscountsParams(line *bill.Line, curr currency.Code) []*stripe.InvoiceItemDiscountParams {
+	var discounts []*stripe.InvoiceItemDiscountParams
+	for _, d := range line.Discounts {
+		if params := ToLineDiscountCouponParams(line, d, curr); params != nil {
+			discounts = append(discounts, &stripe.InvoiceItemDiscountParams{
+				Coupon: stripe.String(r.couponIDFor(params)),
+			})
+		}
+	}
+	return discounts
+}
+
+// toInvoiceDiscountsParams returns the coupons to apply to the whole invoice.
+func (r *CouponRegistry) toInvoiceDiscountsParams(discounts []*bill.Discount, curr currency.Code) []*stripe.InvoiceDiscountParams {
+	var list []*stripe.InvoiceDiscountParams
+	for _, d := range discounts {
+		if params := ToDiscountCouponParams(d, curr); params != nil {
+			list = append(list, &stripe.InvoiceDiscountParams{
+				Coupon: stripe.String(r.couponIDFor(params)),
+			})
+		}
+	}
+	return list
+}
+
+// couponMatches checks if an existing Stripe coupon has the same terms as the params
+// of a new one. Coupons restricted to some products are never reused.
+func couponMatches(c *stripe.Coupon, params *stripe.CouponParams) bool {
+	if !c.Valid || c.Deleted {
+		return false
+	}
+	if c.ID == stripe.StringValue(params.ID) {
+		return true
+	}
+	if c.AppliesTo != nil && len(c.AppliesTo.Products) > 0 {
+		return false
+	}
+	if c.Duration != stripe.CouponDuration(stripe.StringValue(params.Duration)) {
+		return false
+	}
+	if c.Name != stripe.StringValue(params.Name) {
+		return false
+	}
+	if params.PercentOff != nil {
+		return c.AmountOff == 0 && c.PercentOff == *params.PercentOff
+	}
+	return c.PercentOff == 0 &&
+		c.AmountOff == stripe.Int64Value(params.AmountOff) &&
+		strings.EqualFold(string(c.Currency), stripe.StringValue(params.Currency))
+}
@@ -0,0 +1,214 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func discountedLine(discounts ...*bill.LineDiscount) *bill.Line {
+	return &bill.Line{
+		Quantity: num.MakeAmount(2, 0),
+		Item: &org.Item{
+			Name:  "Pro Plan",
+			Price: num.NewAmount(5000, 2),
+		},
+		Discounts: discounts,
+	}
+}
+
+func TestToLineDiscountCouponParams(t *testing.T) {
+	t.Run("percent discount", func(t *testing.T) {
+		line := discountedLine(&bill.LineDiscount{
+			Reason:  "Loyalty",
+			Percent: num.NewPercentage(10, 2),
+		})
+		params := goblstripe.ToLineDiscountCouponParams(line, line.Discounts[0], currency.EUR)
+		require.NotNil(t, params)
+		assert.Equal(t, 10.0, stripe.Float64Value(params.PercentOff))
+		assert.Nil(t, params.AmountOff)
+		assert.Nil(t, params.Currency)
+		assert.Equal(t, "once", stripe.StringValue(params.Duration))
+		assert.Equal(t, "Loyalty", stripe.StringValue(params.Name))
+		assert.Contains(t, stripe.StringValue(params.ID), "gobl-")
+	})
+
+	t.Run("amount discount", func(t *testing.T) {
+		line := discountedLine(&bill.LineDiscount{
+			Reason: "Promo",
+			Amount: num.MakeAmount(1250, 2),
+		})
+		params := goblstripe.ToLineDiscountCouponParams(line, line.Discounts[0], currency.EUR)
+		require.NotNil(t, params)
+		assert.Nil(t, params.PercentOff)
+		assert.Equal(t, int64(1250), stripe.Int64Value(params.AmountOff))
+		assert.Equal(t, "eur", stripe.StringValue(params.Currency))
+	})
+
+	t.Run("zero decimal currency", func(t *testing.T) {
+		line := discountedLine(&bill.LineDiscount{
+			Amount: num.MakeAmount(500, 0),
+		})
+		params := goblstripe.ToLineDiscountCouponParams(line, line.Discounts[0], currency.JPY)
+		require.NotNil(t, params)
+		assert.Equal(t, int64(500), stripe.Int64Value(params.AmountOff))
+		assert.Equal(t, "jpy", stripe.StringValue(params.Currency))
+		assert.Nil(t, params.Name)
+	})
+
+	t.Run("percent with custom base uses the amount", func(t *testing.T) {
+		line := discountedLine(&bill.LineDiscount{
+			Base:    num.NewAmount(5000, 2),
+			Percent: num.NewPercentage(20, 2),
+		})
+		params := goblstripe.ToLineDiscountCouponParams(line, line.Discounts[0], currency.EUR)
+		require.NotNil(t, params)
+		assert.Nil(t, params.PercentOff)
+		assert.Equal(t, int64(1000), stripe.Int64Value(params.AmountOff))
+	})
+
+	t.Run("long reason", func(t *testing.T) {
+		line := discountedLine(&bill.LineDiscount{
+			Reason:  "Special discount for the early adopters of the platform",
+			Percent: num.NewPercentage(5, 2),
+		})
+		params := goblstripe.ToLineDiscountCouponParams(line, line.Discounts[0], currency.EUR)
+		assert.Len(t, stripe.StringValue(params.Name), 40)
+	})
+
+	t.Run("empty discount", func(t *testing.T) {
+		line := discountedLine(&bill.LineDiscount{})
+		assert.Nil(t, goblstripe.ToLineDiscountCouponParams(line, line.Discounts[0], currency.EUR))
+		assert.Nil(t, goblstripe.ToLineDiscountCouponParams(line, nil, currency.EUR))
+	})
+
+	t.Run("identical terms share the ID", func(t *testing.T) {
+		l1 := discountedLine(&bill.LineDiscount{Reason: "Loyalty", Percent: num.NewPercentage(10, 2)})
+		l2 := discountedLine(&bill.LineDiscount{Reason: "Loyalty", Percent: num.NewPercentage(100, 3)})
+		l3 := discountedLine(&bill.LineDiscount{Reason: "Promo", Percent: num.NewPercentage(10, 2)})
+		p1 := goblstripe.ToLineDiscountCouponParams(l1, l1.Discounts[0], currency.EUR)
+		p2 := goblstripe.ToLineDiscountCouponParams(l2, l2.Discounts[0], currency.EUR)
+		p3 := goblstripe.ToLineDiscountCouponParams(l3, l3.Discounts[0], currency.EUR)
+		assert.Equal(t, stripe.StringValue(p1.ID), stripe.StringValue(p2.ID))
+		assert.NotEqual(t, stripe.StringValue(p1.ID), stripe.StringValue(p3.ID))
+	})
+}
+
+func TestToDiscountCouponParams(t *testing.T) {
+	t.Run("percent discount", func(t *testing.T) {
+		params := goblstripe.ToDiscountCouponParams(&bill.Discount{
+			Reason:  "Volume",
+			Percent: num.NewPercentage(15, 2),
+		}, currency.EUR)
+		require.NotNil(t, params)
+		assert.Equal(t, 15.0, stripe.Float64Value(params.PercentOff))
+		assert.Equal(t, "Volume", stripe.StringValue(params.Name))
+	})
+
+	t.Run("percent with custom base", func(t *testing.T) {
+		params := goblstripe.ToDiscountCouponParams(&bill.Discount{
+			Base:    num.NewAmount(20000, 2),
+			Percent: num.NewPercentage(10, 2),
+		}, currency.EUR)
+		require.NotNil(t, params)
+		assert.Nil(t, params.PercentOff)
+		assert.Equal(t, int64(2000), stripe.Int64Value(params.AmountOff))
+	})
+
+	t.Run("nil discount", func(t *testing.T) {
+		assert.Nil(t, goblstripe.ToDiscountCouponParams(nil, currency.EUR))
+	})
+}
+
+func TestCouponRegistry(t *testing.T) {
+	line := discountedLine(&bill.LineDiscount{Reason: "Loyalty", Percent: num.NewPercentage(10, 2)})
+	params := goblstripe.ToLineDiscountCouponParams(line, line.Discounts[0], currency.EUR)
+
+	t.Run("matches by terms", func(t *testing.T) {
+		reg := goblstripe.NewCouponRegistry([]*stripe.Coupon{
+			{ID: "LOYALTY10", Name: "Loyalty", PercentOff: 10, Duration: stripe.CouponDurationOnce, Valid: true},
+		})
+		assert.Equal(t, "LOYALTY10", reg.Lookup(params))
+	})
+
+	t.Run("ignores different or unusable coupons", func(t *testing.T) {
+		reg := goblstripe.NewCouponRegistry([]*stripe.Coupon{
+			{ID: "expired", Name: "Loyalty", PercentOff: 10, Duration: stripe.CouponDurationOnce},
+			{ID: "forever", Name: "Loyalty", PercentOff: 10, Duration: stripe.CouponDurationForever, Valid: true},
+			{ID: "other-name", Name: "Promo", PercentOff: 10, Duration: stripe.CouponDurationOnce, Valid: true},
+			{
+				ID: "restricted", Name: "Loyalty", PercentOff: 10, Duration: stripe.CouponDurationOnce, Valid: true,
+				AppliesTo: &stripe.CouponAppliesTo{Products: []string{"prod_123"}},
+			},
+		})
+		assert.Empty(t, reg.Lookup(params))
+	})
+
+	t.Run("matches by ID", func(t *testing.T) {
+		reg := goblstripe.NewCouponRegistry(nil)
+		reg.Add(&stripe.Coupon{ID: stripe.StringValue(params.ID), Valid: true})
+		assert.Equal(t, stripe.StringValue(params.ID), reg.Lookup(params))
+	})
+
+	t.Run("missing coupons without duplicates", func(t *testing.T) {
+		inv := validGOBLInvoice()
+		inv.Lines[0].Discounts = []*bill.LineDiscount{
+			{Reason: "Loyalty", Percent: num.NewPercentage(10, 2)},
+		}
+		inv.Lines = append(inv.Lines, discountedLine(&bill.LineDiscount{
+			Reason:  "Loyalty",
+			Percent: num.NewPercentage(10, 2),
+		}))
+		inv.Lines[1].Taxes = inv.Lines[0].Taxes
+		inv.Discounts = []*bill.Discount{
+			{Reason: "Volume", Percent: num.NewPercentage(5, 2)},
+		}
+
+		reg := goblstripe.NewCouponRegistry(nil)
+		missing := reg.Missing(inv)
+		require.Len(t, missing, 2)
+		assert.Equal(t, "Loyalty", stripe.StringValue(missing[0].Name))
+		assert.Equal(t, "Volume", stripe.StringValue(missing[1].Name))
+
+		reg.Add(&stripe.Coupon{ID: "LOYALTY10", Name: "Loyalty", PercentOff: 10, Duration: stripe.CouponDurationOnce, Valid: true})
+		assert.Len(t, reg.Missing(inv), 1)
+	})
+}
+
+func TestToInvoiceWithCoupons(t *testing.T) {
+	inv := validGOBLInvoice()
+	inv.Lines[0].Discounts = []*bill.LineDiscount{
+		{Reason: "Loyalty", Percent: num.NewPercentage(10, 2)},
+	}
+	inv.Discounts = []*bill.Discount{
+		{Reason: "Volume", Amount: num.MakeAmount(500, 2)},
+	}
+	require.NoError(t, inv.Calculate())
+
+	reg := goblstripe.NewCouponRegistry([]*stripe.Coupon{
+		{ID: "LOYALTY10", Name: "Loyalty", PercentOff: 10, Duration: stripe.CouponDurationOnce, Valid: true},
+	})
+	missing := reg.Missing(inv)
+	require.Len(t, missing, 1)
+
+	params, items, err := goblstripe.ToInvoice(inv, goblstripe.WithCouponRegistry(reg))
+	require.NoError(t, err)
+
+	require.Len(t, items, 1)
+	require.Len(t, items[0].Discounts, 1)
+	assert.Equal(t, "LOYALTY10", stripe.StringValue(items[0].Discounts[0].Coupon))
+
+	require.Len(t, params.Discounts, 1)
+	assert.Equal(t, stripe.StringValue(missing[0].ID), stripe.StringValue(params.Discounts[0].Coupon))
+	assert.Equal(t, int64(500), stripe.Int64Value(missing[0].AmountOff))
+
+	// The Stripe tax base (100.00 - 10% - 5.00) matches the GOBL one
+	assert.Equal(t, "85.00", inv.Totals.Total.String())
+}
@@ -67,45 +67,11 @@ func processFile(t *testing.T, path string) error {
 		return fmt.Errorf("reading file: %w", err)
 	}
 
-	var objMap map[string]interface{}
-	if err := json.Unmarshal(data, &objMap); err != nil {
-		return fmt.Errorf("failed to parse JSON: %v", err)
-	}
-
-	objType, ok := objMap["object"].(string)
-	if !ok {
-		return fmt.Errorf("could not determine object type from Stripe JSON")
-	}
-
-	var goblInvoice *bill.Invoice
-
-	switch objType {
-	case "invoice":
-		var stripeInvoice stripe.Invoice
-		if err := json.Unmarshal(data, &stripeInvoice); err != nil {
-			return fmt.Errorf("failed to parse Stripe invoice: %v", err)
-		}
-		goblInvoice, err = goblstripe.FromInvoice(&stripeInvoice, validStripeAccount())
-		if err != nil && goblInvoice == nil {
-			return fmt.Errorf("failed to convert to GOBL: %v", err)
-		}
-	case "credit_note":
-		var stripeCreditNote stripe.CreditNote
-		if err := json.Unmarshal(data, &stripeCreditNote); err != nil {
-			return fmt.Errorf("failed to parse Stripe credit note: %v", err)
-		}
-		goblInvoice, err = goblstripe.FromCreditNote(&stripeCreditNote, validStripeAccount())
-		if err != nil && goblInvoice == nil {
-			return fmt.Errorf("failed to convert to GOBL: %v", err)
-		}
-	default:
-		return fmt.Errorf("unsupported Stripe object type: %s", objType)
+	goblInvoice, err := convertExample(data)
+	if err != nil {
+		return err
 	}
 
-	// override the document UUID and issue date for consistent test results
-	goblInvoice.UUID = uuid.MustParse("019860fc-7d4c-7922-a371-e848ca5141d3")
-	goblInvoice.IssueDate = cal.MakeDate(2026, 1, 1)
-
 	env, err := gobl.Envelop(goblInvoice)
 	if err != nil {
 		return fmt.Errorf("failed to create envelop: %v", err)
@@ -159,3 +125,49 @@ func processFile(t *testing.T, path string) error {
 
 	return nil
 }
+
+// convertExample converts a Stripe example into the GOBL invoice stored in the /out/
+// directory. Rounding errors are ignored as long as the invoice is converted.
+func convertExample(data []byte) (*bill.Invoice, error) {
+	var objMap map[string]interface{}
+	if err := json.Unmarshal(data, &objMap); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %v", err)
+	}
+
+	objType, ok := objMap["object"].(string)
+	if !ok {
+		return nil, fmt.Errorf("could not determine object type from Stripe JSON")
+	}
+
+	var goblInvoice *bill.Invoice
+	var err error
+
+	switch objType {
+	case "invoice":
+		var stripeInvoice stripe.Invoice
+		if err := json.Unmarshal(data, &stripeInvoice); err != nil {
+			return nil, fmt.Errorf("failed to parse Stripe invoice: %v", err)
+		}
+		goblInvoice, err = goblstripe.FromInvoice(&stripeInvoice, validStripeAccount())
+		if err != nil && goblInvoice == nil {
+			return nil, fmt.Errorf("failed to convert to GOBL: %v", err)
+		}
+	case "credit_note":
+		var stripeCreditNote stripe.CreditNote
+		if err := json.Unmarshal(data, &stripeCreditNote); err != nil {
+			return nil, fmt.Errorf("failed to parse Stripe credit note: %v", err)
+		}
+		goblInvoice, err = goblstripe.FromCreditNote(&stripeCreditNote, validStripeAccount())
+		if err != nil && goblInvoice == nil {
+			return nil, fmt.Errorf("failed to convert to GOBL: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Stripe object type: %s", objType)
+	}
+
+	// override the document UUID and issue date for consistent test results
+	goblInvoice.UUID = uuid.MustParse("019860fc-7d4c-7922-a371-e848ca5141d3")
+	goblInvoice.IssueDate = cal.MakeDate(2026, 1, 1)
+
+	return goblInvoice, nil
+}
@@ -24,3 +24,13 @@ func newExtensionsWithPrefix(metadata map[string]string, prefix string) tax.Exte
 	}
 	return extensions
 }
+
+// toMetadataWithPrefix converts a tax.Extensions object into Stripe metadata, adding the
+// provided prefix to each key. It is the reverse of newExtensionsWithPrefix.
+func toMetadataWithPrefix(ext tax.Extensions, prefix string) map[string]string {
+	metadata := make(map[string]string, len(ext))
+	for key, value := range ext {
+		metadata[prefix+key.String()] = value.String()
+	}
+	return metadata
+}
@@ -0,0 +1,369 @@
+package goblstripe
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/catalogues/cef"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// Types of the fee details of Stripe balance transactions.
+const (
+	stripeFeeTypeApplication              = "application_fee"
+	stripeFeeTypePaymentMethodPassthrough = "payment_method_passthrough_fee"
+	stripeFeeTypeStripe                   = "stripe_fee"
+	stripeFeeTypeTax                      = "tax"
+)
+
+// feesCodePrefix is prepended to the codes of the invoices of Stripe fees.
+const feesCodePrefix = "FEES-"
+
+// stripeEntity is the Stripe legal entity that provides the service, and so invoices
+// the fees, to the accounts of a group of countries.
+type stripeEntity struct {
+	name    string
+	country l10n.TaxCountryCode
+}
+
+var (
+	stripeEntityEurope = &stripeEntity{name: "Stripe Technology Europe, Limited", country: "IE"}
+	stripeEntityUK     = &stripeEntity{name: "Stripe Payments UK, Ltd.", country: "GB"}
+	stripeEntityUS     = &stripeEntity{name: "Stripe, Inc.", country: "US"}
+	stripeEntityCA     = &stripeEntity{name: "Stripe Payments Canada, Ltd.", country: "CA"}
+	stripeEntityAU     = &stripeEntity{name: "Stripe Payments Australia Pty Ltd", country: "AU"}
+)
+
+// stripeEntityEuropeCountries are the countries of the accounts served by the European
+// Stripe entity.
+var stripeEntityEuropeCountries = []string{
+	"AT", "BE", "BG", "CH", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU",
+	"IE", "IS", "IT", "LI", "LT", "LU", "LV", "MT", "NL", "NO", "PL", "PT", "RO", "SE", "SI", "SK",
+}
+
+// FeesOption is a functional option for FromBalanceTransactionFees.
+type FeesOption func(*feesOptions)
+
+type feesOptions struct {
+	supplier *org.Party
+}
+
+// WithFeesSupplier provides the party to use as the supplier of the fees invoice instead
+// of the Stripe entity guessed from the account country, for example to include the tax
+// ID and address shown in the invoices Stripe issues.
+func WithFeesSupplier(supplier *org.Party) FeesOption {
+	return func(o *feesOptions) {
+		o.supplier = supplier
+	}
+}
+
+// FromBalanceTransactionFees aggregates the fees that Stripe deducted from the balance
+// transactions of an account over a period into an incoming GOBL invoice, with the
+// Stripe legal entity serving the account country as the supplier and the account as
+// the customer. Fees are grouped into a line per type and description, and the tax
+// charged on fees is applied on top of them. When Stripe charged no tax on fees and
+// invoices them from another country, the fees follow the rules of the services of
+// cross-border invoices: a reverse charge for businesses in other member states and
+// outside the scope of VAT for accounts outside the EU.
+// Transactions created outside of the period are ignored. When no period is given, it
+// spans the transactions given. Fees are already paid, as they are deducted from the
+// balance.
+func FromBalanceTransactionFees(txns []*stripe.BalanceTransaction, account *stripe.Account, period *stripe.Period, opts ...FeesOption) (*bill.Invoice, error) {
+	var options feesOptions
+	for _, o := range opts {
+		if o != nil {
+			o(&options)
+		}
+	}
+	if account == nil || account.Country == "" {
+		return nil, fmt.Errorf("missing account country")
+	}
+	if period == nil {
+		period = balanceTransactionsPeriod(txns)
+	}
+
+	entity := newStripeEntity(account.Country)
+	supplier := options.supplier
+	if supplier == nil {
+		supplier = &org.Party{
+			Name:      entity.name,
+			Addresses: []*org.Address{{Country: l10n.ISOCountryCode(entity.country)}},
+		}
+	}
+	country := entity.country
+	if supplier.TaxID != nil && supplier.TaxID.Country != "" {
+		country = supplier.TaxID.Country
+	}
+	regimeDef := tax.RegimeDefFor(country.Code())
+	if regimeDef == nil {
+		return nil, fmt.Errorf("missing regime definition for %s", country)
+	}
+
+	fees, err := aggregateBalanceTransactionFees(txns, period)
+	if err != nil {
+		return nil, err
+	}
+	if len(fees.lines) == 0 {
+		return nil, fmt.Errorf("no fees found in the balance transactions")
+	}
+
+	inv := newFeesInvoice(fees, regimeDef, period, supplier, NewSupplierFromAccount(account), account.Country)
+	inv.Code = newFeesCode(period, regimeDef)
+	inv.Meta = cbc.Meta{
+		MetaKeyStripeDocID:   account.ID,
+		MetaKeyStripeDocType: StripeDocTypeFees,
+	}
+
+	return inv, nil
+}
+
+// newFeesInvoice creates the invoice of the fees collected over a period. When no tax
+// was charged on the fees, they are keyed as the services of a cross-border invoice
+// (see crossBorderKey).
+func newFeesInvoice(fees *balanceFees, regimeDef *tax.RegimeDef, period *stripe.Period, supplier, customer *org.Party, customerCountry string) *bill.Invoice {
+	inv := new(bill.Invoice)
+	inv.Regime = tax.WithRegime(regimeDef.Country)
+	inv.Type = bill.InvoiceTypeStandard
+	inv.UUID = uuid.V7()
+	inv.IssueDate = *newDateFromTS(period.End, regimeDef.TimeLocation())
+	inv.Currency = FromCurrency(fees.currency)
+	inv.ExchangeRates = newExchangeRates(inv.Currency, regimeDef)
+
+	inv.Supplier = supplier
+	inv.Customer = customer
+
+	var key cbc.Key
+	if fees.tax == 0 {
+		b2b := customer != nil && customer.TaxID != nil && customer.TaxID.Code != ""
+		key = crossBorderKey(regimeDef, l10n.Code(customerCountry), b2b, false)
+	}
+	inv.Tags = newTags(key == tax.KeyReverseCharge, inv.Customer)
+
+	combo := newFeesTaxCombo(fees, key, regimeDef, &inv.IssueDate)
+	if fees.taxIncluded && combo != nil && key == "" {
+		inv.Tax = &bill.Tax{PricesInclude: combo.Category}
+	}
+	for i, l := range fees.lines {
+		l.Index = i + 1
+		if combo != nil {
+			l.Taxes = tax.Set{combo}
+		}
+	}
+	inv.Lines = fees.lines
+
+	inv.Ordering = &bill.Ordering{
+		Period: &cal.Period{
+			Start: *newDateFromTS(period.Start, regimeDef.TimeLocation()),
+			End:   *newDateFromTS(period.End, regimeDef.TimeLocation()),
+		},
+	}
+	inv.Payment = &bill.PaymentDetails{
+		Advances: []*pay.Advance{
+			{
+				Description: "Deducted from the Stripe balance",
+				Percent:     num.NewPercentage(100, 2),
+			},
+		},
+	}
+
+	return inv
+}
+
+// newStripeEntity returns the Stripe legal entity serving the accounts of a country.
+func newStripeEntity(country string) *stripeEntity {
+	switch {
+	case slices.Contains(stripeEntityEuropeCountries, country):
+		return stripeEntityEurope
+	case country == "GB":
+		return stripeEntityUK
+	case country == "CA":
+		return stripeEntityCA
+	case country == "AU":
+		return stripeEntityAU
+	default:
+		return stripeEntityUS
+	}
+}
+
+// balanceTransactionsPeriod returns the period spanning the creation of a list of
+// balance transactions.
+func balanceTransactionsPeriod(txns []*stripe.BalanceTransaction) *stripe.Period {
+	period := new(stripe.Period)
+	for _, txn := range txns {
+		if txn == nil {
+			continue
+		}
+		if period.Start == 0 || txn.Created < period.Start {
+			period.Start = txn.Created
+		}
+		if txn.Created > period.End {
+			period.End = txn.Created
+		}
+	}
+	return period
+}
+
+// balanceFees are the fees deducted from a set of balance transactions.
+type balanceFees struct {
+	lines    []*bill.Line
+	base     int64 // sum of the fees, before tax
+	tax      int64
+	currency stripe.Currency
+	// taxIncluded is set for fees that include the standard rate of the regime when no
+	// tax is recorded for them
+	taxIncluded bool
+}
+
+// aggregateBalanceTransactionFees sums the fee details of the balance transactions
+// created within a period into a line per fee type and description, and the tax
+// charged on them.
+func aggregateBalanceTransactionFees(txns []*stripe.BalanceTransaction, period *stripe.Period) (*balanceFees, error) {
+	fees := new(balanceFees)
+	var keys []string
+	totals := make(map[string]int64)
+	names := make(map[string]string)
+	for _, txn := range txns {
+		if txn == nil || txn.Created < period.Start || txn.Created > period.End {
+			continue
+		}
+		for _, fd := range txn.FeeDetails {
+			if fees.currency == "" {
+				fees.currency = fd.Currency
+			} else if fd.Currency != fees.currency {
+				return nil, fmt.Errorf("fees in different currencies: %s and %s", fees.currency, fd.Currency)
+			}
+			if fd.Type == stripeFeeTypeTax {
+				fees.tax += fd.Amount
+				continue
+			}
+			name := fd.Description
+			if name == "" {
+				name = feeTypeName(fd.Type)
+			}
+			key := fd.Type + "|" + name
+			if _, ok := totals[key]; !ok {
+				keys = append(keys, key)
+				names[key] = name
+			}
+			totals[key] += fd.Amount
+			fees.base += fd.Amount
+		}
+	}
+
+	for _, key := range keys {
+		price := CurrencyAmount(totals[key], FromCurrency(fees.currency))
+		fees.lines = append(fees.lines, &bill.Line{
+			Quantity: num.MakeAmount(1, 0),
+			Item: &org.Item{
+				Name:  names[key],
+				Price: &price,
+			},
+		})
+	}
+	return fees, nil
+}
+
+// feeTypeName returns a readable name for a type of fee without description.
+func feeTypeName(feeType string) string {
+	switch feeType {
+	case stripeFeeTypeStripe:
+		return "Stripe fees"
+	case stripeFeeTypeApplication:
+		return "Application fees"
+	case stripeFeeTypePaymentMethodPassthrough:
+		return "Payment method fees"
+	default:
+		return strings.ReplaceAll(feeType, "_", " ")
+	}
+}
+
+// newFeesTaxCombo returns the tax applied to the fee lines: the cross-border key, the
+// rate of the tax Stripe charged on the fees, the standard rate for fees that include
+// it, or nothing when no tax applies.
+func newFeesTaxCombo(fees *balanceFees, key cbc.Key, regimeDef *tax.RegimeDef, date *cal.Date) *tax.Combo {
+	if key != "" {
+		tc := &tax.Combo{
+			Category: tax.CategoryVAT,
+			Country:  regimeDef.Country,
+			Key:      key,
+		}
+		if code := taxExemptionCode(nil, key, tc.Country); code != "" {
+			tc.Ext = tc.Ext.Set(cef.ExtKeyVATEX, code)
+		}
+		return tc
+	}
+
+	cat := tax.CategoryVAT
+	if regimeDef.CategoryDef(cat) == nil {
+		cat = tax.CategoryGST
+	}
+	if regimeDef.CategoryDef(cat) == nil {
+		return nil
+	}
+	tc := &tax.Combo{
+		Category: cat,
+		Country:  regimeDef.Country,
+	}
+
+	if fees.tax == 0 || fees.base == 0 {
+		if !fees.taxIncluded || regimeDef.CategoryDef(cat).RateDef(tax.KeyStandard, tax.RateGeneral) == nil {
+			return nil
+		}
+		tc.Rate = tax.RateGeneral
+		return tc
+	}
+
+	// Stripe only reports the tax amount, which is rounded on every transaction, so the
+	// rate is the closest one to the percent of tax over the fees.
+	percent := float64(fees.tax) * 100 / float64(fees.base)
+	rate, val := closestRateValue(percent, regimeDef.CategoryDef(cat), date)
+	if val == nil {
+		tc.Percent = percentFromFloat(math.Round(percent*100) / 100)
+		return tc
+	}
+	tc.Rate = rate.Rate
+	tc.Ext = val.Ext
+	return tc
+}
+
+// maxFeesRateDeviation is the largest difference, in percentage points, between the
+// percent of tax over the fees and the regime rate it is matched with.
+const maxFeesRateDeviation = 0.5
+
+// closestRateValue returns the rate value of a category applicable on the date that is
+// closest to the percent given, if close enough.
+func closestRateValue(percent float64, catDef *tax.CategoryDef, date *cal.Date) (rate *tax.RateDef, val *tax.RateValueDef) {
+	best := maxFeesRateDeviation
+	for _, r := range catDef.Rates {
+		for _, v := range r.Values {
+			if v.Surcharge != nil || v != r.Value(*date, v.Ext) {
+				continue
+			}
+			diff := math.Abs(v.Percent.Amount().Float64() - percent)
+			if diff < best {
+				best = diff
+				rate = r
+				val = v
+			}
+		}
+	}
+	return rate, val
+}
+
+// newFeesCode returns the code of the invoice of the fees of a period.
+func newFeesCode(period *stripe.Period, regimeDef *tax.RegimeDef) cbc.Code {
+	start := newDateFromTS(period.Start, regimeDef.TimeLocation())
+	end := newDateFromTS(period.End, regimeDef.TimeLocation())
+	return cbc.Code(feesCodePrefix + strings.ReplaceAll(start.String(), "-", "") + "-" + strings.ReplaceAll(end.String(), "-", ""))
+}
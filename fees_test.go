@@ -0,0 +1,131 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func validStripeBalanceTransactions() []*stripe.BalanceTransaction {
+	return []*stripe.BalanceTransaction{
+		{
+			ID:       "txn_3QkqKWQhcl5B85Yl0Cd5mGiE",
+			Created:  1737738363,
+			Amount:   2000,
+			Fee:      55,
+			Currency: stripe.CurrencyEUR,
+			Type:     stripe.BalanceTransactionTypeCharge,
+			FeeDetails: []*stripe.BalanceTransactionFeeDetail{
+				{Amount: 55, Currency: stripe.CurrencyEUR, Description: "Stripe processing fees", Type: "stripe_fee"},
+			},
+		},
+		{
+			ID:       "txn_3QkqMaQhcl5B85Yl1Kf0aFzB",
+			Created:  1737824763,
+			Amount:   5000,
+			Fee:      120,
+			Currency: stripe.CurrencyEUR,
+			Type:     stripe.BalanceTransactionTypeCharge,
+			FeeDetails: []*stripe.BalanceTransactionFeeDetail{
+				{Amount: 95, Currency: stripe.CurrencyEUR, Description: "Stripe processing fees", Type: "stripe_fee"},
+				{Amount: 25, Currency: stripe.CurrencyEUR, Type: "application_fee", Application: "ca_RdUmKqZ5d0fK2y"},
+			},
+		},
+		{
+			// Outside of the period
+			ID:       "txn_3QpzBTQhcl5B85Yl0S8uTcQk",
+			Created:  1738972800,
+			Amount:   1000,
+			Fee:      40,
+			Currency: stripe.CurrencyEUR,
+			Type:     stripe.BalanceTransactionTypeCharge,
+			FeeDetails: []*stripe.BalanceTransactionFeeDetail{
+				{Amount: 40, Currency: stripe.CurrencyEUR, Description: "Stripe processing fees", Type: "stripe_fee"},
+			},
+		},
+	}
+}
+
+func TestFromBalanceTransactionFees(t *testing.T) {
+	period := &stripe.Period{
+		Start: 1735689600, // 2025-01-01
+		End:   1738281600, // 2025-01-31
+	}
+
+	t.Run("reverse charge", func(t *testing.T) {
+		account := validStripeAccount()
+		account.Country = "DE"
+
+		inv, err := goblstripe.FromBalanceTransactionFees(validStripeBalanceTransactions(), account, period)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, "FEES-20250101-20250131", inv.Code.String())
+		assert.Equal(t, "fees", inv.Meta[goblstripe.MetaKeyStripeDocType])
+		assert.Equal(t, l10n.TaxCountryCode("IE"), inv.Regime.GetRegime())
+		assert.Equal(t, "Stripe Technology Europe, Limited", inv.Supplier.Name)
+		assert.Equal(t, "Test Account", inv.Customer.Name)
+		assert.True(t, inv.HasTags(tax.TagReverseCharge))
+
+		require.Len(t, inv.Lines, 2)
+		assert.Equal(t, "Stripe processing fees", inv.Lines[0].Item.Name)
+		assert.Equal(t, "1.50", inv.Lines[0].Item.Price.String())
+		assert.Equal(t, "Application fees", inv.Lines[1].Item.Name)
+		assert.Equal(t, "0.25", inv.Lines[1].Item.Price.String())
+		assert.Equal(t, tax.KeyReverseCharge, inv.Lines[0].Taxes[0].Key)
+
+		assert.Equal(t, "1.75", inv.Totals.Payable.String())
+		assert.Equal(t, "0.00", inv.Totals.Due.String())
+	})
+
+	t.Run("tax on fees", func(t *testing.T) {
+		account := validStripeAccount()
+		account.Country = "IE"
+		txns := validStripeBalanceTransactions()
+		txns[0].FeeDetails = append(txns[0].FeeDetails, &stripe.BalanceTransactionFeeDetail{
+			Amount: 13, Currency: stripe.CurrencyEUR, Description: "VAT", Type: "tax",
+		})
+		txns[1].FeeDetails = append(txns[1].FeeDetails, &stripe.BalanceTransactionFeeDetail{
+			Amount: 28, Currency: stripe.CurrencyEUR, Description: "VAT", Type: "tax",
+		})
+		supplier := &org.Party{
+			Name: "Stripe Technology Europe, Limited",
+			TaxID: &tax.Identity{
+				Country: "IE",
+			},
+		}
+
+		inv, err := goblstripe.FromBalanceTransactionFees(txns, account, period, goblstripe.WithFeesSupplier(supplier))
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+
+		assert.False(t, inv.HasTags(tax.TagReverseCharge))
+		require.Len(t, inv.Lines[0].Taxes, 1)
+		assert.Equal(t, tax.CategoryVAT, inv.Lines[0].Taxes[0].Category)
+		assert.Equal(t, tax.RateGeneral, inv.Lines[0].Taxes[0].Rate)
+		assert.Equal(t, "23.0%", inv.Lines[0].Taxes[0].Percent.String())
+		assert.Equal(t, "0.40", inv.Totals.Tax.String())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := goblstripe.FromBalanceTransactionFees(validStripeBalanceTransactions(), nil, period)
+		assert.ErrorContains(t, err, "missing account country")
+
+		account := validStripeAccount()
+		account.Country = "DE"
+		_, err = goblstripe.FromBalanceTransactionFees(nil, account, period)
+		assert.ErrorContains(t, err, "no fees found")
+
+		txns := validStripeBalanceTransactions()
+		txns[1].FeeDetails[0].Currency = stripe.CurrencyUSD
+		_, err = goblstripe.FromBalanceTransactionFees(txns, account, period)
+		assert.ErrorContains(t, err, "different currencies")
+	})
+}
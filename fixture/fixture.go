@@ -0,0 +1,847 @@
+// Package fixture builds the Stripe invoices and credit notes that result from creating
+// GOBL documents in Stripe, to use as test fixtures.
+package fixture
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/tax"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// idAlphabet contains the characters used by Stripe in object IDs.
+const idAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// paymentMethodTypes maps GOBL payment means to the Stripe payment method types
+// used in fixtures, in order of preference.
+var paymentMethodTypes = []struct {
+	means cbc.Key
+	typ   string
+}{
+	{pay.MeansKeyCard, "card"},
+	{pay.MeansKeyDirectDebit, "sepa_debit"},
+	{pay.MeansKeyCreditTransfer, "customer_balance"},
+	{pay.MeansKeyOnline, "link"},
+}
+
+// ToInvoice builds the Stripe invoice that results from creating the objects of a
+// GOBL invoice in Stripe with manual tax rates, as the API returns it once finalized and
+// with all the fields listed in the README expanded. Amounts, discounts and taxes are
+// calculated the way Stripe does, and IDs and timestamps are derived from the invoice,
+// so the same invoice always produces the same fixture. The invoice must be calculated.
+func ToInvoice(inv *bill.Invoice) (*stripe.Invoice, error) {
+	if inv == nil {
+		return nil, fmt.Errorf("missing invoice")
+	}
+	if inv.Type == bill.InvoiceTypeCreditNote {
+		return nil, fmt.Errorf("credit notes are not supported as Stripe invoices")
+	}
+	if inv.Totals == nil {
+		return nil, fmt.Errorf("invoice must be calculated")
+	}
+
+	fb, err := newBuilder(inv)
+	if err != nil {
+		return nil, err
+	}
+	params, items, err := goblstripe.ToInvoice(inv, goblstripe.WithTaxRateRegistry(fb.taxRates), goblstripe.WithCouponRegistry(fb.coupons))
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &stripe.Invoice{
+		ID:                fb.id("in_"),
+		Object:            "invoice",
+		AccountCountry:    fb.regimeDef.Country.String(),
+		AutoAdvance:       true,
+		BillingReason:     stripe.InvoiceBillingReasonManual,
+		CollectionMethod:  stripe.InvoiceCollectionMethod(stripe.StringValue(params.CollectionMethod)),
+		Created:           fb.created,
+		Currency:          stripe.Currency(stripe.StringValue(params.Currency)),
+		Description:       stripe.StringValue(params.Description),
+		DueDate:           stripe.Int64Value(params.DueDate),
+		EffectiveAt:       stripe.Int64Value(params.EffectiveAt),
+		Footer:            stripe.StringValue(params.Footer),
+		Metadata:          params.Metadata,
+		Number:            stripe.StringValue(params.Number),
+		PeriodStart:       fb.created,
+		PeriodEnd:         fb.created,
+		Status:            stripe.InvoiceStatusOpen,
+		StatusTransitions: &stripe.InvoiceStatusTransitions{FinalizedAt: fb.created},
+		Lines:             &stripe.InvoiceLineItemList{},
+	}
+	if doc.CollectionMethod == "" {
+		doc.CollectionMethod = stripe.InvoiceCollectionMethodChargeAutomatically
+	}
+	if doc.EffectiveAt == 0 {
+		doc.EffectiveAt = fb.created
+	}
+	if inv.Ordering != nil && inv.Ordering.Period != nil {
+		doc.PeriodStart = inv.Ordering.Period.Start.TimeIn(fb.regimeDef.TimeLocation()).Unix()
+		doc.PeriodEnd = inv.Ordering.Period.End.TimeIn(fb.regimeDef.TimeLocation()).Unix()
+	}
+	if inv.Ordering != nil {
+		// References added by FromInvoice for subscription invoices and accepted quotes
+		for _, c := range inv.Ordering.Contracts {
+			if c.Type == bill.OrderTypePurchase {
+				doc.Subscription = &stripe.Subscription{ID: c.Code.String()}
+			}
+		}
+		for _, o := range inv.Ordering.Sales {
+			if o.Type == bill.OrderTypeQuote {
+				doc.Quote = &stripe.Quote{Number: o.Code.String()}
+			}
+		}
+	}
+	for _, cf := range params.CustomFields {
+		doc.CustomFields = append(doc.CustomFields, &stripe.InvoiceCustomField{
+			Name:  stripe.StringValue(cf.Name),
+			Value: stripe.StringValue(cf.Value),
+		})
+	}
+	doc.Lines.URL = "/v1/invoices/" + doc.ID + "/lines"
+
+	fb.setAccount(doc)
+	fb.setCustomer(doc)
+
+	for _, item := range items {
+		doc.Lines.Data = append(doc.Lines.Data, fb.invoiceLine(doc, item))
+	}
+	for _, d := range params.Discounts {
+		fb.applyInvoiceDiscount(doc, fb.couponsByID[stripe.StringValue(d.Coupon)])
+	}
+
+	taxes := make(map[string]*stripe.InvoiceTotalTaxAmount)
+	for _, line := range doc.Lines.Data {
+		line.TaxAmounts = fb.invoiceTaxAmounts(line.TaxRates, netAmount(line))
+		doc.Subtotal += line.Amount
+		line.AmountExcludingTax = line.Amount
+		for _, da := range line.DiscountAmounts {
+			doc.TotalDiscountAmounts = appendDiscountTotal(doc.TotalDiscountAmounts, da)
+		}
+		for _, ta := range line.TaxAmounts {
+			doc.Tax += ta.Amount
+			if ta.Inclusive {
+				line.AmountExcludingTax -= ta.Amount
+			}
+			if tt, ok := taxes[ta.TaxRate.ID]; ok {
+				tt.Amount += ta.Amount
+				tt.TaxableAmount += ta.TaxableAmount
+				continue
+			}
+			tt := *ta
+			taxes[ta.TaxRate.ID] = &tt
+			doc.TotalTaxAmounts = append(doc.TotalTaxAmounts, &tt)
+		}
+		doc.SubtotalExcludingTax += line.AmountExcludingTax
+		if line.Quantity != 0 {
+			line.UnitAmountExcludingTax = float64(line.AmountExcludingTax) / float64(line.Quantity)
+		}
+	}
+	doc.TotalExcludingTax = doc.SubtotalExcludingTax
+	doc.Total = doc.Subtotal
+	for _, td := range doc.TotalDiscountAmounts {
+		doc.TotalExcludingTax -= td.Amount
+		doc.Total -= td.Amount
+	}
+	for _, tt := range doc.TotalTaxAmounts {
+		if !tt.Inclusive {
+			doc.Total += tt.Amount
+		}
+	}
+	doc.AmountDue = doc.Total
+	doc.AmountRemaining = doc.Total
+
+	fb.setPayment(doc)
+
+	return doc, nil
+}
+
+// ToCreditNote builds the Stripe credit note that results from creating a GOBL
+// credit note in Stripe with manual tax rates, for a Stripe invoice built from its first
+// preceding document. As with ToInvoice, the fields listed in the README are
+// expanded and the output is deterministic. The credit note must be calculated.
+func ToCreditNote(cn *bill.Invoice) (*stripe.CreditNote, error) {
+	if cn == nil {
+		return nil, fmt.Errorf("missing credit note")
+	}
+	if cn.Type != bill.InvoiceTypeCreditNote {
+		return nil, fmt.Errorf("invalid invoice type %s: expected a credit note", cn.Type)
+	}
+	if cn.Totals == nil {
+		return nil, fmt.Errorf("credit note must be calculated")
+	}
+
+	fb, err := newBuilder(cn)
+	if err != nil {
+		return nil, err
+	}
+	params, err := goblstripe.ToCreditNote(cn, nil, goblstripe.WithTaxRateRegistry(fb.taxRates))
+	if err != nil {
+		return nil, err
+	}
+
+	original := &stripe.Invoice{
+		ID:             stripe.StringValue(params.Invoice),
+		Object:         "invoice",
+		AccountCountry: fb.regimeDef.Country.String(),
+		Currency:       goblstripe.ToCurrency(cn.Currency),
+		Status:         stripe.InvoiceStatusPaid,
+	}
+	if original.ID == "" {
+		original.ID = fb.id("in_")
+	}
+	if len(cn.Preceding) > 0 && cn.Preceding[0] != nil {
+		pre := cn.Preceding[0]
+		if pre.Code.String() != original.ID {
+			original.Number = pre.Series.Join(pre.Code).String()
+		}
+		if pre.IssueDate != nil {
+			original.Created = pre.IssueDate.TimeIn(fb.regimeDef.TimeLocation()).Unix()
+		}
+	}
+	fb.setAccount(original)
+
+	doc := &stripe.CreditNote{
+		ID:          fb.id("cn_"),
+		Object:      "credit_note",
+		Created:     fb.created,
+		Currency:    goblstripe.ToCurrency(cn.Currency),
+		Customer:    fb.customer(),
+		EffectiveAt: stripe.Int64Value(params.EffectiveAt),
+		Invoice:     original,
+		Memo:        stripe.StringValue(params.Memo),
+		Metadata:    params.Metadata,
+		Number:      cn.Series.Join(cn.Code).String(),
+		Reason:      stripe.CreditNoteReason(stripe.StringValue(params.Reason)),
+		Status:      stripe.CreditNoteStatusIssued,
+		Type:        stripe.CreditNoteTypePrePayment,
+		Lines:       &stripe.CreditNoteLineItemList{},
+	}
+	if doc.EffectiveAt == 0 {
+		doc.EffectiveAt = fb.created
+	}
+	doc.Lines.URL = "/v1/credit_notes/" + doc.ID + "/lines"
+
+	taxes := make(map[string]*stripe.CreditNoteTaxAmount)
+	for _, lp := range params.Lines {
+		line := fb.creditNoteLine(lp)
+		doc.Subtotal += line.Amount
+		doc.SubtotalExcludingTax += line.AmountExcludingTax
+		for _, ta := range line.TaxAmounts {
+			if !ta.Inclusive {
+				doc.Total += ta.Amount
+			}
+			if tt, ok := taxes[ta.TaxRate.ID]; ok {
+				tt.Amount += ta.Amount
+				tt.TaxableAmount += ta.TaxableAmount
+				continue
+			}
+			tt := *ta
+			taxes[ta.TaxRate.ID] = &tt
+			doc.TaxAmounts = append(doc.TaxAmounts, &tt)
+		}
+		doc.Lines.Data = append(doc.Lines.Data, line)
+	}
+	doc.TotalExcludingTax = doc.SubtotalExcludingTax
+	doc.Total += doc.Subtotal
+	doc.Amount = doc.Total
+
+	return doc, nil
+}
+
+// ToAccount builds the Stripe account of the supplier of a GOBL document, with the
+// business profile and default tax ID that FromInvoice and FromCreditNote read from it.
+// As with ToInvoice, the output is deterministic.
+func ToAccount(inv *bill.Invoice) (*stripe.Account, error) {
+	if inv == nil {
+		return nil, fmt.Errorf("missing invoice")
+	}
+	if inv.Supplier == nil {
+		return nil, fmt.Errorf("missing supplier")
+	}
+
+	fb, err := newBuilder(inv)
+	if err != nil {
+		return nil, err
+	}
+	return fb.account(), nil
+}
+
+// builder keeps the state shared by the Stripe objects of a fixture: the tax rates
+// and coupons "created" for the document and the sequence used to derive the IDs.
+type builder struct {
+	inv         *bill.Invoice
+	regimeDef   *tax.RegimeDef
+	seed        string
+	seq         int
+	created     int64
+	taxRates    *goblstripe.TaxRateRegistry
+	ratesByID   map[string]*stripe.TaxRate
+	coupons     *goblstripe.CouponRegistry
+	couponsByID map[string]*stripe.Coupon
+}
+
+// newBuilder prepares the tax rates and coupons of a document. It fails when the tax
+// regime can't be determined, as it defines the time zone of the timestamps.
+func newBuilder(inv *bill.Invoice) (*builder, error) {
+	regimeDef := inv.RegimeDef()
+	if regimeDef == nil {
+		return nil, fmt.Errorf("missing tax regime: set the regime or the supplier tax ID")
+	}
+	fb := &builder{
+		inv:         inv,
+		regimeDef:   regimeDef,
+		seed:        inv.UUID.String(),
+		taxRates:    goblstripe.NewTaxRateRegistry(nil),
+		ratesByID:   make(map[string]*stripe.TaxRate),
+		coupons:     goblstripe.NewCouponRegistry(nil),
+		couponsByID: make(map[string]*stripe.Coupon),
+	}
+	if inv.UUID.IsZero() {
+		fb.seed = inv.Series.Join(inv.Code).String()
+	}
+	fb.created = fb.timestamp(inv.IssueDate)
+
+	// Tax rates are created when the operation took place, so the rate lookup
+	// returns the same values when converting back.
+	rateCreated := fb.created
+	if inv.OperationDate != nil {
+		rateCreated = fb.timestamp(*inv.OperationDate)
+	}
+	for _, p := range fb.taxRates.Missing(inv) {
+		rate := &stripe.TaxRate{
+			ID:           fb.id("txr_"),
+			Object:       "tax_rate",
+			Active:       true,
+			Country:      stripe.StringValue(p.Country),
+			Created:      rateCreated,
+			DisplayName:  stripe.StringValue(p.DisplayName),
+			Inclusive:    stripe.BoolValue(p.Inclusive),
+			Jurisdiction: stripe.StringValue(p.Jurisdiction),
+			Metadata:     p.Metadata,
+			Percentage:   stripe.Float64Value(p.Percentage),
+			State:        stripe.StringValue(p.State),
+			TaxType:      stripe.TaxRateTaxType(stripe.StringValue(p.TaxType)),
+		}
+		rate.EffectivePercentage = rate.Percentage
+		fb.ratesByID[rate.ID] = rate
+		fb.taxRates.Add(rate)
+	}
+	for _, p := range fb.coupons.Missing(inv) {
+		c := &stripe.Coupon{
+			ID:         stripe.StringValue(p.ID),
+			Object:     "coupon",
+			AmountOff:  stripe.Int64Value(p.AmountOff),
+			Created:    fb.created,
+			Currency:   stripe.Currency(stripe.StringValue(p.Currency)),
+			Duration:   stripe.CouponDuration(stripe.StringValue(p.Duration)),
+			Name:       stripe.StringValue(p.Name),
+			PercentOff: stripe.Float64Value(p.PercentOff),
+			Valid:      true,
+		}
+		fb.couponsByID[c.ID] = c
+		fb.coupons.Add(c)
+	}
+	return fb, nil
+}
+
+// id returns the next object ID with the given prefix, which looks like a real Stripe ID
+// but is derived from the document.
+func (fb *builder) id(prefix string) string {
+	fb.seq++
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s/%s/%d", fb.seed, prefix, fb.seq))
+	n := new(big.Int).SetBytes(sum[:])
+	base := big.NewInt(int64(len(idAlphabet)))
+	mod := new(big.Int)
+	id := []byte{'1'}
+	for len(id) < 24 {
+		n.DivMod(n, base, mod)
+		id = append(id, idAlphabet[mod.Int64()])
+	}
+	return prefix + string(id)
+}
+
+// timestamp returns the Unix timestamp of a date, at midday in the regime's time zone.
+func (fb *builder) timestamp(d cal.Date) int64 {
+	return time.Date(d.Year, time.Month(d.Month), d.Day, 12, 0, 0, 0, fb.regimeDef.TimeLocation()).Unix()
+}
+
+// taxID builds an expanded Stripe tax ID from the params used to create it.
+func (fb *builder) taxID(params *stripe.CustomerTaxIDDataParams) *stripe.TaxID {
+	return &stripe.TaxID{
+		ID:      fb.id("txi_"),
+		Object:  "tax_id",
+		Created: fb.created,
+		Type:    stripe.TaxIDType(stripe.StringValue(params.Type)),
+		Value:   stripe.StringValue(params.Value),
+	}
+}
+
+// account builds the Stripe account of the supplier.
+func (fb *builder) account() *stripe.Account {
+	params := goblstripe.ToCustomerParams(fb.inv.Supplier)
+	acc := &stripe.Account{
+		ID:      fb.id("acct_")[:21],
+		Object:  "account",
+		Country: fb.regimeDef.Country.String(),
+		Created: fb.created,
+		BusinessProfile: &stripe.AccountBusinessProfile{
+			Name:           fb.inv.Supplier.Name,
+			SupportAddress: toAddress(params.Address),
+			SupportEmail:   stripe.StringValue(params.Email),
+			SupportPhone:   stripe.StringValue(params.Phone),
+		},
+	}
+	if len(params.TaxIDData) > 0 {
+		acc.Settings = &stripe.AccountSettings{
+			Invoices: &stripe.AccountSettingsInvoices{
+				DefaultAccountTaxIDs: []*stripe.TaxID{fb.taxID(params.TaxIDData[0])},
+			},
+		}
+	}
+	return acc
+}
+
+// setAccount sets the account details of the supplier in an invoice.
+func (fb *builder) setAccount(doc *stripe.Invoice) {
+	supplier := fb.inv.Supplier
+	if supplier == nil {
+		return
+	}
+	doc.AccountName = supplier.Name
+	for _, params := range goblstripe.ToCustomerParams(supplier).TaxIDData {
+		doc.AccountTaxIDs = append(doc.AccountTaxIDs, fb.taxID(params))
+	}
+}
+
+// customer builds the expanded Stripe customer of the document. Stripe only exempts the
+// customer from taxes with a reverse charge when the document applies one.
+func (fb *builder) customer() *stripe.Customer {
+	params := goblstripe.ToCustomerParams(fb.inv.Customer)
+	if params == nil {
+		return nil
+	}
+	cus := &stripe.Customer{
+		ID:        fb.id("cus_")[:18],
+		Object:    "customer",
+		Address:   toAddress(params.Address),
+		Created:   fb.created,
+		Currency:  goblstripe.ToCurrency(fb.inv.Currency),
+		Email:     stripe.StringValue(params.Email),
+		Metadata:  params.Metadata,
+		Name:      stripe.StringValue(params.Name),
+		Phone:     stripe.StringValue(params.Phone),
+		TaxExempt: stripe.CustomerTaxExemptNone,
+		TaxIDs:    &stripe.TaxIDList{},
+	}
+	if isReverseCharge(fb.inv) {
+		cus.TaxExempt = stripe.CustomerTaxExemptReverse
+	}
+	if params.Shipping != nil {
+		cus.Shipping = toShipping(params.Shipping)
+	}
+	for _, p := range params.TaxIDData {
+		tID := fb.taxID(p)
+		tID.Customer = &stripe.Customer{ID: cus.ID}
+		cus.TaxIDs.Data = append(cus.TaxIDs.Data, tID)
+	}
+	return cus
+}
+
+// setCustomer sets the expanded customer of an invoice and its snapshot fields.
+func (fb *builder) setCustomer(doc *stripe.Invoice) {
+	cus := fb.customer()
+	if cus == nil {
+		return
+	}
+	doc.Customer = cus
+	doc.CustomerAddress = cus.Address
+	doc.CustomerEmail = cus.Email
+	doc.CustomerName = cus.Name
+	doc.CustomerPhone = cus.Phone
+	doc.CustomerShipping = cus.Shipping
+	doc.CustomerTaxExempt = &cus.TaxExempt
+	for _, tID := range cus.TaxIDs.Data {
+		typ := tID.Type
+		doc.CustomerTaxIDs = append(doc.CustomerTaxIDs, &stripe.InvoiceCustomerTaxID{
+			Type:  &typ,
+			Value: tID.Value,
+		})
+	}
+	if doc.CustomerShipping == nil && fb.inv.Delivery != nil {
+		if shipping := goblstripe.ToCustomerShippingParams(fb.inv.Delivery.Receiver); shipping != nil {
+			doc.CustomerShipping = toShipping(shipping)
+		}
+	}
+}
+
+// invoiceLine builds the invoice line of an invoice item, with its price, product and
+// the item discounts applied one after the other.
+func (fb *builder) invoiceLine(doc *stripe.Invoice, item *stripe.InvoiceItemParams) *stripe.InvoiceLineItem {
+	line := &stripe.InvoiceLineItem{
+		ID:           fb.id("il_"),
+		Object:       "line_item",
+		Currency:     doc.Currency,
+		Description:  stripe.StringValue(item.Description),
+		Discountable: true,
+		Invoice:      doc.ID,
+		InvoiceItem:  &stripe.InvoiceItem{ID: fb.id("ii_")},
+		Metadata:     item.Metadata,
+		Quantity:     stripe.Int64Value(item.Quantity),
+		Type:         stripe.InvoiceLineItemTypeInvoiceItem,
+		Period:       &stripe.Period{Start: doc.PeriodStart, End: doc.PeriodEnd},
+	}
+	if item.Period != nil {
+		line.Period = &stripe.Period{
+			Start: stripe.Int64Value(item.Period.Start),
+			End:   stripe.Int64Value(item.Period.End),
+		}
+	}
+
+	line.Price = &stripe.Price{
+		ID:            fb.id("price_"),
+		Object:        "price",
+		Active:        true,
+		BillingScheme: stripe.PriceBillingSchemePerUnit,
+		Created:       fb.created,
+		Currency:      doc.Currency,
+		TaxBehavior:   stripe.PriceTaxBehaviorUnspecified,
+		Type:          stripe.PriceTypeOneTime,
+		UnitAmount:    stripe.Int64Value(item.UnitAmount),
+	}
+	line.Price.UnitAmountDecimal = float64(line.Price.UnitAmount)
+	line.Amount = line.Quantity * line.Price.UnitAmount
+	if item.UnitAmountDecimal != nil {
+		line.Price.UnitAmountDecimal = *item.UnitAmountDecimal
+		line.Price.UnitAmount = int64(math.Round(*item.UnitAmountDecimal))
+		line.Amount = int64(math.Round(float64(line.Quantity) * *item.UnitAmountDecimal))
+	}
+	line.Price.Product = fb.product(item, line.Price.Created)
+
+	for _, id := range item.TaxRates {
+		line.TaxRates = append(line.TaxRates, fb.ratesByID[stripe.StringValue(id)])
+	}
+
+	remaining := line.Amount
+	for _, d := range item.Discounts {
+		c := fb.couponsByID[stripe.StringValue(d.Coupon)]
+		if c == nil {
+			continue
+		}
+		off := c.AmountOff
+		if c.PercentOff != 0 {
+			off = int64(math.Round(float64(remaining) * c.PercentOff / 100))
+		}
+		off = min(off, remaining)
+		remaining -= off
+		discount := fb.discount(doc, c)
+		discount.InvoiceItem = line.InvoiceItem.ID
+		line.Discounts = append(line.Discounts, discount)
+		line.DiscountAmounts = append(line.DiscountAmounts, &stripe.InvoiceLineItemDiscountAmount{
+			Amount:   off,
+			Discount: discount,
+		})
+	}
+	return line
+}
+
+// product builds the expanded product of an invoice line, reusing the product of the
+// GOBL item when it has a reference.
+func (fb *builder) product(item *stripe.InvoiceItemParams, created int64) *stripe.Product {
+	prod := &stripe.Product{
+		ID:       fb.id("prod_")[:19],
+		Object:   "product",
+		Active:   true,
+		Created:  created,
+		Metadata: item.Metadata,
+		Name:     stripe.StringValue(item.Description),
+		Type:     stripe.ProductTypeService,
+	}
+	for _, line := range fb.inv.Lines {
+		if line == nil || line.Item == nil || line.Item.Ref == "" || line.Item.Name != prod.Name {
+			continue
+		}
+		params := goblstripe.ToProductParams(line.Item)
+		prod.ID = stripe.StringValue(params.ID)
+		prod.Description = stripe.StringValue(params.Description)
+		prod.UnitLabel = stripe.StringValue(params.UnitLabel)
+		prod.Metadata = params.Metadata
+		break
+	}
+	return prod
+}
+
+// discount builds the expanded discount of a coupon applied to an invoice.
+func (fb *builder) discount(doc *stripe.Invoice, c *stripe.Coupon) *stripe.Discount {
+	return &stripe.Discount{
+		ID:       fb.id("di_"),
+		Object:   "discount",
+		Coupon:   c,
+		Customer: doc.Customer,
+		Invoice:  doc.ID,
+		Start:    fb.created,
+	}
+}
+
+// applyInvoiceDiscount spreads an invoice discount over the discountable lines in
+// proportion to their net amounts, as Stripe does.
+func (fb *builder) applyInvoiceDiscount(doc *stripe.Invoice, c *stripe.Coupon) {
+	if c == nil {
+		return
+	}
+	var base int64
+	for _, line := range doc.Lines.Data {
+		if line.Discountable {
+			base += netAmount(line)
+		}
+	}
+	if base == 0 {
+		return
+	}
+	off := c.AmountOff
+	if c.PercentOff != 0 {
+		off = int64(math.Round(float64(base) * c.PercentOff / 100))
+	}
+	off = min(off, base)
+
+	discount := fb.discount(doc, c)
+	doc.Discounts = append(doc.Discounts, discount)
+	allocated := int64(0)
+	last := -1
+	for i, line := range doc.Lines.Data {
+		if line.Discountable {
+			last = i
+		}
+	}
+	for i, line := range doc.Lines.Data {
+		if !line.Discountable {
+			continue
+		}
+		share := int64(math.Round(float64(off) * float64(netAmount(line)) / float64(base)))
+		if i == last {
+			share = off - allocated
+		}
+		allocated += share
+		line.Discounts = append(line.Discounts, discount)
+		line.DiscountAmounts = append(line.DiscountAmounts, &stripe.InvoiceLineItemDiscountAmount{
+			Amount:   share,
+			Discount: discount,
+		})
+	}
+}
+
+// invoiceTaxAmounts calculates the tax amounts of a net amount. Stripe applies manual tax
+// rates as they are, without a taxability reason.
+func (fb *builder) invoiceTaxAmounts(rates []*stripe.TaxRate, net int64) []*stripe.InvoiceTotalTaxAmount {
+	base := taxableAmount(rates, net)
+	var list []*stripe.InvoiceTotalTaxAmount
+	for _, rate := range rates {
+		list = append(list, &stripe.InvoiceTotalTaxAmount{
+			Amount:        int64(math.Round(float64(base) * rate.Percentage / 100)),
+			Inclusive:     rate.Inclusive,
+			TaxRate:       rate,
+			TaxableAmount: base,
+		})
+	}
+	return list
+}
+
+// creditNoteLine builds the custom credit note line created from the params.
+func (fb *builder) creditNoteLine(params *stripe.CreditNoteLineParams) *stripe.CreditNoteLineItem {
+	line := &stripe.CreditNoteLineItem{
+		ID:          fb.id("cnli_"),
+		Object:      "credit_note_line_item",
+		Description: stripe.StringValue(params.Description),
+		Quantity:    stripe.Int64Value(params.Quantity),
+		Type:        stripe.CreditNoteLineItemType(stripe.StringValue(params.Type)),
+		UnitAmount:  stripe.Int64Value(params.UnitAmount),
+	}
+	line.UnitAmountDecimal = float64(line.UnitAmount)
+	line.Amount = line.Quantity * line.UnitAmount
+	if params.UnitAmountDecimal != nil {
+		line.UnitAmountDecimal = *params.UnitAmountDecimal
+		line.UnitAmount = int64(math.Round(*params.UnitAmountDecimal))
+		line.Amount = int64(math.Round(float64(line.Quantity) * *params.UnitAmountDecimal))
+	}
+	for _, id := range params.TaxRates {
+		line.TaxRates = append(line.TaxRates, fb.ratesByID[stripe.StringValue(id)])
+	}
+
+	base := taxableAmount(line.TaxRates, line.Amount)
+	line.AmountExcludingTax = line.Amount
+	for _, rate := range line.TaxRates {
+		ta := &stripe.CreditNoteTaxAmount{
+			Amount:        int64(math.Round(float64(base) * rate.Percentage / 100)),
+			Inclusive:     rate.Inclusive,
+			TaxRate:       rate,
+			TaxableAmount: base,
+		}
+		if ta.Inclusive {
+			line.AmountExcludingTax -= ta.Amount
+		}
+		line.TaxAmounts = append(line.TaxAmounts, ta)
+	}
+	if line.Quantity != 0 {
+		line.UnitAmountExcludingTax = float64(line.AmountExcludingTax) / float64(line.Quantity)
+	}
+	return line
+}
+
+// setPayment sets the payment status of an invoice from the advances and the payment
+// instructions of the GOBL invoice.
+func (fb *builder) setPayment(doc *stripe.Invoice) {
+	p := fb.inv.Payment
+	if p == nil {
+		return
+	}
+
+	if p.Instructions != nil {
+		if typ := paymentMethodType(p.Instructions.Key); typ != "" {
+			doc.PaymentSettings = &stripe.InvoicePaymentSettings{
+				PaymentMethodTypes: []stripe.InvoicePaymentSettingsPaymentMethodType{
+					stripe.InvoicePaymentSettingsPaymentMethodType(typ),
+				},
+			}
+			doc.PaymentIntent = &stripe.PaymentIntent{
+				ID:                 fb.id("pi_"),
+				Object:             "payment_intent",
+				Amount:             doc.AmountDue,
+				Created:            fb.created,
+				Currency:           doc.Currency,
+				PaymentMethodTypes: []string{typ},
+				Status:             stripe.PaymentIntentStatusRequiresPaymentMethod,
+			}
+		}
+	}
+
+	if len(p.Advances) == 0 || fb.inv.Totals.Advances == nil {
+		return
+	}
+	adv := p.Advances[0]
+	doc.AmountPaid = goblstripe.ToStripeInt(fb.inv.Totals.Advances, fb.inv.Currency)
+	doc.AmountRemaining = doc.AmountDue - doc.AmountPaid
+	doc.Charge = &stripe.Charge{
+		ID:          fb.id("ch_"),
+		Object:      "charge",
+		Amount:      doc.AmountPaid,
+		Captured:    true,
+		Created:     fb.created,
+		Currency:    doc.Currency,
+		Description: adv.Description,
+		Paid:        true,
+		Status:      stripe.ChargeStatusSucceeded,
+	}
+	if adv.Date != nil {
+		doc.Charge.Created = fb.timestamp(*adv.Date)
+	}
+	if typ := paymentMethodType(adv.Key); typ != "" {
+		doc.Charge.PaymentMethodDetails = &stripe.ChargePaymentMethodDetails{
+			Type: stripe.ChargePaymentMethodDetailsType(typ),
+		}
+	}
+	if doc.AmountRemaining <= 0 {
+		doc.AmountRemaining = 0
+		doc.Paid = true
+		doc.Status = stripe.InvoiceStatusPaid
+		doc.StatusTransitions.PaidAt = doc.Charge.Created
+		if doc.PaymentIntent != nil {
+			doc.PaymentIntent.Status = stripe.PaymentIntentStatusSucceeded
+		}
+	}
+}
+
+// paymentMethodType returns the Stripe payment method type used in fixtures for a
+// GOBL payment means key.
+func paymentMethodType(key cbc.Key) string {
+	for _, pm := range paymentMethodTypes {
+		if key.Has(pm.means) {
+			return pm.typ
+		}
+	}
+	return ""
+}
+
+// isReverseCharge checks if a GOBL document applies a reverse charge.
+func isReverseCharge(inv *bill.Invoice) bool {
+	if inv.HasTags(tax.TagReverseCharge) {
+		return true
+	}
+	for _, line := range inv.Lines {
+		if line == nil {
+			continue
+		}
+		for _, combo := range line.Taxes {
+			if combo.Key == tax.KeyReverseCharge {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// netAmount returns the amount of a line after its discounts.
+func netAmount(line *stripe.InvoiceLineItem) int64 {
+	net := line.Amount
+	for _, da := range line.DiscountAmounts {
+		net -= da.Amount
+	}
+	return net
+}
+
+// taxableAmount returns the amount the tax rates apply to, which excludes the
+// inclusive taxes.
+func taxableAmount(rates []*stripe.TaxRate, amount int64) int64 {
+	inclusive := 0.0
+	for _, rate := range rates {
+		if rate.Inclusive {
+			inclusive += rate.Percentage
+		}
+	}
+	if inclusive == 0 {
+		return amount
+	}
+	return int64(math.Round(float64(amount) / (1 + inclusive/100)))
+}
+
+// appendDiscountTotal adds a line discount amount to the invoice totals.
+func appendDiscountTotal(totals []*stripe.InvoiceTotalDiscountAmount, da *stripe.InvoiceLineItemDiscountAmount) []*stripe.InvoiceTotalDiscountAmount {
+	for _, t := range totals {
+		if t.Discount == da.Discount {
+			t.Amount += da.Amount
+			return totals
+		}
+	}
+	return append(totals, &stripe.InvoiceTotalDiscountAmount{Amount: da.Amount, Discount: da.Discount})
+}
+
+func toAddress(params *stripe.AddressParams) *stripe.Address {
+	if params == nil {
+		return nil
+	}
+	return &stripe.Address{
+		City:       stripe.StringValue(params.City),
+		Country:    stripe.StringValue(params.Country),
+		Line1:      stripe.StringValue(params.Line1),
+		Line2:      stripe.StringValue(params.Line2),
+		PostalCode: stripe.StringValue(params.PostalCode),
+		State:      stripe.StringValue(params.State),
+	}
+}
+
+func toShipping(params *stripe.CustomerShippingParams) *stripe.ShippingDetails {
+	return &stripe.ShippingDetails{
+		Address: toAddress(params.Address),
+		Name:    stripe.StringValue(params.Name),
+		Phone:   stripe.StringValue(params.Phone),
+	}
+}
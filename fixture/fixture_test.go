@@ -0,0 +1,243 @@
+package fixture_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl.stripe/fixture"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func TestToInvoice(t *testing.T) {
+	inv := testInvoice()
+	inv.UUID = uuid.V7()
+	inv.Lines[0].Discounts = []*bill.LineDiscount{
+		{Reason: "Loyalty", Percent: num.NewPercentage(10, 2)},
+	}
+	require.NoError(t, inv.Calculate())
+
+	doc, err := fixture.ToInvoice(inv)
+	require.NoError(t, err)
+
+	t.Run("expanded fields", func(t *testing.T) {
+		assert.True(t, strings.HasPrefix(doc.ID, "in_"))
+		assert.Equal(t, "DE", doc.AccountCountry)
+		assert.Equal(t, "Test Account", doc.AccountName)
+		require.Len(t, doc.AccountTaxIDs, 1)
+		assert.Equal(t, "DE813495425", doc.AccountTaxIDs[0].Value)
+		assert.NotZero(t, doc.AccountTaxIDs[0].Created)
+
+		require.NotNil(t, doc.Customer)
+		assert.True(t, strings.HasPrefix(doc.Customer.ID, "cus_"))
+		assert.NotZero(t, doc.Customer.Created)
+		assert.Equal(t, "Test Customer", doc.CustomerName)
+
+		require.Len(t, doc.Lines.Data, 1)
+		line := doc.Lines.Data[0]
+		require.NotNil(t, line.Price)
+		require.NotNil(t, line.Price.Product)
+		assert.Equal(t, "Pro Plan", line.Price.Product.Name)
+		require.Len(t, line.Discounts, 1)
+		assert.Equal(t, "Loyalty", line.Discounts[0].Coupon.Name)
+		require.Len(t, line.TaxAmounts, 1)
+		assert.True(t, strings.HasPrefix(line.TaxAmounts[0].TaxRate.ID, "txr_"))
+		require.Len(t, doc.TotalTaxAmounts, 1)
+		assert.Equal(t, 19.0, doc.TotalTaxAmounts[0].TaxRate.Percentage)
+	})
+
+	t.Run("amounts", func(t *testing.T) {
+		assert.Equal(t, int64(10000), doc.Subtotal)
+		require.Len(t, doc.TotalDiscountAmounts, 1)
+		assert.Equal(t, int64(1000), doc.TotalDiscountAmounts[0].Amount)
+		assert.Equal(t, int64(1710), doc.Tax)
+		assert.Equal(t, int64(10710), doc.Total)
+		assert.Equal(t, int64(10710), doc.AmountDue)
+		assert.Equal(t, stripe.InvoiceStatusOpen, doc.Status)
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		again, err := fixture.ToInvoice(inv)
+		require.NoError(t, err)
+		assert.Equal(t, doc, again)
+	})
+
+	t.Run("converts back through JSON", func(t *testing.T) {
+		data, err := json.Marshal(doc)
+		require.NoError(t, err)
+		parsed := new(stripe.Invoice)
+		require.NoError(t, json.Unmarshal(data, parsed))
+
+		gi, err := goblstripe.FromInvoice(parsed, nil)
+		require.NoError(t, err)
+		require.NoError(t, gi.Calculate())
+		assert.Equal(t, inv.UUID, gi.UUID)
+		assert.Equal(t, inv.Supplier.TaxID.String(), gi.Supplier.TaxID.String())
+		assert.Equal(t, inv.Totals.Payable.String(), gi.Totals.Payable.String())
+		assert.Equal(t, inv.Totals.Tax.String(), gi.Totals.Tax.String())
+	})
+
+	t.Run("paid invoice", func(t *testing.T) {
+		inv := testInvoice()
+		inv.Payment = &bill.PaymentDetails{
+			Instructions: &pay.Instructions{Key: pay.MeansKeyCard},
+			Advances: []*pay.Advance{
+				{Key: pay.MeansKeyCard, Description: "Card payment", Percent: num.NewPercentage(1, 0)},
+			},
+		}
+		require.NoError(t, inv.Calculate())
+
+		doc, err := fixture.ToInvoice(inv)
+		require.NoError(t, err)
+		assert.True(t, doc.Paid)
+		assert.Equal(t, stripe.InvoiceStatusPaid, doc.Status)
+		assert.Equal(t, doc.Total, doc.AmountPaid)
+		assert.Zero(t, doc.AmountRemaining)
+		require.NotNil(t, doc.Charge)
+		assert.Equal(t, "Card payment", doc.Charge.Description)
+		require.NotNil(t, doc.PaymentIntent)
+		assert.Equal(t, []string{"card"}, doc.PaymentIntent.PaymentMethodTypes)
+	})
+
+	t.Run("not calculated", func(t *testing.T) {
+		_, err := fixture.ToInvoice(testInvoice())
+		assert.ErrorContains(t, err, "must be calculated")
+	})
+
+	t.Run("missing regime", func(t *testing.T) {
+		inv := testInvoice()
+		require.NoError(t, inv.Calculate())
+		inv.Regime = tax.Regime{}
+		inv.Supplier.TaxID = nil
+		_, err := fixture.ToInvoice(inv)
+		assert.ErrorContains(t, err, "missing tax regime")
+	})
+}
+
+func TestToCreditNote(t *testing.T) {
+	cn := testCreditNote()
+	cn.UUID = uuid.V7()
+	require.NoError(t, cn.Calculate())
+
+	doc, err := fixture.ToCreditNote(cn)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(doc.ID, "cn_"))
+	assert.Equal(t, "CN-0001", doc.Number)
+	assert.Equal(t, stripe.CreditNoteReasonDuplicate, doc.Reason)
+	require.NotNil(t, doc.Invoice)
+	assert.Equal(t, "SAMPLE-0001", doc.Invoice.Number)
+	require.Len(t, doc.Invoice.AccountTaxIDs, 1)
+	require.NotNil(t, doc.Customer)
+	require.Len(t, doc.Lines.Data, 1)
+	require.Len(t, doc.Lines.Data[0].TaxAmounts, 1)
+	assert.NotNil(t, doc.Lines.Data[0].TaxAmounts[0].TaxRate)
+	assert.Equal(t, int64(11900), doc.Total)
+
+	gi, err := goblstripe.FromCreditNote(doc, nil)
+	require.NoError(t, err)
+	require.NoError(t, gi.Calculate())
+	assert.Equal(t, cn.UUID, gi.UUID)
+	assert.Equal(t, cn.Totals.Payable.String(), gi.Totals.Payable.String())
+	assert.Equal(t, "SAMPLE", gi.Preceding[0].Series.String())
+	assert.Equal(t, "0001", gi.Preceding[0].Code.String())
+
+	t.Run("invoice", func(t *testing.T) {
+		_, err := fixture.ToCreditNote(testInvoice())
+		assert.ErrorContains(t, err, "expected a credit note")
+	})
+}
+
+func TestToAccount(t *testing.T) {
+	inv := testInvoice()
+	inv.UUID = uuid.V7()
+	inv.Supplier.Emails = []*org.Email{{Address: "billing@example.com"}}
+	require.NoError(t, inv.Calculate())
+
+	acc, err := fixture.ToAccount(inv)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(acc.ID, "acct_"))
+	assert.Equal(t, "DE", acc.Country)
+	assert.Equal(t, "Test Account", acc.BusinessProfile.Name)
+	assert.Equal(t, "billing@example.com", acc.BusinessProfile.SupportEmail)
+	require.Len(t, acc.Settings.Invoices.DefaultAccountTaxIDs, 1)
+	assert.Equal(t, "DE813495425", acc.Settings.Invoices.DefaultAccountTaxIDs[0].Value)
+
+	supplier := goblstripe.NewSupplierFromAccount(acc)
+	assert.Equal(t, inv.Supplier.Name, supplier.Name)
+	assert.Equal(t, inv.Supplier.TaxID.String(), supplier.TaxID.String())
+
+	t.Run("missing supplier", func(t *testing.T) {
+		inv := testInvoice()
+		inv.Supplier = nil
+		_, err := fixture.ToAccount(inv)
+		assert.ErrorContains(t, err, "missing supplier")
+	})
+}
+
+func testInvoice() *bill.Invoice {
+	return &bill.Invoice{
+		Regime:   tax.WithRegime("DE"),
+		Type:     bill.InvoiceTypeStandard,
+		Series:   "SAMPLE",
+		Code:     "0001",
+		Currency: currency.EUR,
+		Supplier: &org.Party{
+			Name: "Test Account",
+			TaxID: &tax.Identity{
+				Country: "DE",
+				Code:    "813495425",
+			},
+		},
+		Customer: &org.Party{
+			Name: "Test Customer",
+			TaxID: &tax.Identity{
+				Country: "DE",
+				Code:    "282741168",
+			},
+		},
+		IssueDate: cal.MakeDate(2025, 1, 15),
+		Lines: []*bill.Line{
+			{
+				Quantity: num.MakeAmount(2, 0),
+				Item: &org.Item{
+					Name:  "Pro Plan",
+					Price: num.NewAmount(5000, 2),
+				},
+				Taxes: tax.Set{
+					{
+						Category: tax.CategoryVAT,
+						Rate:     tax.RateGeneral,
+					},
+				},
+			},
+		},
+	}
+}
+
+func testCreditNote() *bill.Invoice {
+	cn := testInvoice()
+	cn.Type = bill.InvoiceTypeCreditNote
+	cn.Code = "CN-0001"
+	cn.Series = ""
+	cn.Preceding = []*org.DocumentRef{
+		{
+			Series:    "SAMPLE",
+			Code:      "0001",
+			IssueDate: cal.NewDate(2025, 1, 15),
+			Reason:    "Duplicate",
+		},
+	}
+	return cn
+}
@@ -2,6 +2,7 @@ package goblstripe
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -25,23 +26,206 @@ const (
 
 // Document type constants used in the Stripe to GOBL conversion
 const (
-	StripeDocTypeInvoice    = "invoice"
-	StripeDocTypeCreditNote = "credit_note"
+	StripeDocTypeInvoice             = "invoice"
+	StripeDocTypeCreditNote          = "credit_note"
+	StripeDocTypeCharge              = "charge"
+	StripeDocTypeRefund              = "refund"
+	StripeDocTypeQuote               = "quote"
+	StripeDocTypeSubscription        = "subscription"
+	StripeDocTypeCheckoutSession     = "checkout_session"
+	StripeDocTypeTaxTransaction      = "tax_transaction"
+	StripeDocTypeFees                = "fees"
+	StripeDocTypeApplicationFees     = "application_fees"
+	StripeDocTypeInvoiceCancellation = "invoice_cancellation"
 )
 
+// MetaKeyGOBLUUID is the Stripe metadata key that keeps the UUID of the GOBL document or
+// party a Stripe object was created from. It is used to avoid duplicates when pushing the
+// same GOBL document again, and to keep the same UUID when converting back to GOBL.
+const MetaKeyGOBLUUID = "gobl-uuid"
+
+// fromInvoiceActionRevision is the relation of a Stripe invoice with the invoice it was
+// cloned from when it revises it.
+const fromInvoiceActionRevision = "revision"
+
+// revisionReason is the reason given in the reference to the invoice replaced by a
+// revision.
+const revisionReason = "Invoice revised"
+
 // Custom field constants used in the Stripe to GOBL conversion
 const (
-	CustomFieldPONumber = "po number"
+	CustomFieldPONumber      = "po number"
+	CustomFieldPONumberLabel = "PO Number" // Name used when creating the custom field in Stripe
 )
 
-// ToInvoice converts a GOBL bill.Invoice into a stripe invoice object.
-// TODO: Implement
-/*func ToInvoice(inv *bill.Invoice) (*stripe.Invoice, error) {
-	return nil, nil
-}*/
+// ParamsOption is a functional option for the conversions from GOBL into Stripe params.
+type ParamsOption func(*paramsOptions)
+
+type paramsOptions struct {
+	taxRates *TaxRateRegistry
+	coupons  *CouponRegistry
+	supplier *org.Party
+}
+
+// WithTaxRateRegistry provides the registry used to set the Stripe tax rates of each
+// line. All the tax rates used in the document must already be registered (see
+// TaxRateRegistry.Missing); otherwise, the conversion fails.
+func WithTaxRateRegistry(r *TaxRateRegistry) ParamsOption {
+	return func(o *paramsOptions) {
+		o.taxRates = r
+	}
+}
+
+// WithCouponRegistry provides the registry of existing Stripe coupons to apply the
+// discounts with. Discounts without a registered coupon use the ID of the coupon to
+// be created from the params returned by CouponRegistry.Missing.
+func WithCouponRegistry(r *CouponRegistry) ParamsOption {
+	return func(o *paramsOptions) {
+		o.coupons = r
+	}
+}
+
+// WithSupplier provides the supplier of the document, used to determine the tax
+// exemption of the customer.
+func WithSupplier(supplier *org.Party) ParamsOption {
+	return func(o *paramsOptions) {
+		o.supplier = supplier
+	}
+}
+
+// ToInvoice converts a GOBL bill.Invoice into the Stripe invoice params and the invoice
+// item params for each of its lines. Stripe requires the invoice items to be created
+// for the customer before (or attached to) the invoice, so the customer and invoice IDs
+// still need to be set on the params before sending them to the Stripe API.
+func ToInvoice(inv *bill.Invoice, opts ...ParamsOption) (*stripe.InvoiceParams, []*stripe.InvoiceItemParams, error) {
+	options := newParamsOptions(opts)
+	if inv == nil {
+		return nil, nil, fmt.Errorf("missing invoice")
+	}
+	if inv.Type == bill.InvoiceTypeCreditNote {
+		return nil, nil, fmt.Errorf("credit notes are not supported as Stripe invoices")
+	}
+	if inv.Currency == "" {
+		return nil, nil, fmt.Errorf("missing currency")
+	}
+
+	regimeDef := regimeFromGOBLInvoice(inv)
+
+	params := &stripe.InvoiceParams{
+		Currency: stripe.String(string(ToCurrency(inv.Currency))),
+	}
+	if !inv.UUID.IsZero() {
+		params.AddMetadata(MetaKeyGOBLUUID, inv.UUID.String())
+	}
+
+	if number := toInvoiceNumber(inv.Series, inv.Code); number != "" {
+		params.Number = stripe.String(number)
+	}
+
+	if inv.OperationDate != nil {
+		params.EffectiveAt = stripe.Int64(toTSFromDate(*inv.OperationDate, regimeDef.TimeLocation()))
+	}
+
+	if dueDate := toDueDate(inv.Payment); dueDate != nil {
+		// Stripe only accepts a due date on invoices that are sent to the customer.
+		params.CollectionMethod = stripe.String(string(stripe.InvoiceCollectionMethodSendInvoice))
+		params.DueDate = stripe.Int64(toTSFromDate(*dueDate, regimeDef.TimeLocation()))
+	}
+
+	description, footer := toInvoiceNotes(inv.Notes)
+	if description != "" {
+		params.Description = stripe.String(description)
+	}
+	if footer != "" {
+		params.Footer = stripe.String(footer)
+	}
+
+	if inv.Ordering != nil && inv.Ordering.Code != "" {
+		params.CustomFields = []*stripe.InvoiceCustomFieldParams{
+			{
+				Name:  stripe.String(CustomFieldPONumberLabel),
+				Value: stripe.String(inv.Ordering.Code.String()),
+			},
+		}
+	}
+
+	params.Discounts = options.coupons.toInvoiceDiscountsParams(inv.Discounts, inv.Currency)
+
+	pricesInclude := pricesIncludeFromInvoice(inv)
+	items := make([]*stripe.InvoiceItemParams, 0, len(inv.Lines))
+	for _, line := range inv.Lines {
+		if line == nil || line.Item == nil {
+			continue
+		}
+		lineItems := toInvoiceLineItemsParams(line, inv.Currency, regimeDef, options.coupons)
+		if options.taxRates != nil {
+			// Line charges are taxed like the line itself
+			ids, err := options.taxRates.taxRateIDs(line.Taxes, pricesInclude, regimeDef, inv.Notes)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, item := range lineItems {
+				item.TaxRates = ids
+			}
+		}
+		items = append(items, lineItems...)
+	}
+
+	return params, items, nil
+}
+
+// newParamsOptions applies the functional options for the conversions into Stripe params.
+func newParamsOptions(opts []ParamsOption) *paramsOptions {
+	options := &paramsOptions{
+		coupons: NewCouponRegistry(nil),
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(options)
+		}
+	}
+	return options
+}
+
+// InvoiceOption is a functional option for FromInvoice.
+type InvoiceOption func(*invoiceOptions)
+
+type invoiceOptions struct {
+	creditNoteRevisions []l10n.TaxCountryCode
+	allRevisions        bool
+}
+
+// WithCreditNoteRevisions converts the revisions of invoices issued in the given regimes,
+// or in any regime when none are given, into new standard invoices, even when the regime
+// accepts corrective invoices. The revised invoice is then cancelled with a credit note
+// (see FromVoidedInvoice).
+func WithCreditNoteRevisions(regimes ...l10n.TaxCountryCode) InvoiceOption {
+	return func(o *invoiceOptions) {
+		if len(regimes) == 0 {
+			o.allRevisions = true
+		}
+		o.creditNoteRevisions = append(o.creditNoteRevisions, regimes...)
+	}
+}
+
+// correctiveRevisions checks if the revisions of invoices issued in a regime are
+// converted into corrective invoices.
+func (o *invoiceOptions) correctiveRevisions(regimeDef *tax.RegimeDef) bool {
+	if o.allRevisions || slices.Contains(o.creditNoteRevisions, regimeDef.Country) {
+		return false
+	}
+	return regimeSupportsCorrective(regimeDef)
+}
 
 // FromInvoice converts a stripe invoice object into a GOBL bill.Invoice.
-func FromInvoice(doc *stripe.Invoice, account *stripe.Account) (*bill.Invoice, error) {
+func FromInvoice(doc *stripe.Invoice, account *stripe.Account, opts ...InvoiceOption) (*bill.Invoice, error) {
+	var options invoiceOptions
+	for _, o := range opts {
+		if o != nil {
+			o(&options)
+		}
+	}
+
 	inv := new(bill.Invoice)
 	inv.Type = bill.InvoiceTypeStandard
 
@@ -50,7 +234,7 @@ func FromInvoice(doc *stripe.Invoice, account *stripe.Account) (*bill.Invoice, e
 		return nil, err
 	}
 
-	inv.UUID = uuid.V7() // Generated randomly, but you can modify afterwards for the specific use case.
+	inv.UUID = uuidFromMetadata(doc.Metadata, uuid.V7) // Generated randomly when the invoice was not created from GOBL.
 
 	if doc.Number != "" {
 		// Split the invoice number by "-" to separate series and code
@@ -70,6 +254,13 @@ func FromInvoice(doc *stripe.Invoice, account *stripe.Account) (*bill.Invoice, e
 		MetaKeyStripeDocType: StripeDocTypeInvoice,
 	}
 
+	if from := revisedInvoice(doc); from != nil {
+		inv.Preceding = []*org.DocumentRef{newPrecedingFromRevision(from, regimeDef)}
+		if options.correctiveRevisions(regimeDef) {
+			inv.Type = bill.InvoiceTypeCorrective
+		}
+	}
+
 	if doc.EffectiveAt != 0 {
 		inv.OperationDate = newDateFromTS(doc.EffectiveAt, regimeDef.TimeLocation()) // Date when the operation defined by the invoice became effective
 	}
@@ -98,6 +289,8 @@ func FromInvoice(doc *stripe.Invoice, account *stripe.Account) (*bill.Invoice, e
 	inv.Delivery = newDelivery(doc)
 	inv.Payment = newPayment(doc, regimeDef)
 	inv.Notes = newInvoiceNotes(doc.Description, doc.Footer)
+	inv.Notes = append(inv.Notes, newInvoiceSalesTaxNotes(doc.TotalTaxAmounts, inv.Currency)...)
+	applyCrossBorderKeys(inv, regimeDef)
 
 	//Remaining fields
 	//Discounts: for the moment not considered in general (only in lines)
@@ -140,7 +333,7 @@ func FromCreditNote(doc *stripe.CreditNote, account *stripe.Account, opts ...Cre
 		return nil, err
 	}
 
-	inv.UUID = uuid.V4() // Generated randomly, but you can modify afterwards for the specific use case.
+	inv.UUID = uuidFromMetadata(doc.Metadata, uuid.V4) // Generated randomly when the credit note was not created from GOBL.
 
 	if doc.Number != "" {
 		inv.Code = cbc.Code(doc.Number)
@@ -184,6 +377,8 @@ func FromCreditNote(doc *stripe.CreditNote, account *stripe.Account, opts ...Cre
 		inv.Preceding = []*org.DocumentRef{newPrecedingFromInvoice(doc.Invoice, string(doc.Reason), regimeDef)}
 	}
 	inv.Notes = newCreditNoteNotes(doc.Memo)
+	inv.Notes = append(inv.Notes, newCreditNoteSalesTaxNotes(doc.TaxAmounts, inv.Currency)...)
+	applyCrossBorderKeys(inv, regimeDef)
 
 	if err := AdjustRounding(inv, doc.Total, doc.Currency); err != nil {
 		return inv, err
@@ -192,6 +387,143 @@ func FromCreditNote(doc *stripe.CreditNote, account *stripe.Account, opts ...Cre
 	return inv, nil
 }
 
+// StripeInvoiceIDPrefix is the prefix of every Stripe invoice ID, used to tell them
+// apart from invoice numbers in document references.
+const StripeInvoiceIDPrefix = "in_"
+
+// creditNoteReasons lists the reasons accepted by Stripe for credit notes.
+var creditNoteReasons = []stripe.CreditNoteReason{
+	stripe.CreditNoteReasonDuplicate,
+	stripe.CreditNoteReasonFraudulent,
+	stripe.CreditNoteReasonOrderChange,
+	stripe.CreditNoteReasonProductUnsatisfactory,
+}
+
+// ToCreditNote converts a GOBL credit note into Stripe credit note params. The original
+// Stripe invoice must be one of the documents referenced in the credit note's preceding
+// list, and its lines are used to credit the matching invoice line items directly. When
+// no original invoice is provided, all lines are added as custom lines and the Stripe
+// invoice ID is only set if the preceding document code is a Stripe invoice ID.
+func ToCreditNote(cn *bill.Invoice, original *stripe.Invoice, opts ...ParamsOption) (*stripe.CreditNoteParams, error) {
+	options := newParamsOptions(opts)
+	if cn == nil {
+		return nil, fmt.Errorf("missing credit note")
+	}
+	if cn.Type != bill.InvoiceTypeCreditNote {
+		return nil, fmt.Errorf("invalid invoice type %s: expected a credit note", cn.Type)
+	}
+	if cn.Currency == "" {
+		return nil, fmt.Errorf("missing currency")
+	}
+
+	regimeDef := regimeFromGOBLInvoice(cn)
+	params := new(stripe.CreditNoteParams)
+	if !cn.UUID.IsZero() {
+		params.AddMetadata(MetaKeyGOBLUUID, cn.UUID.String())
+	}
+
+	var pre *org.DocumentRef
+	var originalLines []*stripe.InvoiceLineItem
+	if original != nil {
+		pre = findPrecedingForInvoice(cn.Preceding, original)
+		if pre == nil {
+			return nil, fmt.Errorf("credit note does not reference Stripe invoice %s", original.ID)
+		}
+		params.Invoice = stripe.String(original.ID)
+		if original.Lines != nil {
+			originalLines = original.Lines.Data
+		}
+	} else if len(cn.Preceding) > 0 {
+		pre = cn.Preceding[0]
+		if strings.HasPrefix(pre.Code.String(), StripeInvoiceIDPrefix) {
+			params.Invoice = stripe.String(pre.Code.String())
+		}
+	}
+
+	memo := toCreditNoteMemo(cn.Notes)
+	if pre != nil && pre.Reason != "" {
+		if reason := toCreditNoteReason(pre.Reason); reason != "" {
+			params.Reason = stripe.String(string(reason))
+		} else if memo == "" {
+			// Keep the reason when it can't be expressed with one of Stripe's reasons.
+			memo = pre.Reason
+		}
+	}
+	if memo != "" {
+		params.Memo = stripe.String(memo)
+	}
+
+	if cn.OperationDate != nil {
+		params.EffectiveAt = stripe.Int64(toTSFromDate(*cn.OperationDate, regimeDef.TimeLocation()))
+	}
+
+	params.Lines = ToCreditNoteLinesParams(cn.Lines, cn.Currency, originalLines, regimeDef)
+
+	if options.taxRates != nil {
+		// Lines crediting an invoice line item take the tax rates of the original line.
+		pricesInclude := pricesIncludeFromInvoice(cn)
+		i := 0
+		for _, line := range cn.Lines {
+			if line == nil || line.Item == nil {
+				continue
+			}
+			cnLine := params.Lines[i]
+			i++
+			if stripe.StringValue(cnLine.Type) != string(stripe.CreditNoteLineItemTypeCustomLineItem) {
+				continue
+			}
+			ids, err := options.taxRates.taxRateIDs(line.Taxes, pricesInclude, regimeDef, cn.Notes)
+			if err != nil {
+				return nil, err
+			}
+			cnLine.TaxRates = ids
+		}
+	}
+
+	return params, nil
+}
+
+// findPrecedingForInvoice finds the document reference that points at the Stripe
+// invoice, either by its ID or by its number.
+func findPrecedingForInvoice(preceding []*org.DocumentRef, doc *stripe.Invoice) *org.DocumentRef {
+	for _, ref := range preceding {
+		if ref == nil {
+			continue
+		}
+		if ref.Code.String() == doc.ID {
+			return ref
+		}
+		if doc.Number != "" && (toInvoiceNumber(ref.Series, ref.Code) == doc.Number || ref.Code.String() == doc.Number) {
+			return ref
+		}
+	}
+	return nil
+}
+
+// toCreditNoteReason maps the reason of a GOBL document reference into one of the
+// credit note reasons accepted by Stripe. It returns an empty reason when there is no
+// match.
+func toCreditNoteReason(reason string) stripe.CreditNoteReason {
+	r := strings.ToLower(strings.TrimSpace(reason))
+	r = strings.NewReplacer(" ", "_", "-", "_").Replace(r)
+	for _, cr := range creditNoteReasons {
+		if string(cr) == r {
+			return cr
+		}
+	}
+	return ""
+}
+
+// toCreditNoteMemo joins the GOBL notes into a Stripe credit note memo, the reverse
+// of newCreditNoteNotes.
+func toCreditNoteMemo(notes []*org.Note) string {
+	description, footer := toInvoiceNotes(notes)
+	if footer == "" {
+		return description
+	}
+	return description + "\n\n" + footer
+}
+
 // newDateFromTS creates a cal date object from a Unix timestamp.
 // If a location is provided, the date will be converted to that timezone.
 // Note: For certain date-only fields (such as `effective_at`), Stripe stores timestamps as midnight
@@ -206,6 +538,66 @@ func newDateFromTS(ts int64, loc *time.Location) *cal.Date {
 	return &d
 }
 
+// uuidFromMetadata returns the GOBL UUID stored in the metadata of a Stripe object, or a
+// new one from the generator when there is none.
+func uuidFromMetadata(metadata map[string]string, generate func() uuid.UUID) uuid.UUID {
+	if id, err := uuid.Parse(metadata[MetaKeyGOBLUUID]); err == nil && !id.IsZero() {
+		return id
+	}
+	return generate()
+}
+
+// toTSFromDate creates a Unix timestamp from a cal date at midnight in the given location.
+// It is the reverse of newDateFromTS for date-only fields.
+func toTSFromDate(d cal.Date, loc *time.Location) int64 {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return d.TimeIn(loc).Unix()
+}
+
+// regimeFromGOBLInvoice provides the tax regime definition of a GOBL invoice, falling
+// back to the supplier's tax ID country when the regime has not been set.
+func regimeFromGOBLInvoice(inv *bill.Invoice) *tax.RegimeDef {
+	if rd := inv.RegimeDef(); rd != nil {
+		return rd
+	}
+	if inv.Supplier != nil && inv.Supplier.TaxID != nil {
+		return tax.RegimeDefFor(inv.Supplier.TaxID.Country.Code())
+	}
+	return nil
+}
+
+// toInvoiceNumber joins the series and code of a GOBL invoice into a Stripe invoice
+// number, the reverse of the split done when converting from Stripe.
+func toInvoiceNumber(series, code cbc.Code) string {
+	if code == "" {
+		return ""
+	}
+	if series == "" {
+		return code.String()
+	}
+	return series.String() + "-" + code.String()
+}
+
+// toDueDate picks the latest due date from the GOBL payment terms, as Stripe invoices
+// only support a single due date.
+func toDueDate(payment *bill.PaymentDetails) *cal.Date {
+	if payment == nil || payment.Terms == nil {
+		return nil
+	}
+	var dueDate *cal.Date
+	for _, dd := range payment.Terms.DueDates {
+		if dd == nil || dd.Date == nil {
+			continue
+		}
+		if dueDate == nil || dd.Date.Time().After(dueDate.Time()) {
+			dueDate = dd.Date
+		}
+	}
+	return dueDate
+}
+
 // regimeFromInvoice creates a tax regime definition from a Stripe invoice.
 func regimeFromInvoice(doc *stripe.Invoice) (*tax.RegimeDef, error) {
 	if doc.AccountCountry == "" {
@@ -242,6 +634,37 @@ func newPrecedingFromInvoice(doc *stripe.Invoice, reason string, regimeDef *tax.
 	return docRef
 }
 
+// revisedInvoice returns the invoice revised by a Stripe invoice, or nil when the
+// invoice is not a revision.
+func revisedInvoice(doc *stripe.Invoice) *stripe.Invoice {
+	if doc.FromInvoice == nil || doc.FromInvoice.Action != fromInvoiceActionRevision {
+		return nil
+	}
+	return doc.FromInvoice.Invoice
+}
+
+// newPrecedingFromRevision creates a reference to the invoice replaced by a revision.
+// When the revised invoice is not expanded, only its ID is known.
+func newPrecedingFromRevision(from *stripe.Invoice, regimeDef *tax.RegimeDef) *org.DocumentRef {
+	if from.Created == 0 {
+		return &org.DocumentRef{
+			Type:   bill.InvoiceTypeStandard,
+			Code:   cbc.Code(from.ID),
+			Reason: revisionReason,
+		}
+	}
+	return newPrecedingFromInvoice(from, revisionReason, regimeDef)
+}
+
+// regimeSupportsCorrective checks if the regime allows invoices to be corrected with a
+// corrective invoice that replaces them. Otherwise, the revised invoice is cancelled
+// with a credit note (see FromVoidedInvoice) and the revision is a new invoice, which
+// can also be chosen with WithCreditNoteRevisions.
+func regimeSupportsCorrective(regimeDef *tax.RegimeDef) bool {
+	cd := regimeDef.Corrections.Def(bill.ShortSchemaInvoice)
+	return cd != nil && slices.Contains(cd.Types, bill.InvoiceTypeCorrective)
+}
+
 // newPrecedingFromGOBLInvoice creates a document reference from a GOBL invoice.
 func newPrecedingFromGOBLInvoice(inv *bill.Invoice, reason string) *org.DocumentRef {
 	docRef := &org.DocumentRef{
@@ -341,6 +764,16 @@ func newOrdering(doc *stripe.Invoice, lines []*bill.Line, regimeDef *tax.RegimeD
 		}
 	}
 
+	if doc.Subscription != nil {
+		// Recurring invoices reference the GOBL order of their subscription
+		ordering.Contracts = []*org.DocumentRef{newContractFromSubscription(doc.Subscription, regimeDef)}
+	}
+
+	if doc.Quote != nil {
+		// Invoices created when accepting a quote reference its GOBL order
+		ordering.Sales = []*org.DocumentRef{newPrecedingFromQuote(doc.Quote, regimeDef)}
+	}
+
 	if doc.CustomFields != nil {
 		for _, field := range doc.CustomFields {
 			if strings.ToLower(strings.TrimSpace(field.Name)) == CustomFieldPONumber {
@@ -372,6 +805,38 @@ func newCreditNoteNotes(memo string) []*org.Note {
 	return nil
 }
 
+// toInvoiceNotes converts GOBL notes into a Stripe description and footer, the reverse
+// of newInvoiceNotes. The first note is used as the description and any further notes
+// are joined into the footer. Notes generated when converting from Stripe are left
+// out, as they are generated again when converting back.
+func toInvoiceNotes(notes []*org.Note) (string, string) {
+	var texts []string
+	for _, n := range notes {
+		if n == nil || strings.TrimSpace(n.Text) == "" || isGeneratedNote(n) {
+			continue
+		}
+		texts = append(texts, strings.ReplaceAll(n.Text, "<br>", "\n"))
+	}
+	if len(texts) == 0 {
+		return "", ""
+	}
+	return texts[0], strings.Join(texts[1:], "\n\n")
+}
+
+// isGeneratedNote checks if a note is generated when converting from Stripe: the tax
+// notes of sales tax jurisdictions (see newSalesTaxNote), or a legal note generated for
+// a tax key, either for a cross-border supply by applyCrossBorderKeys or by GOBL for
+// the reverse-charge tag.
+func isGeneratedNote(n *org.Note) bool {
+	if n.Meta[MetaKeyStripeJurisdiction] != "" {
+		return true
+	}
+	if n.Key != org.NoteKeyLegal {
+		return false
+	}
+	return n.Src == tax.TagReverseCharge || crossBorderNotes[n.Src] != ""
+}
+
 // newNote creates a single note with src "stripe" and optional key.
 func newNote(text string, key cbc.Key) *org.Note {
 	text = strings.TrimSpace(text)
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl.stripe/fixture"
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cal"
 	"github.com/invopop/gobl/cbc"
@@ -14,7 +15,9 @@ import (
 	"github.com/invopop/gobl/l10n"
 	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/pay"
 	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stripe/stripe-go/v81"
@@ -848,6 +851,243 @@ func TestNewOrdering(t *testing.T) {
 	})
 }
 
+func TestStackedTaxCategories(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	gst := &stripe.TaxRate{Country: "CA", TaxType: stripe.TaxRateTaxTypeGST, Percentage: 5, Created: created}
+	pst := &stripe.TaxRate{Country: "CA", State: "BC", TaxType: stripe.TaxRateTaxTypePST, Percentage: 7, Created: created}
+
+	s := minimalStripeInvoice()
+	s.AccountCountry = "CA"
+	s.Currency = stripe.CurrencyCAD
+	line := s.Lines.Data[0]
+	line.Currency = stripe.CurrencyCAD
+	line.TaxAmounts = []*stripe.InvoiceTotalTaxAmount{
+		{Amount: 100, TaxRate: gst},
+		{Amount: 140, TaxRate: pst},
+	}
+	s.TotalTaxAmounts = line.TaxAmounts
+	s.Total = 2240
+	s.AmountPaid = 2240
+
+	account := validStripeAccount()
+	account.Country = "CA"
+	gi, err := goblstripe.FromInvoice(s, account)
+	require.NoError(t, err)
+
+	require.Len(t, gi.Lines[0].Taxes, 2)
+	assert.Equal(t, "22.40", gi.Totals.TotalWithTax.String())
+	assert.Nil(t, gi.Totals.Rounding)
+
+	cats := gi.Totals.Taxes.Categories
+	require.Len(t, cats, 2)
+	assert.Equal(t, cbc.Code("GST"), cats[0].Code)
+	assert.Equal(t, "1.00", cats[0].Amount.String())
+	assert.Equal(t, cbc.Code("PST"), cats[1].Code)
+	assert.Equal(t, "1.40", cats[1].Amount.String())
+}
+
+func TestUSSalesTaxJurisdictions(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	rate := func(name string, level stripe.TaxRateJurisdictionLevel, percent float64) *stripe.TaxRate {
+		return &stripe.TaxRate{
+			Country:           "US",
+			State:             "CA",
+			Jurisdiction:      name,
+			JurisdictionLevel: level,
+			TaxType:           stripe.TaxRateTaxTypeSalesTax,
+			Percentage:        percent,
+			Created:           created,
+		}
+	}
+
+	s := minimalStripeInvoice()
+	s.AccountCountry = "US"
+	s.Currency = stripe.CurrencyUSD
+	line := s.Lines.Data[0]
+	line.Currency = stripe.CurrencyUSD
+	line.Amount = 20000
+	line.Price.UnitAmount = 20000
+	line.TaxAmounts = []*stripe.InvoiceTotalTaxAmount{
+		{Amount: 1200, TaxRate: rate("CALIFORNIA", stripe.TaxRateJurisdictionLevelState, 6)},
+		{Amount: 50, TaxRate: rate("LOS ANGELES COUNTY", stripe.TaxRateJurisdictionLevelCounty, 0.25)},
+		{Amount: 450, TaxRate: rate("LOS ANGELES", stripe.TaxRateJurisdictionLevelCity, 2.25)},
+	}
+	s.TotalTaxAmounts = line.TaxAmounts
+	s.Total = 21700
+	s.AmountPaid = 21700
+
+	account := validStripeAccount()
+	account.Country = "US"
+	gi, err := goblstripe.FromInvoice(s, account)
+	require.NoError(t, err)
+	gi.Regime = tax.WithRegime("US")
+	gi.Supplier.TaxID = &tax.Identity{Country: "US"}
+	require.NoError(t, gi.Calculate())
+	require.NoError(t, gi.Validate())
+
+	require.Len(t, gi.Lines[0].Taxes, 1)
+	tc := gi.Lines[0].Taxes[0]
+	assert.Equal(t, tax.CategoryST, tc.Category)
+	assert.Equal(t, "8.50%", tc.Percent.String())
+	assert.Equal(t, "17.00", gi.Totals.Tax.String())
+	assert.Nil(t, gi.Totals.Rounding)
+
+	require.Len(t, gi.Notes, 3)
+	assert.Equal(t, org.NoteKeyTax, gi.Notes[0].Key)
+	assert.Equal(t, cbc.Code("state"), gi.Notes[0].Code)
+	assert.Equal(t, "CALIFORNIA (CA, state) 6%: 12.00", gi.Notes[0].Text)
+	assert.Equal(t, "LOS ANGELES COUNTY (CA, county) 0.25%: 0.50", gi.Notes[1].Text)
+	assert.Equal(t, "LOS ANGELES (CA, city) 2.25%: 4.50", gi.Notes[2].Text)
+	assert.Equal(t, "LOS ANGELES", gi.Notes[2].Meta[goblstripe.MetaKeyStripeJurisdiction])
+	assert.Equal(t, "city", gi.Notes[2].Meta[goblstripe.MetaKeyStripeJurisdictionLevel])
+	assert.Equal(t, "CA", gi.Notes[2].Meta[goblstripe.MetaKeyStripeState])
+
+	t.Run("not sent as description or footer", func(t *testing.T) {
+		params, _, err := goblstripe.ToInvoice(gi)
+		require.NoError(t, err)
+		assert.Empty(t, stripe.StringValue(params.Description))
+		assert.Empty(t, stripe.StringValue(params.Footer))
+	})
+
+	t.Run("back to Stripe", func(t *testing.T) {
+		// A tax rate per jurisdiction, as reported by Stripe
+		reg := goblstripe.NewTaxRateRegistry(nil)
+		missing := reg.Missing(gi)
+		require.Len(t, missing, 3)
+		assert.Equal(t, "CALIFORNIA", stripe.StringValue(missing[0].Jurisdiction))
+		assert.Equal(t, "CA", stripe.StringValue(missing[0].State))
+		assert.Equal(t, "Sales Tax", stripe.StringValue(missing[0].DisplayName))
+		assert.Equal(t, 6.0, stripe.Float64Value(missing[0].Percentage))
+		assert.Equal(t, "LOS ANGELES COUNTY", stripe.StringValue(missing[1].Jurisdiction))
+		assert.Equal(t, 0.25, stripe.Float64Value(missing[1].Percentage))
+		assert.Equal(t, "LOS ANGELES", stripe.StringValue(missing[2].Jurisdiction))
+		assert.Equal(t, 2.25, stripe.Float64Value(missing[2].Percentage))
+
+		doc, err := fixture.ToInvoice(gi)
+		require.NoError(t, err)
+		require.Len(t, doc.Lines.Data[0].TaxRates, 3)
+		assert.Equal(t, int64(1700), doc.Tax)
+
+		got, err := goblstripe.FromInvoice(doc, account)
+		require.NoError(t, err)
+		require.Len(t, got.Notes, 3)
+		assert.Equal(t, gi.Notes[2].Text, got.Notes[2].Text)
+		assert.Equal(t, "8.50%", got.Lines[0].Taxes[0].Percent.String())
+
+		// Without the notes, a single combined rate
+		params := goblstripe.ToTaxRateParams(tc, "", tax.RegimeDefFor(l10n.US))
+		assert.Equal(t, "Sales Tax", stripe.StringValue(params.DisplayName))
+		assert.Nil(t, params.Jurisdiction)
+		assert.Equal(t, 8.5, stripe.Float64Value(params.Percentage))
+	})
+
+	t.Run("exempt jurisdiction", func(t *testing.T) {
+		s.Lines.Data[0].TaxAmounts = []*stripe.InvoiceTotalTaxAmount{
+			{
+				Amount:           0,
+				TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReasonProductExempt,
+				TaxRate:          rate("LOS ANGELES", stripe.TaxRateJurisdictionLevelCity, 0),
+			},
+			{
+				Amount:           0,
+				TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReasonProductExempt,
+				TaxRate:          rate("CALIFORNIA", stripe.TaxRateJurisdictionLevelState, 0),
+			},
+		}
+		s.TotalTaxAmounts = s.Lines.Data[0].TaxAmounts
+		s.Total = 20000
+		s.AmountPaid = 20000
+		gi, err := goblstripe.FromInvoice(s, account)
+		require.NoError(t, err)
+		require.Len(t, gi.Lines[0].Taxes, 1, "no duplicate sales tax combo")
+		assert.Empty(t, gi.Notes)
+	})
+}
+
+func TestInvoiceRevision(t *testing.T) {
+	revised := completeStripeInvoice()
+	revised.ID = "in_1QkqJvQhcl5B85YlfTg3dW2P"
+	revised.Number = "INV-0041"
+
+	t.Run("new invoice when corrective invoices are not supported", func(t *testing.T) {
+		s := completeStripeInvoice()
+		s.Number = "INV-0042"
+		s.FromInvoice = &stripe.InvoiceFromInvoice{Action: "revision", Invoice: revised}
+
+		gi, err := goblstripe.FromInvoice(s, validStripeAccount())
+		require.NoError(t, err)
+		require.NoError(t, gi.Calculate())
+		require.NoError(t, gi.Validate())
+
+		assert.Equal(t, bill.InvoiceTypeStandard, gi.Type)
+		require.Len(t, gi.Preceding, 1)
+		assert.Equal(t, "INV", gi.Preceding[0].Series.String())
+		assert.Equal(t, "0041", gi.Preceding[0].Code.String())
+		assert.Equal(t, "2024-01-01", gi.Preceding[0].IssueDate.String())
+		assert.Equal(t, "Invoice revised", gi.Preceding[0].Reason)
+	})
+
+	t.Run("corrective invoice", func(t *testing.T) {
+		s := completeStripeInvoice()
+		s.AccountCountry = "ES"
+		s.FromInvoice = &stripe.InvoiceFromInvoice{Action: "revision", Invoice: revised}
+
+		gi, err := goblstripe.FromInvoice(s, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, bill.InvoiceTypeCorrective, gi.Type)
+		require.Len(t, gi.Preceding, 1)
+		assert.Equal(t, "0041", gi.Preceding[0].Code.String())
+	})
+
+	t.Run("credit note revisions", func(t *testing.T) {
+		s := completeStripeInvoice()
+		s.AccountCountry = "ES"
+		s.FromInvoice = &stripe.InvoiceFromInvoice{Action: "revision", Invoice: revised}
+
+		gi, err := goblstripe.FromInvoice(s, nil, goblstripe.WithCreditNoteRevisions("ES"))
+		require.NoError(t, err)
+		assert.Equal(t, bill.InvoiceTypeStandard, gi.Type)
+		require.Len(t, gi.Preceding, 1)
+		assert.Equal(t, "0041", gi.Preceding[0].Code.String())
+
+		gi, err = goblstripe.FromInvoice(s, nil, goblstripe.WithCreditNoteRevisions())
+		require.NoError(t, err)
+		assert.Equal(t, bill.InvoiceTypeStandard, gi.Type)
+
+		// Other regimes keep their corrective invoices
+		gi, err = goblstripe.FromInvoice(s, nil, goblstripe.WithCreditNoteRevisions("PT"))
+		require.NoError(t, err)
+		assert.Equal(t, bill.InvoiceTypeCorrective, gi.Type)
+	})
+
+	t.Run("unexpanded revised invoice", func(t *testing.T) {
+		s := completeStripeInvoice()
+		s.FromInvoice = &stripe.InvoiceFromInvoice{
+			Action:  "revision",
+			Invoice: &stripe.Invoice{ID: "in_1QkqJvQhcl5B85YlfTg3dW2P"},
+		}
+
+		gi, err := goblstripe.FromInvoice(s, validStripeAccount())
+		require.NoError(t, err)
+
+		require.Len(t, gi.Preceding, 1)
+		assert.Equal(t, "in_1QkqJvQhcl5B85YlfTg3dW2P", gi.Preceding[0].Code.String())
+		assert.Nil(t, gi.Preceding[0].IssueDate)
+	})
+
+	t.Run("clone", func(t *testing.T) {
+		s := completeStripeInvoice()
+		s.FromInvoice = &stripe.InvoiceFromInvoice{Action: "clone", Invoice: revised}
+
+		gi, err := goblstripe.FromInvoice(s, validStripeAccount())
+		require.NoError(t, err)
+
+		assert.Equal(t, bill.InvoiceTypeStandard, gi.Type)
+		assert.Empty(t, gi.Preceding)
+	})
+}
+
 func TestUnexpandedTax(t *testing.T) {
 	data, _ := os.ReadFile("examples/stripe.gobl/unexpanded_invoice.json")
 	s := new(stripe.Invoice)
@@ -1585,3 +1825,299 @@ func TestNotesInCreditNoteConversion(t *testing.T) {
 		assert.Equal(t, "Refund reason:<br>- Product defect<br>- Customer request", gi.Notes[0].Text)
 	})
 }
+
+func validGOBLInvoice() *bill.Invoice {
+	return &bill.Invoice{
+		Regime:   tax.WithRegime("DE"),
+		Type:     bill.InvoiceTypeStandard,
+		Series:   "SAMPLE",
+		Code:     "0001",
+		Currency: currency.EUR,
+		Supplier: &org.Party{
+			Name: "Test Account",
+			TaxID: &tax.Identity{
+				Country: "DE",
+				Code:    "813495425",
+			},
+		},
+		Customer: &org.Party{
+			Name: "Test Customer",
+			TaxID: &tax.Identity{
+				Country: "DE",
+				Code:    "282741168",
+			},
+		},
+		OperationDate: cal.NewDate(2025, 1, 15),
+		Lines: []*bill.Line{
+			{
+				Quantity: num.MakeAmount(2, 0),
+				Item: &org.Item{
+					Name:  "Pro Plan",
+					Price: num.NewAmount(5000, 2),
+				},
+				Period: &cal.Period{
+					Start: cal.MakeDate(2025, 1, 1),
+					End:   cal.MakeDate(2025, 1, 31),
+				},
+				Taxes: tax.Set{
+					{
+						Category: tax.CategoryVAT,
+						Rate:     tax.RateGeneral,
+					},
+				},
+			},
+		},
+		Payment: &bill.PaymentDetails{
+			Terms: &pay.Terms{
+				DueDates: []*pay.DueDate{
+					{
+						Date:    cal.NewDate(2025, 2, 14),
+						Percent: num.NewPercentage(1, 0),
+					},
+				},
+			},
+		},
+		Ordering: &bill.Ordering{
+			Code: "PO-1234",
+		},
+		Notes: []*org.Note{
+			{
+				Key:  org.NoteKeyGeneral,
+				Text: "Thanks for your business",
+			},
+			{
+				Key:  org.NoteKeyGeneral,
+				Text: "Line one<br>Line two",
+			},
+		},
+	}
+}
+
+func TestToInvoice(t *testing.T) {
+	t.Run("complete invoice", func(t *testing.T) {
+		params, items, err := goblstripe.ToInvoice(validGOBLInvoice())
+		require.NoError(t, err)
+
+		berlin, err := time.LoadLocation("Europe/Berlin")
+		require.NoError(t, err)
+
+		assert.Equal(t, "eur", stripe.StringValue(params.Currency))
+		assert.Equal(t, "SAMPLE-0001", stripe.StringValue(params.Number))
+		assert.Equal(t, time.Date(2025, 1, 15, 0, 0, 0, 0, berlin).Unix(), stripe.Int64Value(params.EffectiveAt))
+		assert.Equal(t, time.Date(2025, 2, 14, 0, 0, 0, 0, berlin).Unix(), stripe.Int64Value(params.DueDate))
+		assert.Equal(t, string(stripe.InvoiceCollectionMethodSendInvoice), stripe.StringValue(params.CollectionMethod))
+		assert.Equal(t, "Thanks for your business", stripe.StringValue(params.Description))
+		assert.Equal(t, "Line one\nLine two", stripe.StringValue(params.Footer))
+		require.Len(t, params.CustomFields, 1)
+		assert.Equal(t, "PO Number", stripe.StringValue(params.CustomFields[0].Name))
+		assert.Equal(t, "PO-1234", stripe.StringValue(params.CustomFields[0].Value))
+
+		assert.Empty(t, params.Metadata, "no UUID to keep")
+
+		require.Len(t, items, 1)
+		assert.Equal(t, "Pro Plan", stripe.StringValue(items[0].Description))
+		assert.Equal(t, int64(2), stripe.Int64Value(items[0].Quantity))
+		assert.Equal(t, int64(5000), stripe.Int64Value(items[0].UnitAmount))
+		assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, berlin).Unix(), stripe.Int64Value(items[0].Period.Start))
+		assert.Equal(t, time.Date(2025, 1, 31, 0, 0, 0, 0, berlin).Unix(), stripe.Int64Value(items[0].Period.End))
+	})
+
+	t.Run("without series, terms or notes", func(t *testing.T) {
+		inv := validGOBLInvoice()
+		inv.Series = ""
+		inv.Payment = nil
+		inv.Notes = nil
+		inv.Ordering = nil
+		params, _, err := goblstripe.ToInvoice(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "0001", stripe.StringValue(params.Number))
+		assert.Nil(t, params.DueDate)
+		assert.Nil(t, params.CollectionMethod)
+		assert.Nil(t, params.Description)
+		assert.Nil(t, params.Footer)
+		assert.Empty(t, params.CustomFields)
+	})
+
+	t.Run("credit note", func(t *testing.T) {
+		inv := validGOBLInvoice()
+		inv.Type = bill.InvoiceTypeCreditNote
+		_, _, err := goblstripe.ToInvoice(inv)
+		assert.ErrorContains(t, err, "credit notes are not supported")
+	})
+
+	t.Run("nil invoice", func(t *testing.T) {
+		_, _, err := goblstripe.ToInvoice(nil)
+		assert.ErrorContains(t, err, "missing invoice")
+	})
+}
+
+func TestToInvoiceRoundTrip(t *testing.T) {
+	inv := validGOBLInvoice()
+	inv.UUID = uuid.V7()
+	require.NoError(t, inv.Calculate())
+
+	params, items, err := goblstripe.ToInvoice(inv)
+	require.NoError(t, err)
+
+	// Mimic the invoice Stripe would build from the params.
+	doc := minimalStripeInvoice()
+	doc.Metadata = params.Metadata
+	doc.Number = stripe.StringValue(params.Number)
+	doc.EffectiveAt = stripe.Int64Value(params.EffectiveAt)
+	doc.CustomFields = []*stripe.InvoiceCustomField{
+		{
+			Name:  stripe.StringValue(params.CustomFields[0].Name),
+			Value: stripe.StringValue(params.CustomFields[0].Value),
+		},
+	}
+	doc.Lines.Data = []*stripe.InvoiceLineItem{
+		{
+			Description: stripe.StringValue(items[0].Description),
+			Amount:      stripe.Int64Value(items[0].Quantity) * stripe.Int64Value(items[0].UnitAmount),
+			Currency:    stripe.CurrencyEUR,
+			Quantity:    stripe.Int64Value(items[0].Quantity),
+			Period: &stripe.Period{
+				Start: stripe.Int64Value(items[0].Period.Start),
+				End:   stripe.Int64Value(items[0].Period.End),
+			},
+			Price: &stripe.Price{
+				BillingScheme: stripe.PriceBillingSchemePerUnit,
+				UnitAmount:    stripe.Int64Value(items[0].UnitAmount),
+			},
+		},
+	}
+	doc.Total = 10000
+
+	gi, err := goblstripe.FromInvoice(doc, validStripeAccount())
+	require.NoError(t, err)
+
+	assert.Equal(t, inv.UUID, gi.UUID)
+	assert.Equal(t, inv.Series, gi.Series)
+	assert.Equal(t, inv.Code, gi.Code)
+	assert.Equal(t, inv.OperationDate, gi.OperationDate)
+	assert.Equal(t, inv.Ordering.Code, gi.Ordering.Code)
+	assert.Equal(t, inv.Lines[0].Period, gi.Lines[0].Period)
+	assert.Equal(t, inv.Lines[0].Quantity, gi.Lines[0].Quantity)
+	assert.Equal(t, inv.Lines[0].Item.Price.String(), gi.Lines[0].Item.Price.String())
+}
+
+func validGOBLCreditNote() *bill.Invoice {
+	cn := validGOBLInvoice()
+	cn.Type = bill.InvoiceTypeCreditNote
+	cn.Code = "CN-0001"
+	cn.Series = ""
+	cn.Payment = nil
+	cn.Ordering = nil
+	cn.Notes = nil
+	cn.Preceding = []*org.DocumentRef{
+		{
+			Series:    "SAMPLE",
+			Code:      "0001",
+			IssueDate: cal.NewDate(2025, 1, 15),
+			Reason:    "Duplicate",
+		},
+	}
+	return cn
+}
+
+func originalStripeInvoice() *stripe.Invoice {
+	doc := minimalStripeInvoice()
+	doc.Number = "SAMPLE-0001"
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	doc.Lines.Data = []*stripe.InvoiceLineItem{
+		{
+			ID:          "il_other",
+			Description: "Setup fee",
+			Amount:      10000,
+			Currency:    stripe.CurrencyEUR,
+			Quantity:    1,
+		},
+		{
+			ID:          "il_pro",
+			Description: "Pro Plan",
+			Amount:      10000,
+			Currency:    stripe.CurrencyEUR,
+			Quantity:    2,
+			Period: &stripe.Period{
+				Start: time.Date(2025, 1, 1, 0, 0, 0, 0, berlin).Unix(),
+				End:   time.Date(2025, 1, 31, 23, 59, 59, 0, berlin).Unix(),
+			},
+		},
+	}
+	return doc
+}
+
+func TestToCreditNote(t *testing.T) {
+	t.Run("matching original line", func(t *testing.T) {
+		params, err := goblstripe.ToCreditNote(validGOBLCreditNote(), originalStripeInvoice())
+		require.NoError(t, err)
+
+		assert.Equal(t, "in_1QkqKVQhcl5B85YlT32LIsNm", stripe.StringValue(params.Invoice))
+		assert.Equal(t, string(stripe.CreditNoteReasonDuplicate), stripe.StringValue(params.Reason))
+		assert.Nil(t, params.Memo)
+		require.Len(t, params.Lines, 1)
+		assert.Equal(t, string(stripe.CreditNoteLineItemTypeInvoiceLineItem), stripe.StringValue(params.Lines[0].Type))
+		assert.Equal(t, "il_pro", stripe.StringValue(params.Lines[0].InvoiceLineItem))
+		assert.Equal(t, int64(10000), stripe.Int64Value(params.Lines[0].Amount))
+	})
+
+	t.Run("line without match", func(t *testing.T) {
+		cn := validGOBLCreditNote()
+		cn.Lines[0].Quantity = num.MakeAmount(1, 0)
+		params, err := goblstripe.ToCreditNote(cn, originalStripeInvoice())
+		require.NoError(t, err)
+
+		require.Len(t, params.Lines, 1)
+		assert.Equal(t, string(stripe.CreditNoteLineItemTypeCustomLineItem), stripe.StringValue(params.Lines[0].Type))
+		assert.Equal(t, "Pro Plan", stripe.StringValue(params.Lines[0].Description))
+		assert.Equal(t, int64(1), stripe.Int64Value(params.Lines[0].Quantity))
+		assert.Equal(t, int64(5000), stripe.Int64Value(params.Lines[0].UnitAmount))
+	})
+
+	t.Run("preceding by Stripe invoice ID", func(t *testing.T) {
+		cn := validGOBLCreditNote()
+		cn.Preceding[0].Series = ""
+		cn.Preceding[0].Code = "in_1QkqKVQhcl5B85YlT32LIsNm"
+		params, err := goblstripe.ToCreditNote(cn, originalStripeInvoice())
+		require.NoError(t, err)
+		assert.Equal(t, "in_1QkqKVQhcl5B85YlT32LIsNm", stripe.StringValue(params.Invoice))
+
+		params, err = goblstripe.ToCreditNote(cn, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "in_1QkqKVQhcl5B85YlT32LIsNm", stripe.StringValue(params.Invoice))
+		require.Len(t, params.Lines, 1)
+		assert.Equal(t, string(stripe.CreditNoteLineItemTypeCustomLineItem), stripe.StringValue(params.Lines[0].Type))
+	})
+
+	t.Run("unrelated original invoice", func(t *testing.T) {
+		doc := originalStripeInvoice()
+		doc.Number = "OTHER-0002"
+		_, err := goblstripe.ToCreditNote(validGOBLCreditNote(), doc)
+		assert.ErrorContains(t, err, "does not reference Stripe invoice")
+	})
+
+	t.Run("unmapped reason kept as memo", func(t *testing.T) {
+		cn := validGOBLCreditNote()
+		cn.Preceding[0].Reason = "Customer changed their mind"
+		params, err := goblstripe.ToCreditNote(cn, originalStripeInvoice())
+		require.NoError(t, err)
+		assert.Nil(t, params.Reason)
+		assert.Equal(t, "Customer changed their mind", stripe.StringValue(params.Memo))
+	})
+
+	t.Run("reason variants", func(t *testing.T) {
+		cn := validGOBLCreditNote()
+		cn.Preceding[0].Reason = "Product unsatisfactory"
+		cn.Notes = []*org.Note{{Key: org.NoteKeyGeneral, Text: "Refund<br>approved"}}
+		params, err := goblstripe.ToCreditNote(cn, originalStripeInvoice())
+		require.NoError(t, err)
+		assert.Equal(t, string(stripe.CreditNoteReasonProductUnsatisfactory), stripe.StringValue(params.Reason))
+		assert.Equal(t, "Refund\napproved", stripe.StringValue(params.Memo))
+	})
+
+	t.Run("standard invoice", func(t *testing.T) {
+		_, err := goblstripe.ToCreditNote(validGOBLInvoice(), nil)
+		assert.ErrorContains(t, err, "expected a credit note")
+	})
+}
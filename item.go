@@ -0,0 +1,91 @@
+package goblstripe
+
+import (
+	"strings"
+
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/org"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// ToProductParams converts a GOBL org.Item into a stripe product object suitable for
+// sending to the Stripe API. The item reference is used as the product ID, so the
+// same item always maps to the same product, and the item extensions are stored in
+// the product metadata so they can be read back when converting from Stripe.
+func ToProductParams(item *org.Item) *stripe.ProductParams {
+	if item == nil {
+		return nil
+	}
+	prod := &stripe.ProductParams{
+		Name: stripe.String(item.Name),
+	}
+	if item.Ref != "" {
+		prod.ID = stripe.String(item.Ref.String())
+	}
+	if item.Description != "" {
+		prod.Description = stripe.String(item.Description)
+	}
+	if item.Unit != "" {
+		prod.UnitLabel = stripe.String(string(item.Unit))
+	}
+	if len(item.Ext) > 0 {
+		prod.Metadata = toMetadataWithPrefix(item.Ext, customDataItemExt)
+	}
+	return prod
+}
+
+// ToPriceParams converts the price of a GOBL org.Item into a stripe price object suitable
+// for sending to the Stripe API. The tax behavior is set from the tax category included
+// in the invoice prices (the invoice's `PricesInclude`), if any. When the item has a
+// reference, it is used as the product ID and, together with the currency and tax
+// behavior, as the price lookup key (e.g. `PROD-001_eur_exclusive`). Otherwise, the
+// product is created inline from the item data.
+func ToPriceParams(item *org.Item, curr currency.Code, pricesInclude cbc.Code) *stripe.PriceParams {
+	if item == nil {
+		return nil
+	}
+	if curr == "" {
+		curr = item.Currency
+	}
+	price := &stripe.PriceParams{
+		Currency: stripe.String(string(ToCurrency(curr))),
+	}
+
+	if pricesInclude != "" {
+		price.TaxBehavior = stripe.String(string(stripe.PriceTaxBehaviorInclusive))
+	} else {
+		price.TaxBehavior = stripe.String(string(stripe.PriceTaxBehaviorExclusive))
+	}
+
+	if item.Ref != "" {
+		price.Product = stripe.String(item.Ref.String())
+		price.LookupKey = stripe.String(priceLookupKey(item.Ref, curr, stripe.StringValue(price.TaxBehavior)))
+		price.TransferLookupKey = stripe.Bool(true) // Newer prices replace the previous one
+	} else {
+		price.ProductData = &stripe.PriceProductDataParams{
+			Name: stripe.String(item.Name),
+		}
+		if item.Unit != "" {
+			price.ProductData.UnitLabel = stripe.String(string(item.Unit))
+		}
+		if len(item.Ext) > 0 {
+			price.ProductData.Metadata = toMetadataWithPrefix(item.Ext, customDataItemExt)
+		}
+	}
+
+	amount := CurrencyAmount(0, curr)
+	if item.Price != nil {
+		amount = *item.Price
+	}
+	price.UnitAmount, price.UnitAmountDecimal = toStripeUnitAmount(amount, curr)
+
+	return price
+}
+
+// priceLookupKey returns the lookup key of the Stripe price of an item reference in a
+// currency and with a tax behavior. Each combination gets its own price, so newer
+// prices only replace the previous one of the same kind.
+func priceLookupKey(ref cbc.Code, curr currency.Code, taxBehavior string) string {
+	return strings.Join([]string{ref.String(), string(ToCurrency(curr)), taxBehavior}, "_")
+}
@@ -0,0 +1,135 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func validGOBLItem() *org.Item {
+	return &org.Item{
+		Ref:         "PROD-001",
+		Name:        "Consulting",
+		Description: "Hourly consulting services",
+		Price:       num.NewAmount(12050, 2),
+		Unit:        org.UnitHour,
+		Ext: tax.Extensions{
+			"mx-cfdi-prod-serv": "80101500",
+		},
+	}
+}
+
+func TestToProductParams(t *testing.T) {
+	t.Run("complete item", func(t *testing.T) {
+		prod := goblstripe.ToProductParams(validGOBLItem())
+		require.NotNil(t, prod)
+
+		assert.Equal(t, "PROD-001", stripe.StringValue(prod.ID))
+		assert.Equal(t, "Consulting", stripe.StringValue(prod.Name))
+		assert.Equal(t, "Hourly consulting services", stripe.StringValue(prod.Description))
+		assert.Equal(t, "h", stripe.StringValue(prod.UnitLabel))
+		assert.Equal(t, map[string]string{"gobl-item-mx-cfdi-prod-serv": "80101500"}, prod.Metadata)
+	})
+
+	t.Run("minimal item", func(t *testing.T) {
+		prod := goblstripe.ToProductParams(&org.Item{Name: "Widget"})
+		require.NotNil(t, prod)
+
+		assert.Equal(t, "Widget", stripe.StringValue(prod.Name))
+		assert.Nil(t, prod.ID)
+		assert.Nil(t, prod.Description)
+		assert.Nil(t, prod.UnitLabel)
+		assert.Nil(t, prod.Metadata)
+	})
+
+	t.Run("nil item", func(t *testing.T) {
+		assert.Nil(t, goblstripe.ToProductParams(nil))
+	})
+}
+
+func TestToPriceParams(t *testing.T) {
+	t.Run("with reference", func(t *testing.T) {
+		price := goblstripe.ToPriceParams(validGOBLItem(), currency.MXN, "")
+		require.NotNil(t, price)
+
+		assert.Equal(t, "mxn", stripe.StringValue(price.Currency))
+		assert.Equal(t, "PROD-001", stripe.StringValue(price.Product))
+		assert.Equal(t, "PROD-001_mxn_exclusive", stripe.StringValue(price.LookupKey))
+		assert.True(t, stripe.BoolValue(price.TransferLookupKey))
+		assert.Nil(t, price.ProductData)
+		assert.Equal(t, int64(12050), stripe.Int64Value(price.UnitAmount))
+		assert.Equal(t, string(stripe.PriceTaxBehaviorExclusive), stripe.StringValue(price.TaxBehavior))
+	})
+
+	t.Run("prices include tax", func(t *testing.T) {
+		price := goblstripe.ToPriceParams(validGOBLItem(), currency.MXN, tax.CategoryVAT)
+		assert.Equal(t, string(stripe.PriceTaxBehaviorInclusive), stripe.StringValue(price.TaxBehavior))
+		assert.Equal(t, "PROD-001_mxn_inclusive", stripe.StringValue(price.LookupKey))
+	})
+
+	t.Run("lookup key per currency", func(t *testing.T) {
+		mxn := goblstripe.ToPriceParams(validGOBLItem(), currency.MXN, "")
+		usd := goblstripe.ToPriceParams(validGOBLItem(), currency.USD, "")
+		assert.NotEqual(t, stripe.StringValue(mxn.LookupKey), stripe.StringValue(usd.LookupKey))
+		assert.Equal(t, "PROD-001_usd_exclusive", stripe.StringValue(usd.LookupKey))
+	})
+
+	t.Run("without reference", func(t *testing.T) {
+		item := validGOBLItem()
+		item.Ref = ""
+		price := goblstripe.ToPriceParams(item, currency.MXN, "")
+
+		assert.Nil(t, price.Product)
+		assert.Nil(t, price.LookupKey)
+		require.NotNil(t, price.ProductData)
+		assert.Equal(t, "Consulting", stripe.StringValue(price.ProductData.Name))
+		assert.Equal(t, "h", stripe.StringValue(price.ProductData.UnitLabel))
+		assert.Equal(t, "80101500", price.ProductData.Metadata["gobl-item-mx-cfdi-prod-serv"])
+	})
+
+	t.Run("decimal price and item currency", func(t *testing.T) {
+		item := &org.Item{
+			Name:     "API call",
+			Currency: currency.EUR,
+			Price:    num.NewAmount(125, 4),
+		}
+		price := goblstripe.ToPriceParams(item, "", "")
+
+		assert.Equal(t, "eur", stripe.StringValue(price.Currency))
+		assert.Nil(t, price.UnitAmount)
+		assert.Equal(t, 1.25, stripe.Float64Value(price.UnitAmountDecimal))
+	})
+
+	t.Run("nil item", func(t *testing.T) {
+		assert.Nil(t, goblstripe.ToPriceParams(nil, currency.EUR, ""))
+	})
+}
+
+func TestProductMetadataRoundTrip(t *testing.T) {
+	item := validGOBLItem()
+	prod := goblstripe.ToProductParams(item)
+
+	line := validInvoiceLine()
+	line.Quantity = 1
+	line.Price = &stripe.Price{
+		BillingScheme: stripe.PriceBillingSchemePerUnit,
+		UnitAmount:    line.Amount,
+		Product: &stripe.Product{
+			ID:       stripe.StringValue(prod.ID),
+			Name:     stripe.StringValue(prod.Name),
+			Metadata: prod.Metadata,
+		},
+	}
+	gl := goblstripe.FromInvoiceLine(line, tax.RegimeDefFor(l10n.MX))
+
+	assert.Equal(t, cbc.Code("80101500"), gl.Item.Ext["mx-cfdi-prod-serv"])
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/catalogues/cef"
 	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/currency"
 	"github.com/invopop/gobl/l10n"
@@ -107,8 +108,15 @@ func fromInvoiceLineToItem(line *stripe.InvoiceLineItem) *org.Item {
 		Currency: currency.Code(strings.ToUpper(string(line.Currency))),
 	}
 
-	if line.Price != nil && line.Price.Product != nil && line.Price.Product.Metadata != nil {
-		item.Ext = newExtensionsWithPrefix(line.Price.Product.Metadata, customDataItemExt)
+	if line.Price != nil && line.Price.Product != nil {
+		item.Key = itemKeyFromProduct(line.Price.Product)
+		if line.Price.Product.Metadata != nil {
+			item.Ext = newExtensionsWithPrefix(line.Price.Product.Metadata, customDataItemExt)
+		}
+	}
+	if len(item.Ext) == 0 && line.Metadata != nil {
+		// Lines of ad-hoc invoice items carry the extensions in their own metadata
+		item.Ext = newExtensionsWithPrefix(line.Metadata, customDataItemExt)
 	}
 
 	return item
@@ -178,7 +186,7 @@ func FromInvoiceTaxAmountsToTaxSet(taxAmounts []*stripe.InvoiceTotalTaxAmount, r
 	for _, taxAmount := range taxAmounts {
 		taxCombo := FromInvoiceTaxAmountToTaxCombo(taxAmount, regimeDef)
 		if taxCombo != nil {
-			ts = append(ts, taxCombo)
+			ts = appendTaxCombo(ts, taxCombo)
 		}
 	}
 	return ts
@@ -193,28 +201,10 @@ func FromInvoiceTaxAmountToTaxCombo(taxAmount *stripe.InvoiceTotalTaxAmount, reg
 		return nil
 	}
 
-	// Instead of the percentage we can also look at the taxability_reason field.
-	// There are different types defined and we could map them to the tax categories in GOBL.
-
-	// A reverse charge is issued from the supplier's side: the supplier charges no
-	// tax and the customer self-accounts. We therefore express it with the supplier
-	// regime's own category and country, not the customer's. Stripe reports the
-	// customer's tax category (e.g. Australian GST for an AU customer), which an EU
-	// supplier's regime (e.g. Poland) doesn't define, so in that case we fall back to
-	// the regime's VAT category. An actual customer-country rate is not a reverse
-	// charge and is handled as a foreign tax below.
-	if taxAmount.TaxabilityReason == stripe.InvoiceTotalTaxAmountTaxabilityReasonReverseCharge {
-		cat := reverseChargeCategory(tc.Category, regimeDef)
-		if cat != "" {
-			tc.Category = cat
-			tc.Country = regimeDef.Country
-			tc.Key = tax.KeyReverseCharge
-			return tc
-		}
-		// The regime defines no reverse-charge-capable category (no VAT), so a valid
-		// reverse charge can't be expressed here: GOBL only accepts the reverse-charge
-		// key on VAT. Record the Stripe tax as-is below, without the key — forcing the
-		// key would make GOBL reject the line.
+	// Untaxed amounts are flagged by Stripe with a taxability reason (or by GOBL with
+	// the tax key stored in the rate's metadata), which maps to a GOBL tax key.
+	if kc := newTaxKeyCombo(tc.Category, string(taxAmount.TaxabilityReason), taxAmount.TaxRate, regimeDef); kc != nil {
+		return kc
 	}
 
 	taxDate := newDateFromTS(taxAmount.TaxRate.Created, regimeDef.TimeLocation())
@@ -358,7 +348,7 @@ func FromCreditNoteTaxAmountsToTaxSet(taxAmounts []*stripe.CreditNoteTaxAmount,
 	for _, taxAmount := range taxAmounts {
 		tc := FromCreditNoteTaxAmountToTaxCombo(taxAmount, regimeDef)
 		if tc != nil {
-			ts = append(ts, tc)
+			ts = appendTaxCombo(ts, tc)
 		}
 	}
 	return ts
@@ -373,25 +363,9 @@ func FromCreditNoteTaxAmountToTaxCombo(taxAmount *stripe.CreditNoteTaxAmount, re
 		return nil
 	}
 
-	// Instead of the percentage we can also look at the taxability_reason field.
-	// There are different types defined and we could map them to the tax categories in GOBL.
-
-	// A reverse charge is issued from the supplier's side (see the invoice version of
-	// this function for the full rationale): express it with the supplier regime's own
-	// category and country, falling back to VAT when Stripe reports a foreign category
-	// the regime doesn't define.
-	if taxAmount.TaxabilityReason == stripe.CreditNoteTaxAmountTaxabilityReasonReverseCharge {
-		cat := reverseChargeCategory(tc.Category, regimeDef)
-		if cat != "" {
-			tc.Category = cat
-			tc.Country = regimeDef.Country
-			tc.Key = tax.KeyReverseCharge
-			return tc
-		}
-		// The regime defines no reverse-charge-capable category (no VAT), so a valid
-		// reverse charge can't be expressed here: GOBL only accepts the reverse-charge
-		// key on VAT. Record the Stripe tax as-is below, without the key — forcing the
-		// key would make GOBL reject the line.
+	// Untaxed amounts map to a GOBL tax key (see the invoice version of this function).
+	if kc := newTaxKeyCombo(tc.Category, string(taxAmount.TaxabilityReason), taxAmount.TaxRate, regimeDef); kc != nil {
+		return kc
 	}
 
 	taxDate := newDateFromTS(taxAmount.TaxRate.Created, regimeDef.TimeLocation())
@@ -422,15 +396,169 @@ func FromCreditNoteTaxAmountToTaxCombo(taxAmount *stripe.CreditNoteTaxAmount, re
 
 //Useful functions
 
-// reverseChargeCategory picks the category to use for a supplier-side reverse
-// charge. Stripe reports the category from the customer's perspective (e.g.
-// Australian GST for an AU customer), but a reverse charge is the supplier's
-// statement that it charges no tax, so it must sit in the supplier's regime. If
-// the regime already defines the reported category (e.g. VAT for an EU supplier)
-// we keep it; otherwise we fall back to the regime's VAT category. It returns an
-// empty code when the regime defines neither, signalling the caller to record the
-// tax as-is instead.
-func reverseChargeCategory(cat cbc.Code, regimeDef *tax.RegimeDef) cbc.Code {
+// appendTaxCombo adds a tax combo to a set. Stripe reports the sales tax of each US
+// jurisdiction (state, county, city and district) as a separate tax amount, while GOBL
+// only accepts one combo per category, so stacked US sales tax rates with the same key
+// are added up into a single combo. The breakdown by jurisdiction is kept in the notes
+// (see newInvoiceSalesTaxNotes). Repeated combos are only added once, while any other
+// combo of the same category is added as is, as there is no way to combine them.
+func appendTaxCombo(ts tax.Set, tc *tax.Combo) tax.Set {
+	for _, c := range ts {
+		if c.Category != tc.Category || c.Country != tc.Country || c.Key != tc.Key {
+			continue
+		}
+		if c.Category != tax.CategoryST || c.Country != l10n.US.Tax() {
+			if c.Rate == tc.Rate && percentEquals(c.Percent, tc.Percent) {
+				return ts
+			}
+			continue
+		}
+		if tc.Percent == nil {
+			// Nothing to add, e.g. for an exempt jurisdiction
+			return ts
+		}
+		if c.Percent == nil {
+			c.Percent = tc.Percent
+			c.Rate = ""
+			return ts
+		}
+		sum, add := c.Percent.Amount(), tc.Percent.Amount()
+		if add.Exp() > sum.Exp() {
+			sum = sum.Rescale(add.Exp())
+		}
+		p := num.PercentageFromAmount(sum.Add(add))
+		c.Percent = &p
+		c.Rate = ""
+		return ts
+	}
+	return append(ts, tc)
+}
+
+// percentEquals checks if two optional percentages are the same.
+func percentEquals(a, b *num.Percentage) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equals(*b)
+}
+
+// taxKeyFromTaxRate returns the GOBL tax key stored in the metadata of a tax rate
+// created from GOBL (see ToTaxRateParams), so keys set with manual tax rates (e.g.
+// reverse charges), which Stripe doesn't flag with a taxability reason, are not lost.
+func taxKeyFromTaxRate(rate *stripe.TaxRate) cbc.Key {
+	if rate == nil {
+		return ""
+	}
+	return cbc.Key(rate.Metadata[metaKeyTaxKey])
+}
+
+// taxabilityReasonExcludedTerritory is the taxability reason Stripe reports for sales
+// to territories excluded from the tax, which stripe-go doesn't define.
+const taxabilityReasonExcludedTerritory = "excluded_territory"
+
+// taxabilityReasonKeys maps the taxability reasons Stripe reports for untaxed amounts
+// to GOBL tax keys. The reasons of taxed amounts (e.g. standard_rated or reduced_rated)
+// keep the category's default key, so they are not listed.
+var taxabilityReasonKeys = map[string]cbc.Key{
+	string(stripe.InvoiceTotalTaxAmountTaxabilityReasonReverseCharge):        tax.KeyReverseCharge,
+	string(stripe.InvoiceTotalTaxAmountTaxabilityReasonZeroRated):            tax.KeyZero,
+	string(stripe.InvoiceTotalTaxAmountTaxabilityReasonProductExempt):        tax.KeyExempt,
+	string(stripe.InvoiceTotalTaxAmountTaxabilityReasonProductExemptHoliday): tax.KeyExempt,
+	string(stripe.InvoiceTotalTaxAmountTaxabilityReasonCustomerExempt):       tax.KeyExempt,
+	string(stripe.InvoiceTotalTaxAmountTaxabilityReasonNotSubjectToTax):      tax.KeyOutsideScope,
+	string(stripe.InvoiceTotalTaxAmountTaxabilityReasonNotCollecting):        tax.KeyOutsideScope,
+	string(stripe.InvoiceTotalTaxAmountTaxabilityReasonNotSupported):         tax.KeyOutsideScope,
+	taxabilityReasonExcludedTerritory:                                        tax.KeyOutsideScope,
+}
+
+// taxKeyExemptions maps GOBL tax keys to the default VATEX exemption code (CEF
+// catalogue) used to justify untaxed amounts in EU countries, which EN 16931 requires
+// for outside scope, intra-community and export sales. Exempt sales have no default, as
+// the article of the exemption depends on the product, so their code can only come from
+// the metadata of tax rates created from GOBL (see ToTaxRateParams).
+var taxKeyExemptions = map[cbc.Key]cbc.Code{
+	tax.KeyOutsideScope:   "VATEX-EU-O",
+	tax.KeyIntraCommunity: "VATEX-EU-IC",
+	tax.KeyExport:         "VATEX-EU-G",
+}
+
+// newTaxKeyCombo creates a GOBL tax combo with the tax key of an untaxed Stripe tax
+// amount, taken from the metadata of tax rates created from GOBL or else from the
+// taxability reason. It returns nil when the amount has no key, or when the key isn't
+// defined in the category, so the caller records the tax by its percentage instead:
+// forcing an undefined key would make GOBL reject the line.
+//
+// A reverse charge or a sale outside the scope of the tax is a statement from the
+// supplier's side that it charges no tax, so it is expressed with the supplier
+// regime's own category and country, not the customer's. Stripe reports the
+// customer's tax category (e.g. Australian GST for an AU customer), which an EU
+// supplier's regime (e.g. Poland) doesn't define, so in that case we fall back to the
+// regime's VAT category. Zero rated and exempt sales keep the country of the rate.
+func newTaxKeyCombo(cat cbc.Code, reason string, rate *stripe.TaxRate, regimeDef *tax.RegimeDef) *tax.Combo {
+	key := taxKeyFromTaxRate(rate)
+	if key == "" {
+		key = taxabilityReasonKeys[reason]
+	}
+
+	tc := &tax.Combo{Key: key}
+	switch key {
+	case tax.KeyReverseCharge, tax.KeyOutsideScope:
+		tc.Category = supplierTaxCategory(cat, regimeDef)
+		tc.Country = supplierTaxCountry(rate, key, regimeDef)
+	case tax.KeyExempt, tax.KeyZero:
+		tc.Category = cat
+		tc.Country = regimeDef.Country
+		if rate != nil && rate.Country != "" {
+			tc.Country = l10n.TaxCountryCode(rate.Country)
+		}
+	default:
+		return nil
+	}
+
+	cd := tax.RegimeDefFor(tc.Country.Code()).CategoryDef(tc.Category)
+	if tc.Category == "" || cd == nil || cd.KeyDef(key) == nil {
+		return nil
+	}
+	if key == tax.KeyZero {
+		tc.Percent = num.NewPercentage(0, 3)
+	}
+	if code := taxExemptionCode(rate, key, tc.Country); code != "" {
+		tc.Ext = tax.Extensions{cef.ExtKeyVATEX: code}
+	}
+
+	return tc
+}
+
+// taxExemptionCode returns the VATEX exemption code of an untaxed amount in an EU
+// country: the one stored in the metadata of tax rates created from GOBL or else the
+// default one for the key.
+func taxExemptionCode(rate *stripe.TaxRate, key cbc.Key, country l10n.TaxCountryCode) cbc.Code {
+	if !l10n.Union(l10n.EU).HasMember(country.Code()) {
+		return ""
+	}
+	if rate != nil && rate.Metadata[metaKeyTaxExemption] != "" {
+		return cbc.Code(rate.Metadata[metaKeyTaxExemption])
+	}
+	return taxKeyExemptions[key]
+}
+
+// supplierTaxCountry returns the country of a supplier-side tax key. Tax rates created
+// from GOBL already carry the supplier's country, while the ones reported by Stripe
+// carry the customer's, so the regime's country is used instead.
+func supplierTaxCountry(rate *stripe.TaxRate, key cbc.Key, regimeDef *tax.RegimeDef) l10n.TaxCountryCode {
+	if taxKeyFromTaxRate(rate) == key && rate.Country != "" {
+		return l10n.TaxCountryCode(rate.Country)
+	}
+	return regimeDef.Country
+}
+
+// supplierTaxCategory picks the category to use for a supplier-side tax key, such as a
+// reverse charge. Stripe reports the category from the customer's perspective, so if
+// the regime already defines the reported category (e.g. VAT for an EU supplier) we
+// keep it; otherwise we fall back to the regime's VAT category. It returns an empty
+// code when the regime defines neither, signalling the caller to record the tax as-is
+// instead.
+func supplierTaxCategory(cat cbc.Code, regimeDef *tax.RegimeDef) cbc.Code {
 	if regimeDef.CategoryDef(cat) != nil {
 		return cat
 	}
@@ -490,3 +618,248 @@ func percentFromFloat(f float64) *num.Percentage {
 
 	return &p
 }
+
+// Invoice Items
+
+// ToInvoiceItemsParams converts GOBL bill lines into Stripe invoice item params. Line
+// discounts are applied with coupons (see ToLineDiscountCouponParams), which need to
+// exist in Stripe before the invoice items are created. Line charges have no
+// equivalent in Stripe, so each one is added as a separate invoice item right after
+// the item of its line.
+func ToInvoiceItemsParams(lines []*bill.Line, curr currency.Code, regimeDef *tax.RegimeDef) []*stripe.InvoiceItemParams {
+	coupons := NewCouponRegistry(nil)
+	items := make([]*stripe.InvoiceItemParams, 0, len(lines))
+	for _, line := range lines {
+		if line == nil || line.Item == nil {
+			continue
+		}
+		items = append(items, toInvoiceLineItemsParams(line, curr, regimeDef, coupons)...)
+	}
+	return items
+}
+
+// toInvoiceLineItemsParams converts a GOBL bill line into the Stripe invoice item params
+// of the line itself, using the coupons of the registry for the line discounts, followed
+// by one invoice item for each of the line charges.
+func toInvoiceLineItemsParams(line *bill.Line, curr currency.Code, regimeDef *tax.RegimeDef, coupons *CouponRegistry) []*stripe.InvoiceItemParams {
+	item := ToInvoiceItemParams(line, curr, regimeDef)
+	item.Discounts = coupons.toInvoiceItemDiscountsParams(line, curr)
+	items := []*stripe.InvoiceItemParams{item}
+	for _, charge := range line.Charges {
+		if charge == nil {
+			continue
+		}
+		items = append(items, toInvoiceItemChargeParams(line, charge, curr, regimeDef))
+	}
+	return items
+}
+
+// ToInvoiceItemParams converts a single GOBL bill line into a Stripe invoice item params
+// object, without the line discounts or charges. The customer and invoice IDs need to be
+// set before sending it to the Stripe API.
+func ToInvoiceItemParams(line *bill.Line, curr currency.Code, regimeDef *tax.RegimeDef) *stripe.InvoiceItemParams {
+	qty, price := resolveLineQuantityAndPrice(line, curr)
+	item := &stripe.InvoiceItemParams{
+		Currency:    stripe.String(string(ToCurrency(curr))),
+		Description: stripe.String(line.Item.Name),
+		Quantity:    stripe.Int64(qty),
+		Period:      toInvoiceItemPeriodParams(line.Period, regimeDef),
+	}
+	item.UnitAmount, item.UnitAmountDecimal = toStripeUnitAmount(price, curr)
+	if len(line.Item.Ext) > 0 {
+		// Ad-hoc items have no product, so the extensions go in the item's metadata
+		item.Metadata = toMetadataWithPrefix(line.Item.Ext, customDataItemExt)
+	}
+
+	return item
+}
+
+// toInvoiceItemChargeParams converts a GOBL line charge into a Stripe invoice item for
+// the charged amount, described by the charge reason and covering the same period as
+// its line.
+func toInvoiceItemChargeParams(line *bill.Line, charge *bill.LineCharge, curr currency.Code, regimeDef *tax.RegimeDef) *stripe.InvoiceItemParams {
+	description := charge.Reason
+	if description == "" {
+		description = line.Item.Name + " (charge)"
+	}
+	item := &stripe.InvoiceItemParams{
+		Currency:    stripe.String(string(ToCurrency(curr))),
+		Description: stripe.String(description),
+		Quantity:    stripe.Int64(1),
+		Period:      toInvoiceItemPeriodParams(line.Period, regimeDef),
+	}
+	item.UnitAmount, item.UnitAmountDecimal = toStripeUnitAmount(lineChargeAmount(line, charge, curr), curr)
+	return item
+}
+
+// resolveLineQuantityAndPrice picks the (quantity, unit price) pair to send to Stripe
+// for a GOBL line. Stripe quantities are whole numbers, so lines with a fractional
+// quantity are sent as a lump sum (`quantity = 1`, `price = line sum`). Negative
+// quantities are turned into a negative price, as Stripe uses negative amounts for credits.
+func resolveLineQuantityAndPrice(line *bill.Line, curr currency.Code) (int64, num.Amount) {
+	price := num.AmountZero
+	if line.Item.Price != nil {
+		price = *line.Item.Price
+	}
+
+	qty := line.Quantity
+	if qty.IsNegative() {
+		qty = qty.Negate()
+		price = price.Negate()
+	}
+
+	whole := qty.Rescale(0)
+	if !whole.Equals(qty) {
+		return 1, lineSum(line, curr)
+	}
+	return whole.Value(), price
+}
+
+// lineDiscountAmount returns the amount of a line discount, calculating it from the
+// percent of the base (or line sum) when the line has not been calculated yet.
+func lineDiscountAmount(line *bill.Line, discount *bill.LineDiscount, curr currency.Code) num.Amount {
+	if discount.Amount.IsZero() && discount.Percent != nil {
+		if discount.Base != nil {
+			return discount.Percent.Of(*discount.Base)
+		}
+		return discount.Percent.Of(lineSum(line, curr))
+	}
+	return discount.Amount
+}
+
+// lineChargeAmount returns the amount of a line charge, calculating it from the
+// percent of the base (or line sum) when the line has not been calculated yet.
+func lineChargeAmount(line *bill.Line, charge *bill.LineCharge, curr currency.Code) num.Amount {
+	if charge.Amount.IsZero() && charge.Percent != nil {
+		if charge.Base != nil {
+			return charge.Percent.Of(*charge.Base)
+		}
+		return charge.Percent.Of(lineSum(line, curr))
+	}
+	return charge.Amount
+}
+
+// lineSum returns the line sum, calculating it from the quantity and price when the
+// line has not been calculated yet.
+func lineSum(line *bill.Line, curr currency.Code) num.Amount {
+	if line.Sum != nil {
+		return *line.Sum
+	}
+	if line.Item == nil || line.Item.Price == nil {
+		return num.AmountZero
+	}
+	sum := line.Item.Price.Multiply(line.Quantity)
+	return sum.Rescale(CurrencyAmount(0, curr).Exp())
+}
+
+// toInvoiceItemPeriodParams converts a GOBL period into a Stripe invoice item period.
+func toInvoiceItemPeriodParams(period *cal.Period, regimeDef *tax.RegimeDef) *stripe.InvoiceItemPeriodParams {
+	if period == nil {
+		return nil
+	}
+	return &stripe.InvoiceItemPeriodParams{
+		Start: stripe.Int64(toTSFromDate(period.Start, regimeDef.TimeLocation())),
+		End:   stripe.Int64(toTSFromDate(period.End, regimeDef.TimeLocation())),
+	}
+}
+
+// Credit Note Lines Params
+
+// ToCreditNoteLinesParams converts GOBL bill lines into Stripe credit note lines. Each
+// line is matched by description, amount and period against the lines of the original
+// Stripe invoice: matching lines are credited as `invoice_line_item` lines and the rest
+// are added as `custom_line_item` lines. Every original line is matched at most once.
+func ToCreditNoteLinesParams(lines []*bill.Line, curr currency.Code, original []*stripe.InvoiceLineItem, regimeDef *tax.RegimeDef) []*stripe.CreditNoteLineParams {
+	used := make(map[string]bool)
+	cnLines := make([]*stripe.CreditNoteLineParams, 0, len(lines))
+	for _, line := range lines {
+		if line == nil || line.Item == nil {
+			continue
+		}
+		if ol := matchInvoiceLineItem(line, curr, original, used, regimeDef); ol != nil {
+			used[ol.ID] = true
+			cnLines = append(cnLines, toCreditNoteInvoiceLineParams(line, ol, curr))
+			continue
+		}
+		cnLines = append(cnLines, ToCreditNoteLineParams(line, curr))
+	}
+	return cnLines
+}
+
+// ToCreditNoteLineParams converts a single GOBL bill line into a Stripe custom credit
+// note line. Custom lines can't hold discounts, so lines with discounts or charges are
+// sent as a lump sum of the line total.
+func ToCreditNoteLineParams(line *bill.Line, curr currency.Code) *stripe.CreditNoteLineParams {
+	qty, price := resolveLineQuantityAndPrice(line, curr)
+	if len(line.Discounts) > 0 || len(line.Charges) > 0 {
+		qty, price = 1, lineTotal(line, curr)
+	}
+	cnLine := &stripe.CreditNoteLineParams{
+		Type:        stripe.String(string(stripe.CreditNoteLineItemTypeCustomLineItem)),
+		Description: stripe.String(line.Item.Name),
+		Quantity:    stripe.Int64(qty),
+	}
+	cnLine.UnitAmount, cnLine.UnitAmountDecimal = toStripeUnitAmount(price, curr)
+	return cnLine
+}
+
+// toCreditNoteInvoiceLineParams creates a credit note line that credits an existing
+// Stripe invoice line item. The whole line is credited by amount, so the discounts
+// applied to the original line are also credited proportionally by Stripe.
+func toCreditNoteInvoiceLineParams(line *bill.Line, ol *stripe.InvoiceLineItem, curr currency.Code) *stripe.CreditNoteLineParams {
+	amount := lineSum(line, curr).Abs()
+	return &stripe.CreditNoteLineParams{
+		Type:            stripe.String(string(stripe.CreditNoteLineItemTypeInvoiceLineItem)),
+		InvoiceLineItem: stripe.String(ol.ID),
+		Amount:          stripe.Int64(ToStripeInt(&amount, curr)),
+	}
+}
+
+// matchInvoiceLineItem looks for an unused line of the original Stripe invoice with the
+// same description, amount and period as the GOBL line.
+func matchInvoiceLineItem(line *bill.Line, curr currency.Code, original []*stripe.InvoiceLineItem, used map[string]bool, regimeDef *tax.RegimeDef) *stripe.InvoiceLineItem {
+	sum := lineSum(line, curr).Abs()
+	for _, ol := range original {
+		if ol == nil || ol.ID == "" || used[ol.ID] {
+			continue
+		}
+		if setItemName(ol) != line.Item.Name {
+			continue
+		}
+		if !CurrencyAmount(ol.Amount, curr).Abs().Equals(sum) {
+			continue
+		}
+		if line.Period != nil {
+			if ol.Period == nil {
+				continue
+			}
+			start := newDateFromTS(ol.Period.Start, regimeDef.TimeLocation())
+			end := newDateFromTS(ol.Period.End, regimeDef.TimeLocation())
+			if *start != line.Period.Start || *end != line.Period.End {
+				continue
+			}
+		}
+		return ol
+	}
+	return nil
+}
+
+// lineTotal returns the line total after discounts and charges, calculating it when
+// the line has not been calculated yet.
+func lineTotal(line *bill.Line, curr currency.Code) num.Amount {
+	if line.Total != nil {
+		return *line.Total
+	}
+	total := lineSum(line, curr)
+	for _, d := range line.Discounts {
+		if d != nil {
+			total = total.Subtract(lineDiscountAmount(line, d, curr))
+		}
+	}
+	for _, c := range line.Charges {
+		if c != nil {
+			total = total.Add(lineChargeAmount(line, c, curr))
+		}
+	}
+	return total
+}
@@ -7,12 +7,14 @@ import (
 	goblstripe "github.com/invopop/gobl.stripe"
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/currency"
 	"github.com/invopop/gobl/l10n"
 	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stripe/stripe-go/v81"
 )
 
@@ -780,6 +782,30 @@ func TestFromInvoiceTaxAmountsReverseCharge(t *testing.T) {
 				},
 			},
 		},
+		{
+			// Manual tax rates created from GOBL have no taxability reason, but keep
+			// the GOBL key and the supplier's country.
+			name: "manual reverse charge rate created from GOBL",
+			input: []*stripe.InvoiceTotalTaxAmount{
+				{
+					TaxRate: &stripe.TaxRate{
+						Country:     "PT",
+						TaxType:     stripe.TaxRateTaxTypeVAT,
+						DisplayName: "VAT",
+						Metadata:    map[string]string{"gobl-tax-key": "reverse-charge"},
+						Created:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix(),
+					},
+				},
+			},
+			regime: l10n.DE,
+			expected: tax.Set{
+				{
+					Category: tax.CategoryVAT,
+					Country:  l10n.PT.Tax(),
+					Key:      tax.KeyReverseCharge,
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -790,6 +816,247 @@ func TestFromInvoiceTaxAmountsReverseCharge(t *testing.T) {
 	}
 }
 
+func TestFromInvoiceTaxAmountsTaxabilityReasons(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	tests := []struct {
+		name     string
+		input    *stripe.InvoiceTotalTaxAmount
+		regime   l10n.Code
+		expected *tax.Combo
+	}{
+		{
+			name: "zero rated",
+			input: &stripe.InvoiceTotalTaxAmount{
+				TaxRate: &stripe.TaxRate{
+					Country: "GB",
+					TaxType: stripe.TaxRateTaxTypeVAT,
+					Created: created,
+				},
+				TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReasonZeroRated,
+			},
+			regime: l10n.GB,
+			expected: &tax.Combo{
+				Category: tax.CategoryVAT,
+				Country:  l10n.GB.Tax(),
+				Key:      tax.KeyZero,
+				Percent:  num.NewPercentage(0, 3),
+			},
+		},
+		{
+			name: "product exempt in the EU",
+			input: &stripe.InvoiceTotalTaxAmount{
+				TaxRate: &stripe.TaxRate{
+					Country: "DE",
+					TaxType: stripe.TaxRateTaxTypeVAT,
+					Created: created,
+				},
+				TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReasonProductExempt,
+			},
+			regime: l10n.DE,
+			expected: &tax.Combo{
+				Category: tax.CategoryVAT,
+				Country:  l10n.DE.Tax(),
+				Key:      tax.KeyExempt,
+			},
+		},
+		{
+			name: "customer exempt outside the EU",
+			input: &stripe.InvoiceTotalTaxAmount{
+				TaxRate: &stripe.TaxRate{
+					Country: "GB",
+					TaxType: stripe.TaxRateTaxTypeVAT,
+					Created: created,
+				},
+				TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReasonCustomerExempt,
+			},
+			regime: l10n.GB,
+			expected: &tax.Combo{
+				Category: tax.CategoryVAT,
+				Country:  l10n.GB.Tax(),
+				Key:      tax.KeyExempt,
+			},
+		},
+		{
+			// Not collecting in the customer's country is a statement from the
+			// supplier's side, like a reverse charge.
+			name: "not collecting",
+			input: &stripe.InvoiceTotalTaxAmount{
+				TaxRate: &stripe.TaxRate{
+					Country: "AU",
+					TaxType: stripe.TaxRateTaxTypeGST,
+					Created: created,
+				},
+				TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReasonNotCollecting,
+			},
+			regime: l10n.ES,
+			expected: &tax.Combo{
+				Category: tax.CategoryVAT,
+				Country:  l10n.ES.Tax(),
+				Key:      tax.KeyOutsideScope,
+				Ext:      tax.Extensions{"cef-vatex": "VATEX-EU-O"},
+			},
+		},
+		{
+			name: "excluded territory",
+			input: &stripe.InvoiceTotalTaxAmount{
+				TaxRate: &stripe.TaxRate{
+					Country: "ES",
+					TaxType: stripe.TaxRateTaxTypeVAT,
+					Created: created,
+				},
+				TaxabilityReason: "excluded_territory",
+			},
+			regime: l10n.ES,
+			expected: &tax.Combo{
+				Category: tax.CategoryVAT,
+				Country:  l10n.ES.Tax(),
+				Key:      tax.KeyOutsideScope,
+				Ext:      tax.Extensions{"cef-vatex": "VATEX-EU-O"},
+			},
+		},
+		{
+			name: "manual exempt rate created from GOBL",
+			input: &stripe.InvoiceTotalTaxAmount{
+				TaxRate: &stripe.TaxRate{
+					Country:     "FR",
+					TaxType:     stripe.TaxRateTaxTypeVAT,
+					DisplayName: "VAT",
+					Metadata: map[string]string{
+						"gobl-tax-key":       "exempt",
+						"gobl-tax-exemption": "VATEX-EU-143",
+					},
+					Created: created,
+				},
+			},
+			regime: l10n.DE,
+			expected: &tax.Combo{
+				Category: tax.CategoryVAT,
+				Country:  l10n.FR.Tax(),
+				Key:      tax.KeyExempt,
+				Ext:      tax.Extensions{"cef-vatex": "VATEX-EU-143"},
+			},
+		},
+		{
+			// Sales tax defines no keys, so the rate is recorded by its percentage.
+			name: "product exempt sales tax",
+			input: &stripe.InvoiceTotalTaxAmount{
+				TaxRate: &stripe.TaxRate{
+					Country: "US",
+					TaxType: stripe.TaxRateTaxTypeSalesTax,
+					Created: created,
+				},
+				TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReasonProductExempt,
+			},
+			regime: l10n.US,
+			expected: &tax.Combo{
+				Category: tax.CategoryST,
+				Country:  l10n.US.Tax(),
+				Percent:  num.NewPercentage(0, 3),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := goblstripe.FromInvoiceTaxAmountToTaxCombo(tt.input, tax.RegimeDefFor(tt.regime))
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestFromInvoiceTaxAmountsStackedCategories(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	tests := []struct {
+		name     string
+		input    []*stripe.InvoiceTotalTaxAmount
+		regime   l10n.Code
+		expected []cbc.Code
+	}{
+		{
+			name: "Canadian GST and PST",
+			input: []*stripe.InvoiceTotalTaxAmount{
+				{Amount: 500, TaxRate: &stripe.TaxRate{Country: "CA", TaxType: stripe.TaxRateTaxTypeGST, Percentage: 5, Created: created}},
+				{Amount: 700, TaxRate: &stripe.TaxRate{Country: "CA", State: "BC", TaxType: stripe.TaxRateTaxTypePST, Percentage: 7, Created: created}},
+			},
+			regime:   l10n.CA,
+			expected: []cbc.Code{"GST", "PST"},
+		},
+		{
+			name: "Canadian GST and QST",
+			input: []*stripe.InvoiceTotalTaxAmount{
+				{Amount: 500, TaxRate: &stripe.TaxRate{Country: "CA", TaxType: stripe.TaxRateTaxTypeGST, Percentage: 5, Created: created}},
+				{Amount: 998, TaxRate: &stripe.TaxRate{Country: "CA", State: "QC", TaxType: stripe.TaxRateTaxTypeQST, Percentage: 9.975, Created: created}},
+			},
+			regime:   l10n.CA,
+			expected: []cbc.Code{"GST", "PST"},
+		},
+		{
+			name: "Canadian HST",
+			input: []*stripe.InvoiceTotalTaxAmount{
+				{Amount: 1300, TaxRate: &stripe.TaxRate{Country: "CA", State: "ON", TaxType: stripe.TaxRateTaxTypeHST, Percentage: 13, Created: created}},
+			},
+			regime:   l10n.CA,
+			expected: []cbc.Code{"HST"},
+		},
+		{
+			name: "Indian CGST and SGST from the jurisdiction level",
+			input: []*stripe.InvoiceTotalTaxAmount{
+				{Amount: 900, TaxRate: &stripe.TaxRate{Country: "IN", TaxType: stripe.TaxRateTaxTypeGST, JurisdictionLevel: stripe.TaxRateJurisdictionLevelCountry, Percentage: 9, Created: created}},
+				{Amount: 900, TaxRate: &stripe.TaxRate{Country: "IN", State: "KA", TaxType: stripe.TaxRateTaxTypeGST, JurisdictionLevel: stripe.TaxRateJurisdictionLevelState, Percentage: 9, Created: created}},
+			},
+			regime:   l10n.IN,
+			expected: []cbc.Code{"CGST", "SGST"},
+		},
+		{
+			name: "Indian CGST and UTGST from the display name",
+			input: []*stripe.InvoiceTotalTaxAmount{
+				{Amount: 900, TaxRate: &stripe.TaxRate{Country: "IN", TaxType: stripe.TaxRateTaxTypeGST, DisplayName: "CGST", Percentage: 9, Created: created}},
+				{Amount: 900, TaxRate: &stripe.TaxRate{Country: "IN", TaxType: stripe.TaxRateTaxTypeGST, DisplayName: "UTGST", Percentage: 9, Created: created}},
+			},
+			regime:   l10n.IN,
+			expected: []cbc.Code{"CGST", "UTGST"},
+		},
+		{
+			name: "Indian IGST",
+			input: []*stripe.InvoiceTotalTaxAmount{
+				{Amount: 1800, TaxRate: &stripe.TaxRate{Country: "IN", TaxType: stripe.TaxRateTaxTypeIGST, Percentage: 18, Created: created}},
+			},
+			regime:   l10n.IN,
+			expected: []cbc.Code{"IGST"},
+		},
+		{
+			name: "repeated reverse charge",
+			input: []*stripe.InvoiceTotalTaxAmount{
+				{TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReasonReverseCharge, TaxRate: &stripe.TaxRate{Country: "DE", TaxType: stripe.TaxRateTaxTypeVAT, Created: created}},
+				{TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReasonReverseCharge, TaxRate: &stripe.TaxRate{Country: "DE", TaxType: stripe.TaxRateTaxTypeVAT, Created: created}},
+			},
+			regime:   l10n.DE,
+			expected: []cbc.Code{"VAT"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := goblstripe.FromInvoiceTaxAmountsToTaxSet(tt.input, tax.RegimeDefFor(tt.regime))
+			cats := make([]cbc.Code, len(result))
+			for i, tc := range result {
+				cats[i] = tc.Category
+			}
+			assert.Equal(t, tt.expected, cats)
+		})
+	}
+
+	t.Run("only US sales tax rates are added up", func(t *testing.T) {
+		vat := &stripe.TaxRate{Country: "DE", TaxType: stripe.TaxRateTaxTypeVAT, Percentage: 19, Created: created}
+		result := goblstripe.FromInvoiceTaxAmountsToTaxSet([]*stripe.InvoiceTotalTaxAmount{
+			{Amount: 1900, TaxRate: vat},
+			{Amount: 1900, TaxRate: vat},
+		}, tax.RegimeDefFor(l10n.DE))
+		require.Len(t, result, 1)
+		assert.Equal(t, tax.RateGeneral, result[0].Rate)
+	})
+}
+
 func TestFromCreditNoteTaxAmountsReverseCharge(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -1451,3 +1718,300 @@ func TestFromCreditNoteLineDiscountsAllZeroAmount(t *testing.T) {
 	assert.NotNil(t, result, "Result should not be nil")
 	assert.Len(t, result, 0, "Result should be empty when all discounts are zero")
 }
+
+func TestToInvoiceItemsParams(t *testing.T) {
+	regimeDef := tax.RegimeDefFor(l10n.ES)
+
+	t.Run("per unit price", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(3, 0),
+			Item: &org.Item{
+				Name:  "Widget",
+				Price: num.NewAmount(1050, 2),
+			},
+		}
+		items := goblstripe.ToInvoiceItemsParams([]*bill.Line{line}, currency.EUR, regimeDef)
+		assert.Len(t, items, 1)
+		assert.Equal(t, "eur", stripe.StringValue(items[0].Currency))
+		assert.Equal(t, int64(3), stripe.Int64Value(items[0].Quantity))
+		assert.Equal(t, int64(1050), stripe.Int64Value(items[0].UnitAmount))
+		assert.Nil(t, items[0].UnitAmountDecimal)
+		assert.Nil(t, items[0].Period)
+		assert.Nil(t, items[0].Metadata)
+	})
+
+	t.Run("item extensions", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(1, 0),
+			Item: &org.Item{
+				Name:  "Widget",
+				Price: num.NewAmount(1050, 2),
+				Ext:   tax.Extensions{"mx-cfdi-prod-serv": "43211505"},
+			},
+		}
+		items := goblstripe.ToInvoiceItemsParams([]*bill.Line{line}, currency.EUR, regimeDef)
+		assert.Len(t, items, 1)
+		assert.Equal(t, "43211505", items[0].Metadata["gobl-item-mx-cfdi-prod-serv"])
+	})
+
+	t.Run("price with extra precision", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(1000, 0),
+			Item: &org.Item{
+				Name:  "API calls",
+				Price: num.NewAmount(1234, 4),
+			},
+		}
+		items := goblstripe.ToInvoiceItemsParams([]*bill.Line{line}, currency.EUR, regimeDef)
+		assert.Len(t, items, 1)
+		assert.Nil(t, items[0].UnitAmount)
+		assert.Equal(t, 12.34, stripe.Float64Value(items[0].UnitAmountDecimal))
+	})
+
+	t.Run("fractional quantity", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(15, 1),
+			Item: &org.Item{
+				Name:  "Consulting hours",
+				Price: num.NewAmount(8000, 2),
+			},
+		}
+		items := goblstripe.ToInvoiceItemsParams([]*bill.Line{line}, currency.EUR, regimeDef)
+		assert.Len(t, items, 1)
+		assert.Equal(t, int64(1), stripe.Int64Value(items[0].Quantity))
+		assert.Equal(t, int64(12000), stripe.Int64Value(items[0].UnitAmount))
+	})
+
+	t.Run("negative quantity", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(-2, 0),
+			Item: &org.Item{
+				Name:  "Returned widget",
+				Price: num.NewAmount(1000, 2),
+			},
+		}
+		items := goblstripe.ToInvoiceItemsParams([]*bill.Line{line}, currency.EUR, regimeDef)
+		assert.Len(t, items, 1)
+		assert.Equal(t, int64(2), stripe.Int64Value(items[0].Quantity))
+		assert.Equal(t, int64(-1000), stripe.Int64Value(items[0].UnitAmount))
+	})
+
+	t.Run("zero decimal currency", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(1, 0),
+			Item: &org.Item{
+				Name:  "Widget",
+				Price: num.NewAmount(1500, 0),
+			},
+		}
+		items := goblstripe.ToInvoiceItemsParams([]*bill.Line{line}, currency.JPY, regimeDef)
+		assert.Equal(t, "jpy", stripe.StringValue(items[0].Currency))
+		assert.Equal(t, int64(1500), stripe.Int64Value(items[0].UnitAmount))
+	})
+
+	t.Run("line discounts", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(1, 0),
+			Item: &org.Item{
+				Name:  "Item with discount",
+				Price: num.NewAmount(14900, 2),
+			},
+			Period: &cal.Period{
+				Start: cal.MakeDate(2025, 8, 8),
+				End:   cal.MakeDate(2025, 8, 8),
+			},
+			Discounts: []*bill.LineDiscount{
+				{
+					Reason: "Fixed discount",
+					Amount: num.MakeAmount(5000, 2),
+				},
+				{
+					Percent: num.NewPercentage(10, 2),
+				},
+			},
+		}
+		items := goblstripe.ToInvoiceItemsParams([]*bill.Line{line}, currency.EUR, regimeDef)
+		assert.Len(t, items, 1)
+		assert.Equal(t, int64(14900), stripe.Int64Value(items[0].UnitAmount))
+		assert.Len(t, items[0].Discounts, 2)
+
+		fixed := goblstripe.ToLineDiscountCouponParams(line, line.Discounts[0], currency.EUR)
+		percent := goblstripe.ToLineDiscountCouponParams(line, line.Discounts[1], currency.EUR)
+		assert.Equal(t, stripe.StringValue(fixed.ID), stripe.StringValue(items[0].Discounts[0].Coupon))
+		assert.Equal(t, stripe.StringValue(percent.ID), stripe.StringValue(items[0].Discounts[1].Coupon))
+		assert.Equal(t, int64(1490), stripe.Int64Value(percent.AmountOff), "stacked percent discounts use the GOBL amount")
+	})
+
+	t.Run("line charges", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(2, 0),
+			Item: &org.Item{
+				Name:  "Widget",
+				Price: num.NewAmount(5000, 2),
+			},
+			Charges: []*bill.LineCharge{
+				{
+					Reason: "Handling",
+					Amount: num.MakeAmount(750, 2),
+				},
+				{
+					Percent: num.NewPercentage(5, 2),
+				},
+			},
+		}
+		items := goblstripe.ToInvoiceItemsParams([]*bill.Line{line}, currency.EUR, regimeDef)
+		require.Len(t, items, 3)
+		assert.Equal(t, int64(5000), stripe.Int64Value(items[0].UnitAmount))
+		assert.Equal(t, "Handling", stripe.StringValue(items[1].Description))
+		assert.Equal(t, int64(1), stripe.Int64Value(items[1].Quantity))
+		assert.Equal(t, int64(750), stripe.Int64Value(items[1].UnitAmount))
+		assert.Equal(t, "Widget (charge)", stripe.StringValue(items[2].Description))
+		assert.Equal(t, int64(500), stripe.Int64Value(items[2].UnitAmount))
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		items := goblstripe.ToInvoiceItemsParams(nil, currency.EUR, regimeDef)
+		assert.NotNil(t, items)
+		assert.Len(t, items, 0)
+	})
+
+	t.Run("period in regime timezone", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(1, 0),
+			Item: &org.Item{
+				Name:  "Monthly plan",
+				Price: num.NewAmount(1000, 2),
+			},
+			Period: &cal.Period{
+				Start: cal.MakeDate(2025, 1, 1),
+				End:   cal.MakeDate(2025, 1, 31),
+			},
+		}
+		items := goblstripe.ToInvoiceItemsParams([]*bill.Line{line}, currency.EUR, regimeDef)
+		madrid, _ := time.LoadLocation("Europe/Madrid")
+		assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, madrid).Unix(), stripe.Int64Value(items[0].Period.Start))
+		assert.Equal(t, time.Date(2025, 1, 31, 0, 0, 0, 0, madrid).Unix(), stripe.Int64Value(items[0].Period.End))
+	})
+}
+
+func TestToCreditNoteLinesParams(t *testing.T) {
+	regimeDef := tax.RegimeDefFor(l10n.DE)
+
+	t.Run("custom line per unit", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(3, 0),
+			Item: &org.Item{
+				Name:  "Widget",
+				Price: num.NewAmount(1050, 2),
+			},
+		}
+		lines := goblstripe.ToCreditNoteLinesParams([]*bill.Line{line}, currency.EUR, nil, regimeDef)
+		require.Len(t, lines, 1)
+		assert.Equal(t, string(stripe.CreditNoteLineItemTypeCustomLineItem), stripe.StringValue(lines[0].Type))
+		assert.Equal(t, "Widget", stripe.StringValue(lines[0].Description))
+		assert.Equal(t, int64(3), stripe.Int64Value(lines[0].Quantity))
+		assert.Equal(t, int64(1050), stripe.Int64Value(lines[0].UnitAmount))
+		assert.Nil(t, lines[0].InvoiceLineItem)
+	})
+
+	t.Run("custom line with charges", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(2, 0),
+			Item: &org.Item{
+				Name:  "Widget",
+				Price: num.NewAmount(5000, 2),
+			},
+			Charges: []*bill.LineCharge{
+				{Amount: num.MakeAmount(750, 2)},
+			},
+		}
+		lines := goblstripe.ToCreditNoteLinesParams([]*bill.Line{line}, currency.EUR, nil, regimeDef)
+		require.Len(t, lines, 1)
+		assert.Equal(t, int64(1), stripe.Int64Value(lines[0].Quantity))
+		assert.Equal(t, int64(10750), stripe.Int64Value(lines[0].UnitAmount))
+	})
+
+	t.Run("matching original line", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(2, 0),
+			Item: &org.Item{
+				Name:  "Seat",
+				Price: num.NewAmount(1000, 2),
+			},
+		}
+		original := []*stripe.InvoiceLineItem{
+			{ID: "il_1", Description: "Other", Amount: 2000},
+			{ID: "il_2", Description: "Seat", Amount: 2000},
+		}
+		lines := goblstripe.ToCreditNoteLinesParams([]*bill.Line{line}, currency.EUR, original, regimeDef)
+		require.Len(t, lines, 1)
+		assert.Equal(t, string(stripe.CreditNoteLineItemTypeInvoiceLineItem), stripe.StringValue(lines[0].Type))
+		assert.Equal(t, "il_2", stripe.StringValue(lines[0].InvoiceLineItem))
+		assert.Equal(t, int64(2000), stripe.Int64Value(lines[0].Amount))
+	})
+
+	t.Run("custom line with discounts", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(2, 0),
+			Item: &org.Item{
+				Name:  "Widget",
+				Price: num.NewAmount(5000, 2),
+			},
+			Discounts: []*bill.LineDiscount{
+				{Percent: num.NewPercentage(10, 2)},
+			},
+		}
+		lines := goblstripe.ToCreditNoteLinesParams([]*bill.Line{line}, currency.EUR, nil, regimeDef)
+		assert.Len(t, lines, 1)
+		assert.Equal(t, string(stripe.CreditNoteLineItemTypeCustomLineItem), stripe.StringValue(lines[0].Type))
+		assert.Equal(t, int64(1), stripe.Int64Value(lines[0].Quantity))
+		assert.Equal(t, int64(9000), stripe.Int64Value(lines[0].UnitAmount))
+	})
+
+	t.Run("original lines matched once", func(t *testing.T) {
+		newLine := func() *bill.Line {
+			return &bill.Line{
+				Quantity: num.MakeAmount(1, 0),
+				Item: &org.Item{
+					Name:  "Seat",
+					Price: num.NewAmount(1000, 2),
+				},
+			}
+		}
+		original := []*stripe.InvoiceLineItem{
+			{ID: "il_1", Description: "Seat", Amount: 1000},
+		}
+		lines := goblstripe.ToCreditNoteLinesParams([]*bill.Line{newLine(), newLine()}, currency.EUR, original, regimeDef)
+		assert.Len(t, lines, 2)
+		assert.Equal(t, "il_1", stripe.StringValue(lines[0].InvoiceLineItem))
+		assert.Equal(t, string(stripe.CreditNoteLineItemTypeCustomLineItem), stripe.StringValue(lines[1].Type))
+	})
+
+	t.Run("period mismatch", func(t *testing.T) {
+		line := &bill.Line{
+			Quantity: num.MakeAmount(1, 0),
+			Item: &org.Item{
+				Name:  "Seat",
+				Price: num.NewAmount(1000, 2),
+			},
+			Period: &cal.Period{
+				Start: cal.MakeDate(2025, 2, 1),
+				End:   cal.MakeDate(2025, 2, 28),
+			},
+		}
+		original := []*stripe.InvoiceLineItem{
+			{
+				ID:          "il_1",
+				Description: "Seat",
+				Amount:      1000,
+				Period: &stripe.Period{
+					Start: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Unix(),
+					End:   time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC).Unix(),
+				},
+			},
+		}
+		lines := goblstripe.ToCreditNoteLinesParams([]*bill.Line{line}, currency.EUR, original, regimeDef)
+		assert.Len(t, lines, 1)
+		assert.Equal(t, string(stripe.CreditNoteLineItemTypeCustomLineItem), stripe.StringValue(lines[0].Type))
+	})
+}
@@ -110,7 +110,7 @@ func ToCustomerTaxIDDataParamsForTax(tID *tax.Identity) *stripe.CustomerTaxIDDat
 	if tID == nil {
 		return nil
 	}
-	eu := l10n.Unions().Code(l10n.EU)
+	eu := l10n.Union(l10n.EU)
 	if eu.HasMember(tID.Country.Code()) {
 		return &stripe.CustomerTaxIDDataParams{
 			Type:  stripe.String(string(stripe.TaxIDTypeEUVAT)),
@@ -224,32 +224,132 @@ func FromTaxIDToOrg(taxID *stripe.TaxID) *org.Identity {
 }
 
 // ToCustomerParams converts a GOBL org.Party into a stripe customer object suitable for
-// sending to the Stripe API.
-func ToCustomerParams(party *org.Party) *stripe.CustomerParams {
+// sending to the Stripe API. The first address labelled as a delivery or shipping
+// address is used for the customer shipping details, and the first of the rest as the
+// billing address. The party extensions are kept in the metadata so that FromCustomer
+// can read them back. Use WithSupplier to flag customers in another EU state as
+// reverse charge.
+func ToCustomerParams(party *org.Party, opts ...ParamsOption) *stripe.CustomerParams {
 	if party == nil {
 		return nil
 	}
+	options := newParamsOptions(opts)
 	cus := &stripe.CustomerParams{
 		Name:     stripe.String(party.Name),
-		Metadata: make(map[string]string),
+		Metadata: toMetadataWithPrefix(party.Ext, customDataCustomerExt),
+	}
+	if !party.UUID.IsZero() {
+		cus.Metadata[MetaKeyGOBLUUID] = party.UUID.String()
 	}
 	if len(party.Emails) > 0 {
 		cus.Email = stripe.String(party.Emails[0].Address)
 	}
-	if len(party.Addresses) > 0 {
-		cus.Address = ToAddressParams(party.Addresses[0])
+	if len(party.Telephones) > 0 && party.Telephones[0] != nil {
+		cus.Phone = stripe.String(party.Telephones[0].Number)
 	}
-	if party.TaxID != nil {
-		tID := ToCustomerTaxIDDataParamsForTax(party.TaxID)
-		if tID != nil {
-			cus.TaxIDData = []*stripe.CustomerTaxIDDataParams{
-				tID,
-			}
+	billing, delivery := splitPartyAddresses(party.Addresses)
+	if billing != nil {
+		cus.Address = ToAddressParams(billing)
+	}
+	if delivery != nil {
+		cus.Shipping = &stripe.CustomerShippingParams{
+			Name:    stripe.String(party.Name),
+			Address: ToAddressParams(delivery),
 		}
+		if cus.Phone != nil {
+			cus.Shipping.Phone = cus.Phone
+		}
+	}
+	cus.TaxIDData = toCustomerTaxIDDataParams(party)
+	if isIntraEUCustomer(party, options.supplier) {
+		cus.TaxExempt = stripe.String(string(stripe.CustomerTaxExemptReverse))
 	}
 	return cus
 }
 
+// ToCustomerShippingParams converts the receiver of a GOBL delivery into the Stripe
+// customer shipping details. It is the reverse of FromShippingDetailsToDeliveryDetails.
+func ToCustomerShippingParams(receiver *org.Party) *stripe.CustomerShippingParams {
+	if receiver == nil || len(receiver.Addresses) == 0 {
+		return nil
+	}
+	shipping := &stripe.CustomerShippingParams{
+		Name:    stripe.String(receiver.Name),
+		Address: ToAddressParams(receiver.Addresses[0]),
+	}
+	if len(receiver.Telephones) > 0 && receiver.Telephones[0] != nil {
+		shipping.Phone = stripe.String(receiver.Telephones[0].Number)
+	}
+	return shipping
+}
+
+// splitPartyAddresses picks the billing and delivery addresses of a party. Addresses
+// are considered delivery addresses when their label says so.
+func splitPartyAddresses(addresses []*org.Address) (billing, delivery *org.Address) {
+	for _, addr := range addresses {
+		if addr == nil {
+			continue
+		}
+		if isDeliveryAddress(addr) {
+			if delivery == nil {
+				delivery = addr
+			}
+			continue
+		}
+		if billing == nil {
+			billing = addr
+		}
+	}
+	return billing, delivery
+}
+
+// isDeliveryAddress checks if the label of the address marks it as a delivery address.
+func isDeliveryAddress(addr *org.Address) bool {
+	label := strings.ToLower(addr.Label)
+	return strings.Contains(label, "delivery") || strings.Contains(label, "shipping")
+}
+
+// toCustomerTaxIDDataParams converts the tax ID and the identities of a party that
+// Stripe supports into the customer tax ID data, skipping duplicates.
+func toCustomerTaxIDDataParams(party *org.Party) []*stripe.CustomerTaxIDDataParams {
+	var list []*stripe.CustomerTaxIDDataParams
+	add := func(tID *stripe.CustomerTaxIDDataParams) {
+		if tID == nil {
+			return
+		}
+		for _, t := range list {
+			if *t.Type == *tID.Type && *t.Value == *tID.Value {
+				return
+			}
+		}
+		list = append(list, tID)
+	}
+	if party.TaxID != nil && party.TaxID.Code != "" {
+		add(ToCustomerTaxIDDataParamsForTax(party.TaxID))
+	}
+	for _, id := range party.Identities {
+		if taxID := ToTaxIDFromOrg(id); taxID != nil {
+			add(&stripe.CustomerTaxIDDataParams{
+				Type:  stripe.String(string(taxID.Type)),
+				Value: stripe.String(taxID.Value),
+			})
+		}
+	}
+	return list
+}
+
+// isIntraEUCustomer checks if the customer is a business registered for tax in an EU
+// state other than the supplier's one, where the reverse charge mechanism applies.
+func isIntraEUCustomer(customer, supplier *org.Party) bool {
+	if supplier == nil || supplier.TaxID == nil || customer.TaxID == nil || customer.TaxID.Code == "" {
+		return false
+	}
+	eu := l10n.Union(l10n.EU)
+	cc := customer.TaxID.Country.Code()
+	sc := supplier.TaxID.Country.Code()
+	return cc != sc && eu.HasMember(cc) && eu.HasMember(sc)
+}
+
 // FromCustomer converts a stripe customer object into a GOBL org.Party.
 func FromCustomer(customer *stripe.Customer) *org.Party {
 	/*
@@ -288,11 +388,17 @@ func FromCustomer(customer *stripe.Customer) *org.Party {
 			customerParty = new(org.Party)
 		}
 
-		if slices.Contains(orgIDKeys, customer.TaxIDs.Data[0].Type) {
-			customerParty.Identities = make([]*org.Identity, 1)
-			customerParty.Identities[0] = FromTaxIDToOrg(customer.TaxIDs.Data[0])
-		} else {
-			customerParty.TaxID = FromTaxIDToTax(customer.TaxIDs.Data[0])
+		// The first tax ID is used as the party tax identity, and the ones that GOBL
+		// represents as org identities are all kept, as written by ToCustomerParams.
+		for _, taxID := range customer.TaxIDs.Data {
+			if taxID == nil {
+				continue
+			}
+			if slices.Contains(orgIDKeys, taxID.Type) {
+				customerParty.Identities = append(customerParty.Identities, FromTaxIDToOrg(taxID))
+			} else if customerParty.TaxID == nil {
+				customerParty.TaxID = FromTaxIDToTax(taxID)
+			}
 		}
 	}
 
@@ -304,7 +410,12 @@ func FromCustomer(customer *stripe.Customer) *org.Party {
 	}
 
 	if len(customer.Metadata) != 0 {
-		customerParty.Ext = newExtensionsWithPrefix(customer.Metadata, customDataCustomerExt)
+		if ext := newExtensionsWithPrefix(customer.Metadata, customDataCustomerExt); len(ext) > 0 {
+			if customerParty == nil {
+				customerParty = new(org.Party)
+			}
+			customerParty.Ext = ext
+		}
 	}
 
 	return customerParty
@@ -492,8 +603,16 @@ func ToAddressParams(addr *org.Address) *stripe.AddressParams {
 		Line1:      stripe.String(addr.Street),
 		Line2:      stripe.String(addr.StreetExtra),
 		City:       stripe.String(addr.Locality),
-		State:      stripe.String(addr.Region),
+		State:      stripe.String(toAddressState(addr)),
 		PostalCode: stripe.String(addr.Code.String()),
 		Country:    stripe.String(addr.Country.String()),
 	}
 }
+
+// toAddressState returns the state code of the address, or the region when it has none.
+func toAddressState(addr *org.Address) string {
+	if addr.State != "" {
+		return addr.State.String()
+	}
+	return addr.Region
+}
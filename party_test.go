@@ -362,6 +362,20 @@ func TestFromCustomer(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "with only extensions",
+			input: &stripe.Customer{
+				Metadata: map[string]string{
+					"gobl-customer-foo": "bar",
+					"other":             "value",
+				},
+			},
+			expected: &org.Party{
+				Ext: tax.Extensions{
+					"foo": "bar",
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -372,6 +386,153 @@ func TestFromCustomer(t *testing.T) {
 	}
 }
 
+func TestToCustomerParams(t *testing.T) {
+	party := &org.Party{
+		Name: "Test Company",
+		TaxID: &tax.Identity{
+			Country: "FR",
+			Code:    "44732829320",
+		},
+		Identities: []*org.Identity{
+			{
+				Country: l10n.DE.ISO(),
+				Key:     de.IdentityKeyTaxNumber,
+				Code:    "123456789",
+			},
+			{
+				Type: "OTHER",
+				Code: "999",
+			},
+		},
+		Addresses: []*org.Address{
+			{
+				Label:    "Warehouse delivery",
+				Street:   "Quai de la Gare 5",
+				Locality: "Paris",
+				Code:     "75013",
+				Country:  "FR",
+			},
+			{
+				Street:   "Rue de Rivoli 1",
+				Locality: "Paris",
+				Region:   "Île-de-France",
+				Code:     "75001",
+				Country:  "FR",
+			},
+		},
+		Emails: []*org.Email{
+			{Address: "billing@example.com"},
+		},
+		Telephones: []*org.Telephone{
+			{Number: "+33123456789"},
+		},
+		Ext: tax.Extensions{
+			"foo": "bar",
+		},
+	}
+	supplier := &org.Party{
+		Name: "Supplier",
+		TaxID: &tax.Identity{
+			Country: "DE",
+			Code:    "111111125",
+		},
+	}
+
+	t.Run("full party", func(t *testing.T) {
+		cus := goblstripe.ToCustomerParams(party, goblstripe.WithSupplier(supplier))
+
+		assert.Equal(t, "Test Company", stripe.StringValue(cus.Name))
+		assert.Equal(t, "billing@example.com", stripe.StringValue(cus.Email))
+		assert.Equal(t, "+33123456789", stripe.StringValue(cus.Phone))
+
+		assert.Equal(t, "Rue de Rivoli 1", stripe.StringValue(cus.Address.Line1))
+		assert.Equal(t, "Île-de-France", stripe.StringValue(cus.Address.State))
+
+		if assert.NotNil(t, cus.Shipping) {
+			assert.Equal(t, "Test Company", stripe.StringValue(cus.Shipping.Name))
+			assert.Equal(t, "Quai de la Gare 5", stripe.StringValue(cus.Shipping.Address.Line1))
+			assert.Equal(t, "+33123456789", stripe.StringValue(cus.Shipping.Phone))
+		}
+
+		if assert.Len(t, cus.TaxIDData, 2) {
+			assert.Equal(t, "eu_vat", stripe.StringValue(cus.TaxIDData[0].Type))
+			assert.Equal(t, "FR44732829320", stripe.StringValue(cus.TaxIDData[0].Value))
+			assert.Equal(t, "de_stn", stripe.StringValue(cus.TaxIDData[1].Type))
+			assert.Equal(t, "123456789", stripe.StringValue(cus.TaxIDData[1].Value))
+		}
+
+		assert.Equal(t, map[string]string{"gobl-customer-foo": "bar"}, cus.Metadata)
+		assert.Equal(t, "reverse", stripe.StringValue(cus.TaxExempt))
+	})
+
+	t.Run("same country supplier", func(t *testing.T) {
+		cus := goblstripe.ToCustomerParams(party, goblstripe.WithSupplier(&org.Party{
+			TaxID: &tax.Identity{Country: "FR", Code: "39356000000"},
+		}))
+		assert.Nil(t, cus.TaxExempt)
+	})
+
+	t.Run("without supplier", func(t *testing.T) {
+		cus := goblstripe.ToCustomerParams(party)
+		assert.Nil(t, cus.TaxExempt)
+	})
+
+	t.Run("non EU customer", func(t *testing.T) {
+		cus := goblstripe.ToCustomerParams(&org.Party{
+			Name:  "US Company",
+			TaxID: &tax.Identity{Country: "US", Code: "123456789"},
+		}, goblstripe.WithSupplier(supplier))
+		assert.Nil(t, cus.TaxExempt)
+		assert.Nil(t, cus.Shipping)
+		assert.Nil(t, cus.Address)
+	})
+
+	t.Run("nil party", func(t *testing.T) {
+		assert.Nil(t, goblstripe.ToCustomerParams(nil))
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		cus := goblstripe.ToCustomerParams(party)
+		sc := &stripe.Customer{
+			Name:     stripe.StringValue(cus.Name),
+			Metadata: cus.Metadata,
+			TaxIDs:   &stripe.TaxIDList{},
+		}
+		for _, tID := range cus.TaxIDData {
+			sc.TaxIDs.Data = append(sc.TaxIDs.Data, &stripe.TaxID{
+				Type:  stripe.TaxIDType(stripe.StringValue(tID.Type)),
+				Value: stripe.StringValue(tID.Value),
+			})
+		}
+
+		result := goblstripe.FromCustomer(sc)
+		assert.Equal(t, party.Ext, result.Ext)
+		assert.Equal(t, party.TaxID.Code, result.TaxID.Code)
+		if assert.Len(t, result.Identities, 1) {
+			assert.Equal(t, party.Identities[0].Code, result.Identities[0].Code)
+		}
+	})
+}
+
+func TestToCustomerShippingParams(t *testing.T) {
+	receiver := &org.Party{
+		Name: "Receiver",
+		Addresses: []*org.Address{
+			{Street: "Main St 1", Locality: "Madrid", Code: "28001", Country: "ES"},
+		},
+		Telephones: []*org.Telephone{
+			{Number: "+34911111111"},
+		},
+	}
+	shipping := goblstripe.ToCustomerShippingParams(receiver)
+	assert.Equal(t, "Receiver", stripe.StringValue(shipping.Name))
+	assert.Equal(t, "Main St 1", stripe.StringValue(shipping.Address.Line1))
+	assert.Equal(t, "+34911111111", stripe.StringValue(shipping.Phone))
+
+	assert.Nil(t, goblstripe.ToCustomerShippingParams(&org.Party{Name: "No address"}))
+	assert.Nil(t, goblstripe.ToCustomerShippingParams(nil))
+}
+
 func TestFromAddress(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,76 @@
+package goblstripe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// proformaCodePrefix is prepended to the provisional codes of proforma invoices, so they
+// can't be mistaken for the codes of finalized invoices.
+const proformaCodePrefix = "PRO-"
+
+// upcomingInvoiceIDPrefix is the prefix of the IDs of upcoming invoice previews, which
+// change between requests.
+const upcomingInvoiceIDPrefix = "upcoming_"
+
+// FromUpcomingInvoice converts a draft Stripe invoice or an upcoming invoice preview into
+// a GOBL proforma invoice. As these invoices have no number yet, the proforma gets a
+// provisional code that stays the same across conversions: the draft invoice ID or, for
+// upcoming invoices, the subscription (or customer) and the start of the period being
+// invoiced, which for subscriptions is their next period. Payment terms and advances
+// are only known once the invoice is finalized, so just the payment instructions are
+// kept.
+func FromUpcomingInvoice(doc *stripe.Invoice, account *stripe.Account) (*bill.Invoice, error) {
+	if doc == nil {
+		return nil, fmt.Errorf("missing invoice")
+	}
+	if doc.Status != "" && doc.Status != stripe.InvoiceStatusDraft {
+		return nil, fmt.Errorf("invoice %s is %s, use FromInvoice instead", doc.ID, doc.Status)
+	}
+
+	inv, err := FromInvoice(doc, account)
+	if err != nil {
+		return inv, err
+	}
+
+	inv.Type = bill.InvoiceTypeProforma
+	inv.Series = ""
+	inv.Code = newProformaCode(doc, inv)
+	inv.OperationDate = nil
+
+	inv.Payment = nil
+	if instructions := newPaymentInstructions(doc); instructions != nil {
+		inv.Payment = &bill.PaymentDetails{
+			Instructions: instructions,
+		}
+	}
+
+	return inv, nil
+}
+
+// newProformaCode returns the provisional code of a proforma invoice.
+func newProformaCode(doc *stripe.Invoice, inv *bill.Invoice) cbc.Code {
+	if doc.ID != "" && !strings.HasPrefix(doc.ID, upcomingInvoiceIDPrefix) {
+		return cbc.Code(proformaCodePrefix + doc.ID)
+	}
+
+	var owner string
+	switch {
+	case doc.Subscription != nil && doc.Subscription.ID != "":
+		owner = doc.Subscription.ID
+	case doc.Customer != nil && doc.Customer.ID != "":
+		owner = doc.Customer.ID
+	default:
+		owner = "upcoming"
+	}
+
+	code := proformaCodePrefix + owner
+	if inv.Ordering != nil && inv.Ordering.Period != nil {
+		code += "-" + inv.Ordering.Period.Start.String()
+	}
+	return cbc.Code(code)
+}
@@ -0,0 +1,71 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func upcomingStripeInvoice() *stripe.Invoice {
+	doc := minimalStripeInvoice()
+	doc.ID = ""
+	doc.Status = stripe.InvoiceStatusDraft
+	doc.EffectiveAt = 0
+	doc.AmountPaid = 0
+	doc.AmountDue = 2000
+	doc.DueDate = 1738411200
+	doc.Customer = &stripe.Customer{ID: "cus_RY7TdAXokervKd"}
+	doc.Subscription = &stripe.Subscription{ID: "sub_1QkqKWQhcl5B85YlLkS7a1bQ"}
+	doc.Lines.Data[0].Period = &stripe.Period{
+		Start: 1738411200, // 2025-02-01 12:00 UTC
+		End:   1740830400, // 2025-03-01 12:00 UTC
+	}
+	return doc
+}
+
+func TestFromUpcomingInvoice(t *testing.T) {
+	t.Run("upcoming invoice", func(t *testing.T) {
+		inv, err := goblstripe.FromUpcomingInvoice(upcomingStripeInvoice(), validStripeAccount())
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, bill.InvoiceTypeProforma, inv.Type)
+		assert.Empty(t, inv.Series)
+		assert.Equal(t, "PRO-sub_1QkqKWQhcl5B85YlLkS7a1bQ-2025-02-01", inv.Code.String())
+		assert.Nil(t, inv.OperationDate)
+		assert.Equal(t, cal.MakeDate(2025, 2, 1), inv.Ordering.Period.Start)
+		assert.Equal(t, cal.MakeDate(2025, 3, 1), inv.Ordering.Period.End)
+		assert.Nil(t, inv.Payment)
+		assert.Equal(t, "20.00", inv.Totals.Payable.String())
+
+		again, err := goblstripe.FromUpcomingInvoice(upcomingStripeInvoice(), validStripeAccount())
+		require.NoError(t, err)
+		assert.Equal(t, inv.Code, again.Code)
+	})
+
+	t.Run("draft invoice", func(t *testing.T) {
+		doc := upcomingStripeInvoice()
+		doc.ID = "in_1QkqKVQhcl5B85YlT32LIsNm"
+		doc.DefaultPaymentMethod = &stripe.PaymentMethod{Type: stripe.PaymentMethodTypeSEPADebit}
+		inv, err := goblstripe.FromUpcomingInvoice(doc, validStripeAccount())
+		require.NoError(t, err)
+		assert.Equal(t, "PRO-in_1QkqKVQhcl5B85YlT32LIsNm", inv.Code.String())
+		require.NotNil(t, inv.Payment)
+		assert.Nil(t, inv.Payment.Terms)
+		assert.Equal(t, "SEPA Direct Debit", inv.Payment.Instructions.Detail)
+	})
+
+	t.Run("finalized invoice", func(t *testing.T) {
+		doc := upcomingStripeInvoice()
+		doc.ID = "in_1QkqKVQhcl5B85YlT32LIsNm"
+		doc.Status = stripe.InvoiceStatusOpen
+		_, err := goblstripe.FromUpcomingInvoice(doc, validStripeAccount())
+		assert.ErrorContains(t, err, "use FromInvoice instead")
+	})
+}
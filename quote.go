@@ -0,0 +1,177 @@
+package goblstripe
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// FromQuote converts a Stripe quote into a GOBL order of type quote. The quote should
+// be retrieved with the customer and the line items expanded, including their taxes,
+// discounts and products. Quote line items are converted in the same way as invoice
+// lines. Quotes don't carry the account country, so the regime is taken from the
+// account, which is required.
+func FromQuote(q *stripe.Quote, account *stripe.Account) (*bill.Order, error) {
+	if q == nil {
+		return nil, fmt.Errorf("missing quote")
+	}
+
+	regimeDef, err := regimeFromAccount(account)
+	if err != nil {
+		return nil, err
+	}
+
+	ord := new(bill.Order)
+	ord.Type = bill.OrderTypeQuote
+	ord.UUID = uuidFromMetadata(q.Metadata, uuid.V7) // Generated randomly when the quote was not created from GOBL.
+	ord.Code = newQuoteCode(q)
+
+	ord.Meta = cbc.Meta{
+		MetaKeyStripeDocID:   q.ID,
+		MetaKeyStripeDocType: StripeDocTypeQuote,
+	}
+
+	ord.IssueDate = *newDateFromTS(quoteIssuedAt(q), regimeDef.TimeLocation())
+	if q.ExpiresAt != 0 {
+		// The quote is valid from the issue date until it expires
+		ord.Period = &cal.Period{
+			Start: ord.IssueDate,
+			End:   *newDateFromTS(q.ExpiresAt, regimeDef.TimeLocation()),
+		}
+	}
+
+	ord.Currency = FromCurrency(q.Currency)
+	ord.ExchangeRates = newExchangeRates(ord.Currency, regimeDef)
+
+	ord.Supplier = NewSupplierFromAccount(account)
+	if q.Customer != nil && q.Customer.Created != 0 {
+		ord.Customer = FromCustomer(q.Customer)
+	}
+
+	lines := quoteInvoiceLines(q)
+	ord.Tags = newTags(isQuoteReverseCharge(q, lines), ord.Customer)
+	ord.Lines = FromInvoiceLines(lines, regimeDef)
+	ord.Tax = taxFromInvoiceTaxAmounts(lineTaxAmounts(lines), lines)
+	ord.Notes = newQuoteNotes(q)
+
+	return ord, nil
+}
+
+// newQuoteCode returns the code of the GOBL order of a quote: the quote number or, for
+// draft quotes, the quote ID.
+func newQuoteCode(q *stripe.Quote) cbc.Code {
+	if q.Number != "" {
+		return cbc.Code(q.Number)
+	}
+	return cbc.Code(q.ID)
+}
+
+// quoteIssuedAt returns the timestamp of the issue date of a quote: when it was
+// finalized or, for draft quotes, when it was created.
+func quoteIssuedAt(q *stripe.Quote) int64 {
+	if q.StatusTransitions != nil && q.StatusTransitions.FinalizedAt != 0 {
+		return q.StatusTransitions.FinalizedAt
+	}
+	return q.Created
+}
+
+// newPrecedingFromQuote creates a reference to the GOBL order of a quote (see FromQuote),
+// with the same code and issue date. The quote must be expanded in the invoice: an
+// unexpanded quote only has its ID, which is used as the code instead of the quote
+// number, and no issue date.
+func newPrecedingFromQuote(q *stripe.Quote, regimeDef *tax.RegimeDef) *org.DocumentRef {
+	docRef := &org.DocumentRef{
+		Type: bill.OrderTypeQuote,
+		Code: newQuoteCode(q),
+	}
+	if issued := quoteIssuedAt(q); issued != 0 {
+		docRef.IssueDate = newDateFromTS(issued, regimeDef.TimeLocation())
+	}
+	return docRef
+}
+
+// quoteInvoiceLines converts the line items of a quote into invoice line items, so they
+// can be converted with the invoice line logic.
+func quoteInvoiceLines(q *stripe.Quote) []*stripe.InvoiceLineItem {
+	if q.LineItems == nil {
+		return nil
+	}
+	lines := make([]*stripe.InvoiceLineItem, 0, len(q.LineItems.Data))
+	for _, li := range q.LineItems.Data {
+		lines = append(lines, newInvoiceLineFromLineItem(li))
+	}
+	return lines
+}
+
+// newInvoiceLineFromLineItem converts a Stripe line item, as used in quotes and checkout
+// sessions, into an invoice line item. The subtotal of a line item, like the amount of an
+// invoice line, is the amount before discounts and exclusive taxes.
+func newInvoiceLineFromLineItem(li *stripe.LineItem) *stripe.InvoiceLineItem {
+	line := &stripe.InvoiceLineItem{
+		ID:           li.ID,
+		Amount:       li.AmountSubtotal,
+		Currency:     li.Currency,
+		Description:  li.Description,
+		Discountable: len(li.Discounts) > 0,
+		Price:        li.Price,
+		Quantity:     li.Quantity,
+	}
+	for _, d := range li.Discounts {
+		line.DiscountAmounts = append(line.DiscountAmounts, &stripe.InvoiceLineItemDiscountAmount{
+			Amount:   d.Amount,
+			Discount: d.Discount,
+		})
+	}
+	for _, t := range li.Taxes {
+		taxAmount := &stripe.InvoiceTotalTaxAmount{
+			Amount:           t.Amount,
+			TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReason(t.TaxabilityReason),
+			TaxableAmount:    t.TaxableAmount,
+			TaxRate:          t.Rate,
+		}
+		if t.Rate != nil {
+			taxAmount.Inclusive = t.Rate.Inclusive
+		}
+		line.TaxAmounts = append(line.TaxAmounts, taxAmount)
+	}
+	return line
+}
+
+// lineTaxAmounts collects the tax amounts of a list of invoice line items.
+func lineTaxAmounts(lines []*stripe.InvoiceLineItem) []*stripe.InvoiceTotalTaxAmount {
+	var taxAmounts []*stripe.InvoiceTotalTaxAmount
+	for _, line := range lines {
+		taxAmounts = append(taxAmounts, line.TaxAmounts...)
+	}
+	return taxAmounts
+}
+
+// isQuoteReverseCharge checks if the quote has reverse charge applied.
+func isQuoteReverseCharge(q *stripe.Quote, lines []*stripe.InvoiceLineItem) bool {
+	if q.Customer != nil && q.Customer.TaxExempt == stripe.CustomerTaxExemptReverse {
+		return true
+	}
+
+	for _, taxAmount := range lineTaxAmounts(lines) {
+		if taxAmount.TaxabilityReason == stripe.InvoiceTotalTaxAmountTaxabilityReasonReverseCharge {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newQuoteNotes creates notes from a Stripe quote's header, description and footer.
+func newQuoteNotes(q *stripe.Quote) []*org.Note {
+	var notes []*org.Note
+	if n := newNote(q.Header, org.NoteKeyGeneral); n != nil {
+		notes = append(notes, n)
+	}
+	return append(notes, newInvoiceNotes(q.Description, q.Footer)...)
+}
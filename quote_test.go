@@ -0,0 +1,165 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func validStripeQuote() *stripe.Quote {
+	return &stripe.Quote{
+		ID:          "qt_1QkqKWQhcl5B85YlXqJmF2sd",
+		Object:      "quote",
+		Number:      "QT-255RTCB4-0001",
+		Created:     1737738363, // 2025-01-24
+		ExpiresAt:   1740330363, // 2025-02-23
+		Currency:    stripe.CurrencyEUR,
+		Customer:    validStripeCustomer(),
+		Status:      stripe.QuoteStatusOpen,
+		Header:      "Website redesign",
+		Description: "Quote valid for 30 days",
+		AmountTotal: 23800,
+		LineItems: &stripe.LineItemList{
+			Data: []*stripe.LineItem{
+				{
+					ID:             "li_1QkqKWQhcl5B85YlNsP3u8dT",
+					Description:    "Design hours",
+					AmountSubtotal: 25000,
+					AmountDiscount: 5000,
+					AmountTax:      3800,
+					AmountTotal:    23800,
+					Currency:       stripe.CurrencyEUR,
+					Quantity:       10,
+					Price: &stripe.Price{
+						BillingScheme: stripe.PriceBillingSchemePerUnit,
+						Currency:      stripe.CurrencyEUR,
+						UnitAmount:    2500,
+					},
+					Discounts: []*stripe.LineItemDiscount{
+						{
+							Amount: 5000,
+							Discount: &stripe.Discount{
+								Coupon: &stripe.Coupon{Name: "Launch offer", PercentOff: 20},
+							},
+						},
+					},
+					Taxes: []*stripe.LineItemTax{
+						{
+							Amount: 3800,
+							Rate: &stripe.TaxRate{
+								Created:             1736351225,
+								TaxType:             stripe.TaxRateTaxTypeVAT,
+								Country:             "DE",
+								EffectivePercentage: 19.0,
+								Percentage:          19.0,
+							},
+							TaxabilityReason: stripe.LineItemTaxTaxabilityReasonStandardRated,
+							TaxableAmount:    20000,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func validQuoteAccount() *stripe.Account {
+	account := validStripeAccount()
+	account.Country = "DE"
+	return account
+}
+
+func TestFromQuote(t *testing.T) {
+	ord, err := goblstripe.FromQuote(validStripeQuote(), validQuoteAccount())
+	require.NoError(t, err)
+	require.NoError(t, ord.Calculate())
+	require.NoError(t, ord.Validate())
+
+	assert.Equal(t, bill.OrderTypeQuote, ord.Type)
+	assert.Equal(t, "QT-255RTCB4-0001", ord.Code.String())
+	assert.Equal(t, "quote", ord.Meta[goblstripe.MetaKeyStripeDocType])
+	assert.Equal(t, cal.MakeDate(2025, 1, 24), ord.IssueDate)
+	require.NotNil(t, ord.Period)
+	assert.Equal(t, cal.MakeDate(2025, 1, 24), ord.Period.Start)
+	assert.Equal(t, cal.MakeDate(2025, 2, 23), ord.Period.End)
+
+	assert.Equal(t, "Test Account", ord.Supplier.Name)
+	assert.Equal(t, "Test Customer", ord.Customer.Name)
+
+	require.Len(t, ord.Lines, 1)
+	line := ord.Lines[0]
+	assert.Equal(t, "Design hours", line.Item.Name)
+	assert.Equal(t, "10", line.Quantity.String())
+	assert.Equal(t, "25.00", line.Item.Price.String())
+	require.Len(t, line.Discounts, 1)
+	assert.Equal(t, "Launch offer", line.Discounts[0].Reason)
+	assert.Equal(t, tax.CategoryVAT, line.Taxes[0].Category)
+	assert.Equal(t, tax.RateGeneral, line.Taxes[0].Rate)
+
+	assert.Equal(t, "238.00", ord.Totals.Payable.String())
+	require.Len(t, ord.Notes, 2)
+	assert.Equal(t, "Website redesign", ord.Notes[0].Text)
+
+	t.Run("draft without expiry", func(t *testing.T) {
+		q := validStripeQuote()
+		q.Number = ""
+		q.ExpiresAt = 0
+		q.Status = stripe.QuoteStatusDraft
+		ord, err := goblstripe.FromQuote(q, validQuoteAccount())
+		require.NoError(t, err)
+		assert.Equal(t, "qt_1QkqKWQhcl5B85YlXqJmF2sd", ord.Code.String())
+		assert.Nil(t, ord.Period)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := goblstripe.FromQuote(nil, validQuoteAccount())
+		assert.ErrorContains(t, err, "missing quote")
+
+		_, err = goblstripe.FromQuote(validStripeQuote(), nil)
+		assert.ErrorContains(t, err, "missing account country")
+	})
+}
+
+func TestFromInvoiceWithQuote(t *testing.T) {
+	t.Run("expanded quote", func(t *testing.T) {
+		doc := minimalStripeInvoice()
+		doc.Quote = validStripeQuote()
+		doc.Quote.StatusTransitions = &stripe.QuoteStatusTransitions{
+			FinalizedAt: 1737824763, // 2025-01-25
+		}
+
+		inv, err := goblstripe.FromInvoice(doc, validStripeAccount())
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		require.Len(t, inv.Ordering.Sales, 1)
+		ref := inv.Ordering.Sales[0]
+		assert.Equal(t, bill.OrderTypeQuote, ref.Type)
+		assert.Equal(t, "QT-255RTCB4-0001", ref.Code.String())
+		// Same issue date as the order converted with FromQuote
+		ord, err := goblstripe.FromQuote(doc.Quote, validQuoteAccount())
+		require.NoError(t, err)
+		require.NotNil(t, ref.IssueDate)
+		assert.Equal(t, ord.IssueDate, *ref.IssueDate)
+		assert.Equal(t, cal.MakeDate(2025, 1, 25), *ref.IssueDate)
+	})
+
+	t.Run("unexpanded quote", func(t *testing.T) {
+		doc := minimalStripeInvoice()
+		doc.Quote = &stripe.Quote{ID: "qt_1QkqKWQhcl5B85YlXqJmF2sd"}
+
+		inv, err := goblstripe.FromInvoice(doc, validStripeAccount())
+		require.NoError(t, err)
+
+		require.Len(t, inv.Ordering.Sales, 1)
+		assert.Equal(t, "qt_1QkqKWQhcl5B85YlXqJmF2sd", inv.Ordering.Sales[0].Code.String())
+		assert.Nil(t, inv.Ordering.Sales[0].IssueDate)
+	})
+}
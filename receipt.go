@@ -0,0 +1,218 @@
+package goblstripe
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// FromCharge converts a settled Stripe charge into a GOBL payment receipt. The paid
+// invoice, when the charge has one, is referenced in the payment line, so the charge
+// should be retrieved with the invoice (and the customer) expanded. The regime is taken
+// from the account country of the invoice or, for charges without one, of the account.
+func FromCharge(charge *stripe.Charge, account *stripe.Account) (*bill.Payment, error) {
+	if charge == nil {
+		return nil, fmt.Errorf("missing charge")
+	}
+	if !charge.Paid || charge.Status != stripe.ChargeStatusSucceeded {
+		return nil, fmt.Errorf("charge %s is not settled", charge.ID)
+	}
+
+	regimeDef, err := regimeFromCharge(charge, account)
+	if err != nil {
+		return nil, err
+	}
+
+	pmt := new(bill.Payment)
+	pmt.Type = bill.PaymentTypeReceipt
+	pmt.UUID = uuidFromMetadata(charge.Metadata, uuid.V7) // Generated randomly when the charge was not created from GOBL.
+
+	pmt.Code = newChargeCode(charge)
+
+	pmt.Meta = cbc.Meta{
+		MetaKeyStripeDocID:   charge.ID,
+		MetaKeyStripeDocType: StripeDocTypeCharge,
+	}
+
+	pmt.IssueDate = *newDateFromTS(charge.Created, regimeDef.TimeLocation())
+	pmt.Currency = FromCurrency(charge.Currency)
+	pmt.ExchangeRates = newExchangeRates(pmt.Currency, regimeDef)
+	pmt.Method = newPaymentMethod(charge)
+
+	pmt.Supplier = NewSupplierFromAccount(account)
+	if pmt.Supplier == nil && charge.Invoice != nil {
+		pmt.Supplier = newSupplierFromInvoice(charge.Invoice)
+	}
+
+	pmt.Customer = newCustomerFromCharge(charge)
+	pmt.Lines = []*bill.PaymentLine{newPaymentLine(charge, regimeDef)}
+
+	return pmt, nil
+}
+
+// FromPaymentIntent converts a succeeded Stripe payment intent into a GOBL payment
+// receipt, built from its latest charge (see FromCharge). The payment intent should be
+// retrieved with the latest charge expanded, along with the invoice and the customer.
+func FromPaymentIntent(pi *stripe.PaymentIntent, account *stripe.Account) (*bill.Payment, error) {
+	if pi == nil {
+		return nil, fmt.Errorf("missing payment intent")
+	}
+	if pi.Status != stripe.PaymentIntentStatusSucceeded {
+		return nil, fmt.Errorf("payment intent %s has not succeeded", pi.ID)
+	}
+	if pi.LatestCharge == nil || pi.LatestCharge.Created == 0 {
+		return nil, fmt.Errorf("payment intent %s has no expanded latest charge", pi.ID)
+	}
+
+	// The invoice, the customer and the metadata are usually only set in the payment intent.
+	charge := *pi.LatestCharge
+	if charge.Invoice == nil || charge.Invoice.Created == 0 {
+		charge.Invoice = pi.Invoice
+	}
+	if charge.Customer == nil || charge.Customer.Created == 0 {
+		charge.Customer = pi.Customer
+	}
+	if _, ok := charge.Metadata[MetaKeyGOBLUUID]; !ok {
+		charge.Metadata = pi.Metadata
+	}
+
+	return FromCharge(&charge, account)
+}
+
+// newChargeCode returns the code of the receipt of a charge: the receipt number sent to
+// the customer or, when no receipt was sent, the charge ID.
+func newChargeCode(charge *stripe.Charge) cbc.Code {
+	if charge.ReceiptNumber != "" {
+		return cbc.Code(charge.ReceiptNumber)
+	}
+	return cbc.Code(charge.ID)
+}
+
+// regimeFromCharge returns the regime of the account that received a charge.
+func regimeFromCharge(charge *stripe.Charge, account *stripe.Account) (*tax.RegimeDef, error) {
+	if charge.Invoice != nil && charge.Invoice.AccountCountry != "" {
+		return regimeFromInvoice(charge.Invoice)
+	}
+	return regimeFromAccount(account)
+}
+
+// regimeFromAccount returns the regime of the country of a Stripe account.
+func regimeFromAccount(account *stripe.Account) (*tax.RegimeDef, error) {
+	if account == nil || account.Country == "" {
+		return nil, fmt.Errorf("missing account country")
+	}
+	regime := tax.WithRegime(l10n.TaxCountryCode(account.Country))
+	if regime.RegimeDef() == nil {
+		return nil, fmt.Errorf("missing regime definition for %s", account.Country)
+	}
+	return regime.RegimeDef(), nil
+}
+
+// newPaymentMethod creates the GOBL payment method of a charge, using the charge ID as
+// the payment reference.
+func newPaymentMethod(charge *stripe.Charge) *pay.Instructions {
+	method := &pay.Instructions{
+		Key: pay.MeansKeyAny,
+		Ref: cbc.Code(charge.ID),
+	}
+
+	details := charge.PaymentMethodDetails
+	if details == nil {
+		return method
+	}
+	for _, def := range paymentMethodDefinitions {
+		if string(details.Type) == def.Key {
+			method.Key = def.MeansKey
+			method.Detail = def.Description
+			break
+		}
+	}
+
+	switch {
+	case details.Card != nil:
+		method.Card = &pay.Card{
+			Last4: details.Card.Last4,
+		}
+		if charge.BillingDetails != nil {
+			method.Card.Holder = charge.BillingDetails.Name
+		}
+	case details.SEPADebit != nil && details.SEPADebit.Mandate != "":
+		method.DirectDebit = &pay.DirectDebit{
+			Ref: details.SEPADebit.Mandate,
+		}
+	}
+
+	return method
+}
+
+// newCustomerFromCharge creates the GOBL customer of a charge from the expanded customer,
+// the paid invoice or, as a last resort, the billing details of the charge.
+func newCustomerFromCharge(charge *stripe.Charge) *org.Party {
+	if charge.Customer != nil && charge.Customer.Created != 0 {
+		return FromCustomer(charge.Customer)
+	}
+	if charge.Invoice != nil {
+		if charge.Invoice.Customer != nil && charge.Invoice.Customer.Created != 0 {
+			return FromCustomer(charge.Invoice.Customer)
+		}
+		if party := newCustomerFromInvoice(charge.Invoice); party != nil {
+			return party
+		}
+	}
+
+	bd := charge.BillingDetails
+	if bd == nil || (bd.Name == "" && bd.Email == "") {
+		return nil
+	}
+	party := &org.Party{
+		Name: bd.Name,
+	}
+	if bd.Email != "" {
+		party.Emails = append(party.Emails, FromEmail(bd.Email))
+	}
+	if bd.Phone != "" {
+		party.Telephones = append(party.Telephones, FromTelephone(bd.Phone))
+	}
+	if bd.Address != nil && bd.Address.Country != "" {
+		party.Addresses = append(party.Addresses, FromAddress(bd.Address))
+	}
+	return party
+}
+
+// newPaymentLine creates the payment line of a charge, referencing the paid invoice
+// when there is one.
+func newPaymentLine(charge *stripe.Charge, regimeDef *tax.RegimeDef) *bill.PaymentLine {
+	curr := FromCurrency(charge.Currency)
+	amount := charge.AmountCaptured
+	if amount == 0 {
+		amount = charge.Amount
+	}
+	line := &bill.PaymentLine{
+		Amount: CurrencyAmount(amount, curr),
+	}
+
+	doc := charge.Invoice
+	if doc == nil || doc.Created == 0 {
+		line.Description = charge.Description
+		return line
+	}
+
+	line.Document = newPrecedingFromInvoice(doc, "", regimeDef)
+	if doc.Total > 0 {
+		payable := CurrencyAmount(doc.Total, curr)
+		line.Payable = &payable
+	}
+	if previous := doc.AmountPaid - amount; previous > 0 && line.Payable != nil {
+		// Amounts paid before this charge, for invoices paid in several installments.
+		advances := CurrencyAmount(previous, curr)
+		line.Advances = &advances
+	}
+	return line
+}
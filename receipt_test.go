@@ -0,0 +1,160 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/pay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func validStripeCharge() *stripe.Charge {
+	doc := minimalStripeInvoice()
+	doc.Number = "SAMPLE-0001"
+	doc.CustomerName = "Test Customer"
+	doc.CustomerEmail = "customer@example.com"
+	return &stripe.Charge{
+		ID:             "ch_3QkqKWQhcl5B85Yl0JRXqT0e",
+		Object:         "charge",
+		Amount:         2000,
+		AmountCaptured: 2000,
+		Created:        1737738400, // 2025-01-24
+		Currency:       stripe.CurrencyEUR,
+		Invoice:        doc,
+		Paid:           true,
+		Status:         stripe.ChargeStatusSucceeded,
+		BillingDetails: &stripe.ChargeBillingDetails{Name: "Jane Doe"},
+		PaymentMethodDetails: &stripe.ChargePaymentMethodDetails{
+			Type: stripe.ChargePaymentMethodDetailsTypeCard,
+			Card: &stripe.ChargePaymentMethodDetailsCard{Last4: "4242"},
+		},
+	}
+}
+
+func TestFromCharge(t *testing.T) {
+	t.Run("invoice payment", func(t *testing.T) {
+		pmt, err := goblstripe.FromCharge(validStripeCharge(), validStripeAccount())
+		require.NoError(t, err)
+		require.NoError(t, pmt.Calculate())
+		require.NoError(t, pmt.Validate())
+
+		assert.Equal(t, bill.PaymentTypeReceipt, pmt.Type)
+		assert.Equal(t, "ch_3QkqKWQhcl5B85Yl0JRXqT0e", pmt.Code.String())
+		assert.Equal(t, cal.MakeDate(2025, 1, 24), pmt.IssueDate)
+		assert.Equal(t, "EUR", pmt.Currency.String())
+		assert.Equal(t, "charge", pmt.Meta[goblstripe.MetaKeyStripeDocType])
+
+		assert.Equal(t, pay.MeansKeyCard, pmt.Method.Key)
+		assert.Equal(t, "Card", pmt.Method.Detail)
+		assert.Equal(t, "ch_3QkqKWQhcl5B85Yl0JRXqT0e", pmt.Method.Ref.String())
+		assert.Equal(t, "4242", pmt.Method.Card.Last4)
+		assert.Equal(t, "Jane Doe", pmt.Method.Card.Holder)
+
+		assert.Equal(t, "Test Account", pmt.Supplier.Name)
+		assert.Equal(t, "Test Customer", pmt.Customer.Name)
+
+		require.Len(t, pmt.Lines, 1)
+		line := pmt.Lines[0]
+		require.NotNil(t, line.Document)
+		assert.Equal(t, "SAMPLE", line.Document.Series.String())
+		assert.Equal(t, "0001", line.Document.Code.String())
+		assert.Equal(t, bill.InvoiceTypeStandard, line.Document.Type)
+		assert.Equal(t, "20.00", line.Payable.String())
+		assert.Nil(t, line.Advances)
+		assert.Equal(t, "20.00", line.Amount.String())
+		assert.Equal(t, "20.00", pmt.Total.String())
+	})
+
+	t.Run("installment", func(t *testing.T) {
+		charge := validStripeCharge()
+		charge.Invoice.Total = 5000
+		charge.Invoice.AmountPaid = 5000
+		charge.ReceiptNumber = "2431-1234"
+		pmt, err := goblstripe.FromCharge(charge, validStripeAccount())
+		require.NoError(t, err)
+		require.NoError(t, pmt.Calculate())
+
+		assert.Equal(t, "2431-1234", pmt.Code.String())
+		assert.Equal(t, "50.00", pmt.Lines[0].Payable.String())
+		assert.Equal(t, "30.00", pmt.Lines[0].Advances.String())
+		assert.Equal(t, "0.00", pmt.Lines[0].Due.String())
+	})
+
+	t.Run("without invoice", func(t *testing.T) {
+		charge := validStripeCharge()
+		charge.Invoice = nil
+		charge.Description = "Consulting session"
+		charge.BillingDetails.Email = "jane@example.com"
+		charge.PaymentMethodDetails = &stripe.ChargePaymentMethodDetails{
+			Type:      stripe.ChargePaymentMethodDetailsTypeSEPADebit,
+			SEPADebit: &stripe.ChargePaymentMethodDetailsSEPADebit{Mandate: "mandate_123"},
+		}
+		account := validStripeAccount()
+		account.Country = "DE"
+
+		pmt, err := goblstripe.FromCharge(charge, account)
+		require.NoError(t, err)
+		require.NoError(t, pmt.Calculate())
+
+		assert.Equal(t, pay.MeansKeyDirectDebit, pmt.Method.Key)
+		assert.Equal(t, "mandate_123", pmt.Method.DirectDebit.Ref)
+		assert.Equal(t, "Jane Doe", pmt.Customer.Name)
+		assert.Nil(t, pmt.Lines[0].Document)
+		assert.Equal(t, "Consulting session", pmt.Lines[0].Description)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := goblstripe.FromCharge(nil, nil)
+		assert.ErrorContains(t, err, "missing charge")
+
+		charge := validStripeCharge()
+		charge.Status = stripe.ChargeStatusPending
+		_, err = goblstripe.FromCharge(charge, nil)
+		assert.ErrorContains(t, err, "not settled")
+
+		charge = validStripeCharge()
+		charge.Invoice = nil
+		_, err = goblstripe.FromCharge(charge, nil)
+		assert.ErrorContains(t, err, "missing account country")
+	})
+}
+
+func TestFromPaymentIntent(t *testing.T) {
+	charge := validStripeCharge()
+	doc := charge.Invoice
+	charge.Invoice = &stripe.Invoice{ID: doc.ID}
+
+	pi := &stripe.PaymentIntent{
+		ID:           "pi_3QkqKWQhcl5B85Yl0bXGZ5nD",
+		Status:       stripe.PaymentIntentStatusSucceeded,
+		Invoice:      doc,
+		LatestCharge: charge,
+		Metadata:     map[string]string{goblstripe.MetaKeyGOBLUUID: "0195e4a1-8c1b-7f4a-9b3e-2d6f5a4c3b21"},
+	}
+
+	pmt, err := goblstripe.FromPaymentIntent(pi, validStripeAccount())
+	require.NoError(t, err)
+	assert.Equal(t, "0195e4a1-8c1b-7f4a-9b3e-2d6f5a4c3b21", pmt.UUID.String())
+	assert.Equal(t, "0001", pmt.Lines[0].Document.Code.String())
+	assert.Equal(t, "ch_3QkqKWQhcl5B85Yl0JRXqT0e", pmt.Method.Ref.String())
+	assert.Equal(t, doc.ID, charge.Invoice.ID, "the charge is not modified")
+	assert.Zero(t, charge.Invoice.Created)
+
+	t.Run("not succeeded", func(t *testing.T) {
+		_, err := goblstripe.FromPaymentIntent(&stripe.PaymentIntent{ID: "pi_1", Status: stripe.PaymentIntentStatusProcessing}, nil)
+		assert.ErrorContains(t, err, "has not succeeded")
+	})
+
+	t.Run("charge not expanded", func(t *testing.T) {
+		_, err := goblstripe.FromPaymentIntent(&stripe.PaymentIntent{
+			ID:           "pi_1",
+			Status:       stripe.PaymentIntentStatusSucceeded,
+			LatestCharge: &stripe.Charge{ID: "ch_1"},
+		}, nil)
+		assert.ErrorContains(t, err, "no expanded latest charge")
+	})
+}
@@ -0,0 +1,139 @@
+package goblstripe
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// RefundOption is a functional option for FromRefund.
+type RefundOption func(*refundOptions)
+
+type refundOptions struct {
+	creditNote *stripe.CreditNote
+}
+
+// WithCreditNote provides the Stripe credit note issued along with the refund, which
+// Stripe doesn't reference from the refund itself.
+func WithCreditNote(cn *stripe.CreditNote) RefundOption {
+	return func(o *refundOptions) {
+		o.creditNote = cn
+	}
+}
+
+// FromRefund converts a succeeded Stripe refund into a GOBL payment. GOBL represents
+// refunds as receipts whose lines are flagged as refunds, so the payment total is
+// negative. The refund should be retrieved with the charge expanded, along with its
+// invoice and customer: the receipt of the charge is added as the preceding document
+// and the refunded invoice is referenced in the payment line.
+func FromRefund(refund *stripe.Refund, account *stripe.Account, opts ...RefundOption) (*bill.Payment, error) {
+	var options refundOptions
+	for _, o := range opts {
+		if o != nil {
+			o(&options)
+		}
+	}
+	if refund == nil {
+		return nil, fmt.Errorf("missing refund")
+	}
+	if refund.Status != stripe.RefundStatusSucceeded {
+		return nil, fmt.Errorf("refund %s has not succeeded", refund.ID)
+	}
+	charge := refund.Charge
+	if charge == nil || charge.Created == 0 {
+		return nil, fmt.Errorf("refund %s has no expanded charge", refund.ID)
+	}
+
+	regimeDef, err := regimeFromCharge(charge, account)
+	if err != nil {
+		return nil, err
+	}
+
+	pmt := new(bill.Payment)
+	pmt.Type = bill.PaymentTypeReceipt
+	pmt.UUID = uuidFromMetadata(refund.Metadata, uuid.V7) // Generated randomly when the refund was not created from GOBL.
+
+	if refund.ReceiptNumber != "" {
+		pmt.Code = cbc.Code(refund.ReceiptNumber)
+	} else {
+		pmt.Code = cbc.Code(refund.ID)
+	}
+
+	pmt.Meta = cbc.Meta{
+		MetaKeyStripeDocID:   refund.ID,
+		MetaKeyStripeDocType: StripeDocTypeRefund,
+	}
+
+	pmt.IssueDate = *newDateFromTS(refund.Created, regimeDef.TimeLocation())
+	pmt.Currency = FromCurrency(refund.Currency)
+	pmt.ExchangeRates = newExchangeRates(pmt.Currency, regimeDef)
+
+	// Refunds are returned through the payment method of the charge.
+	pmt.Method = newPaymentMethod(charge)
+	pmt.Method.Ref = cbc.Code(refund.ID)
+
+	pmt.Supplier = NewSupplierFromAccount(account)
+	if pmt.Supplier == nil && charge.Invoice != nil {
+		pmt.Supplier = newSupplierFromInvoice(charge.Invoice)
+	}
+	pmt.Customer = newCustomerFromCharge(charge)
+
+	pmt.Preceding = []*org.DocumentRef{newPrecedingFromCharge(charge, regimeDef)}
+	if options.creditNote != nil {
+		pmt.Preceding = append(pmt.Preceding, newPrecedingFromCreditNote(options.creditNote, regimeDef))
+	}
+
+	pmt.Lines = []*bill.PaymentLine{newRefundLine(refund, regimeDef)}
+	if n := newNote(string(refund.Reason), org.NoteKeyReason); n != nil {
+		pmt.Notes = []*org.Note{n}
+	}
+
+	return pmt, nil
+}
+
+// newRefundLine creates the payment line of a refund, referencing the invoice of the
+// refunded charge when there is one.
+func newRefundLine(refund *stripe.Refund, regimeDef *tax.RegimeDef) *bill.PaymentLine {
+	line := &bill.PaymentLine{
+		Refund:      true,
+		Amount:      CurrencyAmount(refund.Amount, FromCurrency(refund.Currency)),
+		Description: refund.Description,
+	}
+	if doc := refund.Charge.Invoice; doc != nil && doc.Created != 0 {
+		line.Document = newPrecedingFromInvoice(doc, "", regimeDef)
+	}
+	return line
+}
+
+// newPrecedingFromCharge creates a reference to the GOBL receipt of a charge (see
+// FromCharge).
+func newPrecedingFromCharge(charge *stripe.Charge, regimeDef *tax.RegimeDef) *org.DocumentRef {
+	return &org.DocumentRef{
+		Type:      bill.PaymentTypeReceipt,
+		Code:      newChargeCode(charge),
+		IssueDate: newDateFromTS(charge.Created, regimeDef.TimeLocation()),
+	}
+}
+
+// newPrecedingFromCreditNote creates a reference to the GOBL credit note of a Stripe
+// credit note (see FromCreditNote).
+func newPrecedingFromCreditNote(cn *stripe.CreditNote, regimeDef *tax.RegimeDef) *org.DocumentRef {
+	docRef := &org.DocumentRef{
+		Type:   bill.InvoiceTypeCreditNote,
+		Reason: string(cn.Reason),
+	}
+	if cn.Number != "" {
+		docRef.Code = cbc.Code(cn.Number)
+	} else {
+		docRef.Code = cbc.Code(cn.ID)
+	}
+	if cn.Created != 0 {
+		docRef.IssueDate = newDateFromTS(cn.Created, regimeDef.TimeLocation())
+	}
+	return docRef
+}
@@ -0,0 +1,109 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/org"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func validStripeRefund() *stripe.Refund {
+	charge := validStripeCharge()
+	charge.ReceiptNumber = "2431-1234"
+	return &stripe.Refund{
+		ID:       "re_3QkqKWQhcl5B85Yl0Vq2mZ4c",
+		Object:   "refund",
+		Amount:   500,
+		Charge:   charge,
+		Created:  1738238400, // 2025-01-30
+		Currency: stripe.CurrencyEUR,
+		Reason:   stripe.RefundReasonRequestedByCustomer,
+		Status:   stripe.RefundStatusSucceeded,
+	}
+}
+
+func TestFromRefund(t *testing.T) {
+	t.Run("partial refund", func(t *testing.T) {
+		pmt, err := goblstripe.FromRefund(validStripeRefund(), validStripeAccount())
+		require.NoError(t, err)
+		require.NoError(t, pmt.Calculate())
+		require.NoError(t, pmt.Validate())
+
+		assert.Equal(t, bill.PaymentTypeReceipt, pmt.Type)
+		assert.Equal(t, "re_3QkqKWQhcl5B85Yl0Vq2mZ4c", pmt.Code.String())
+		assert.Equal(t, cal.MakeDate(2025, 1, 30), pmt.IssueDate)
+		assert.Equal(t, "refund", pmt.Meta[goblstripe.MetaKeyStripeDocType])
+		assert.Equal(t, "re_3QkqKWQhcl5B85Yl0Vq2mZ4c", pmt.Method.Ref.String())
+		assert.Equal(t, "4242", pmt.Method.Card.Last4)
+		assert.Equal(t, "Test Customer", pmt.Customer.Name)
+
+		require.Len(t, pmt.Preceding, 1)
+		assert.Equal(t, bill.PaymentTypeReceipt, pmt.Preceding[0].Type)
+		assert.Equal(t, "2431-1234", pmt.Preceding[0].Code.String())
+		assert.Equal(t, cal.MakeDate(2025, 1, 24), *pmt.Preceding[0].IssueDate)
+
+		require.Len(t, pmt.Lines, 1)
+		line := pmt.Lines[0]
+		assert.True(t, line.Refund)
+		assert.Equal(t, "5.00", line.Amount.String())
+		require.NotNil(t, line.Document)
+		assert.Equal(t, "SAMPLE", line.Document.Series.String())
+		assert.Equal(t, "0001", line.Document.Code.String())
+		assert.Equal(t, "-5.00", pmt.Total.String())
+
+		require.Len(t, pmt.Notes, 1)
+		assert.Equal(t, org.NoteKeyReason, pmt.Notes[0].Key)
+		assert.Equal(t, "requested_by_customer", pmt.Notes[0].Text)
+	})
+
+	t.Run("with credit note", func(t *testing.T) {
+		cn := &stripe.CreditNote{
+			ID:      "cn_1QkqKWQhcl5B85YlFJ6vQbD3",
+			Number:  "SAMPLE-0001-CN-01",
+			Created: 1738238400,
+			Reason:  stripe.CreditNoteReasonProductUnsatisfactory,
+		}
+		pmt, err := goblstripe.FromRefund(validStripeRefund(), validStripeAccount(), goblstripe.WithCreditNote(cn))
+		require.NoError(t, err)
+		require.Len(t, pmt.Preceding, 2)
+		ref := pmt.Preceding[1]
+		assert.Equal(t, bill.InvoiceTypeCreditNote, ref.Type)
+		assert.Equal(t, "SAMPLE-0001-CN-01", ref.Code.String())
+		assert.Equal(t, "product_unsatisfactory", ref.Reason)
+		assert.Equal(t, cal.MakeDate(2025, 1, 30), *ref.IssueDate)
+	})
+
+	t.Run("without invoice", func(t *testing.T) {
+		refund := validStripeRefund()
+		refund.Charge.Invoice = nil
+		refund.Reason = ""
+		refund.Description = "Cancelled booking"
+		account := validStripeAccount()
+		account.Country = "DE"
+		pmt, err := goblstripe.FromRefund(refund, account)
+		require.NoError(t, err)
+		assert.Nil(t, pmt.Lines[0].Document)
+		assert.Equal(t, "Cancelled booking", pmt.Lines[0].Description)
+		assert.Empty(t, pmt.Notes)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := goblstripe.FromRefund(nil, nil)
+		assert.ErrorContains(t, err, "missing refund")
+
+		refund := validStripeRefund()
+		refund.Status = stripe.RefundStatusPending
+		_, err = goblstripe.FromRefund(refund, nil)
+		assert.ErrorContains(t, err, "has not succeeded")
+
+		refund = validStripeRefund()
+		refund.Charge = &stripe.Charge{ID: "ch_1"}
+		_, err = goblstripe.FromRefund(refund, nil)
+		assert.ErrorContains(t, err, "no expanded charge")
+	})
+}
@@ -0,0 +1,216 @@
+package goblstripe_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl.stripe/fixture"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/org"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRoundTripExamples runs every GOBL example in the /out/ directory through
+// GOBL -> Stripe -> GOBL and checks that the totals, the tax breakdown, the parties,
+// the extension codes, the notes and the subscription and quote references survive. The GOBL
+// documents are rebuilt from their Stripe sources (TestConvertExamplesToJSON checks
+// they match the /out/ files), and the Stripe objects are built from the params with the
+// fixture package, which computes the amounts the same way Stripe does with manual tax
+// rates.
+func TestRoundTripExamples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("examples", "stripe.gobl", "out", "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, path := range files {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			want := loadExampleInvoice(t, path)
+			require.NoError(t, want.Calculate())
+
+			got, err := roundTripInvoice(want)
+			require.NoError(t, err)
+
+			if diffs := roundTripDiffs(want, got); len(diffs) > 0 {
+				t.Errorf("%s does not survive the round trip:\n  %s", path, strings.Join(diffs, "\n  "))
+			}
+		})
+	}
+}
+
+// loadExampleInvoice returns the GOBL invoice of an example in the /out/ directory,
+// converted from its Stripe source.
+func loadExampleInvoice(t *testing.T, path string) *bill.Invoice {
+	t.Helper()
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, "gobl_") {
+		name = "stripe_" + strings.TrimPrefix(name, "gobl_")
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(path), "..", name))
+	require.NoError(t, err, "missing Stripe source for %s", path)
+
+	inv, err := convertExample(data)
+	require.NoError(t, err)
+	return inv
+}
+
+// roundTripInvoice converts a GOBL invoice or credit note into the Stripe object its
+// params would create, built with the fixture package, and back into GOBL.
+func roundTripInvoice(inv *bill.Invoice) (*bill.Invoice, error) {
+	account, err := fixture.ToAccount(inv)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Type == bill.InvoiceTypeCreditNote {
+		doc, err := fixture.ToCreditNote(inv)
+		if err != nil {
+			return nil, err
+		}
+		return goblstripe.FromCreditNote(doc, account)
+	}
+	doc, err := fixture.ToInvoice(inv)
+	if err != nil {
+		return nil, err
+	}
+	return goblstripe.FromInvoice(doc, account)
+}
+
+// roundTripDiffs lists the differences in the totals, tax breakdown, parties, extension
+// codes, notes and subscription or quote references of two GOBL invoices, one per line
+// with the path of the field.
+func roundTripDiffs(want, got *bill.Invoice) []string {
+	var diffs []string
+	diffs = append(diffs, jsonDiffs("totals", roundTripTotals(want.Totals), roundTripTotals(got.Totals))...)
+	diffs = append(diffs, jsonDiffs("supplier", roundTripParty(want.Supplier), roundTripParty(got.Supplier))...)
+	diffs = append(diffs, jsonDiffs("customer", roundTripParty(want.Customer), roundTripParty(got.Customer))...)
+	diffs = append(diffs, jsonDiffs("lines.ext", lineExtensions(want.Lines), lineExtensions(got.Lines))...)
+	diffs = append(diffs, jsonDiffs("notes", want.Notes, got.Notes)...)
+	diffs = append(diffs, jsonDiffs("ordering.contracts", orderingRefs(want.Ordering, true), orderingRefs(got.Ordering, true))...)
+	diffs = append(diffs, jsonDiffs("ordering.sales", orderingRefs(want.Ordering, false), orderingRefs(got.Ordering, false))...)
+	return diffs
+}
+
+// roundTripTotals returns the totals without the payment details, which are not part
+// of the Stripe params.
+func roundTripTotals(t *bill.Totals) *bill.Totals {
+	if t == nil {
+		return nil
+	}
+	tt := *t
+	tt.Advances = nil
+	tt.Due = nil
+	tt.Payable = tt.TotalWithTax
+	return &tt
+}
+
+// roundTripParty returns the party without the UUID, which Stripe doesn't keep.
+func roundTripParty(p *org.Party) *org.Party {
+	if p == nil {
+		return nil
+	}
+	pp := *p
+	pp.UUID = ""
+	return &pp
+}
+
+// orderingRefs returns the subscription contracts or the sales orders (quotes) of an
+// invoice ordering.
+func orderingRefs(o *bill.Ordering, contracts bool) []*org.DocumentRef {
+	if o == nil {
+		return nil
+	}
+	if contracts {
+		return o.Contracts
+	}
+	return o.Sales
+}
+
+// lineExtensions collects the item and tax extensions of each line.
+func lineExtensions(lines []*bill.Line) []map[string]any {
+	var list []map[string]any
+	for _, line := range lines {
+		ext := make(map[string]any)
+		if line.Item != nil && len(line.Item.Ext) > 0 {
+			ext["item"] = line.Item.Ext
+		}
+		for _, combo := range line.Taxes {
+			if len(combo.Ext) > 0 {
+				ext[combo.Category.String()] = combo.Ext
+			}
+		}
+		list = append(list, ext)
+	}
+	return list
+}
+
+// jsonDiffs compares the JSON representation of two values field by field.
+func jsonDiffs(path string, want, got any) []string {
+	return valueDiffs(path, toJSONValue(want), toJSONValue(got))
+}
+
+func toJSONValue(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return out
+}
+
+func valueDiffs(path string, want, got any) []string {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range w {
+			keys[k] = true
+		}
+		for k := range g {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		var diffs []string
+		for _, k := range sorted {
+			diffs = append(diffs, valueDiffs(path+"."+k, w[k], g[k])...)
+		}
+		return diffs
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(g) != len(w) {
+			break
+		}
+		var diffs []string
+		for i := range w {
+			diffs = append(diffs, valueDiffs(fmt.Sprintf("%s[%d]", path, i), w[i], g[i])...)
+		}
+		return diffs
+	}
+	if reflect.DeepEqual(want, got) {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s: want %s, got %s", path, compactJSON(want), compactJSON(got))}
+}
+
+func compactJSON(v any) string {
+	if v == nil {
+		return "<missing>"
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,255 @@
+package goblstripe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// MetaKeyStripeBillingCycle is the meta key of GOBL subscription orders that keeps the
+// billing cycle of the subscription as an ISO 8601 duration (e.g. P1M).
+const MetaKeyStripeBillingCycle = "stripe-billing-cycle"
+
+// FromSubscription converts a Stripe subscription into a GOBL purchase order, which acts
+// as the contract behind the subscription invoices. The subscription items are the order
+// lines, billed over the current period of the subscription, which is also the order
+// period. The subscription should be retrieved with the customer and the item products
+// expanded. The regime and the supplier are taken from the account or, when no account
+// is given, from the latest invoice, which must then be expanded.
+func FromSubscription(sub *stripe.Subscription, account *stripe.Account) (*bill.Order, error) {
+	if sub == nil {
+		return nil, fmt.Errorf("missing subscription")
+	}
+
+	regimeDef, err := regimeFromSubscription(sub, account)
+	if err != nil {
+		return nil, err
+	}
+
+	ord := new(bill.Order)
+	ord.Type = bill.OrderTypePurchase
+	ord.UUID = uuidFromMetadata(sub.Metadata, uuid.V7) // Generated randomly when the subscription was not created from GOBL.
+	ord.Code = cbc.Code(sub.ID)
+
+	ord.Meta = cbc.Meta{
+		MetaKeyStripeDocID:   sub.ID,
+		MetaKeyStripeDocType: StripeDocTypeSubscription,
+	}
+	if cycle := subscriptionBillingCycle(sub); cycle != "" {
+		ord.Meta[MetaKeyStripeBillingCycle] = cycle
+	}
+
+	start := sub.StartDate
+	if start == 0 {
+		start = sub.Created
+	}
+	ord.IssueDate = *newDateFromTS(start, regimeDef.TimeLocation())
+	if sub.CurrentPeriodStart != 0 && sub.CurrentPeriodEnd != 0 {
+		ord.Period = &cal.Period{
+			Start: *newDateFromTS(sub.CurrentPeriodStart, regimeDef.TimeLocation()),
+			End:   *newDateFromTS(sub.CurrentPeriodEnd, regimeDef.TimeLocation()),
+		}
+	}
+
+	ord.Currency = FromCurrency(sub.Currency)
+	ord.ExchangeRates = newExchangeRates(ord.Currency, regimeDef)
+
+	latest := sub.LatestInvoice
+	if latest != nil && latest.Created == 0 {
+		latest = nil
+	}
+
+	ord.Supplier = NewSupplierFromAccount(account)
+	if ord.Supplier == nil && latest != nil {
+		ord.Supplier = newSupplierFromInvoice(latest)
+	}
+
+	if sub.Customer != nil && sub.Customer.Created != 0 {
+		ord.Customer = FromCustomer(sub.Customer)
+	} else if latest != nil {
+		ord.Customer = newCustomerFromInvoice(latest)
+	}
+
+	reverseCharge := sub.Customer != nil && sub.Customer.TaxExempt == stripe.CustomerTaxExemptReverse
+	ord.Tags = newTags(reverseCharge, ord.Customer)
+
+	lines, err := subscriptionInvoiceLines(sub)
+	if err != nil {
+		return nil, err
+	}
+	ord.Lines = FromInvoiceLines(lines, regimeDef)
+	ord.Tax = taxFromInvoiceTaxAmounts(lineTaxAmounts(lines), lines)
+	ord.Notes = newInvoiceNotes(sub.Description, "")
+
+	return ord, nil
+}
+
+// regimeFromSubscription returns the regime of the account of a subscription.
+func regimeFromSubscription(sub *stripe.Subscription, account *stripe.Account) (*tax.RegimeDef, error) {
+	if account == nil && sub.LatestInvoice != nil && sub.LatestInvoice.AccountCountry != "" {
+		return regimeFromInvoice(sub.LatestInvoice)
+	}
+	return regimeFromAccount(account)
+}
+
+// newContractFromSubscription creates a reference to the GOBL order of a subscription
+// (see FromSubscription).
+func newContractFromSubscription(sub *stripe.Subscription, regimeDef *tax.RegimeDef) *org.DocumentRef {
+	docRef := &org.DocumentRef{
+		Type: bill.OrderTypePurchase,
+		Code: cbc.Code(sub.ID),
+	}
+	if sub.StartDate != 0 {
+		docRef.IssueDate = newDateFromTS(sub.StartDate, regimeDef.TimeLocation())
+	}
+	return docRef
+}
+
+// subscriptionBillingCycle returns the billing cycle of the subscription prices as an
+// ISO 8601 duration, or an empty string when the subscription has no recurring price.
+func subscriptionBillingCycle(sub *stripe.Subscription) string {
+	if sub.Items == nil {
+		return ""
+	}
+	for _, item := range sub.Items.Data {
+		if item.Price == nil || item.Price.Recurring == nil {
+			continue
+		}
+		r := item.Price.Recurring
+		count := r.IntervalCount
+		if count == 0 {
+			count = 1
+		}
+		unit := strings.ToUpper(string(r.Interval))
+		if unit == "" {
+			continue
+		}
+		return fmt.Sprintf("P%d%s", count, unit[:1])
+	}
+	return ""
+}
+
+// subscriptionInvoiceLines converts the items of a subscription into the invoice line
+// items of its current period, so they can be converted with the invoice line logic.
+func subscriptionInvoiceLines(sub *stripe.Subscription) ([]*stripe.InvoiceLineItem, error) {
+	if sub.Items == nil {
+		return nil, nil
+	}
+	var period *stripe.Period
+	if sub.CurrentPeriodStart != 0 && sub.CurrentPeriodEnd != 0 {
+		period = &stripe.Period{Start: sub.CurrentPeriodStart, End: sub.CurrentPeriodEnd}
+	}
+
+	lines := make([]*stripe.InvoiceLineItem, 0, len(sub.Items.Data))
+	for _, item := range sub.Items.Data {
+		if item.Deleted || item.Price == nil {
+			continue
+		}
+		amount, err := subscriptionItemAmount(item.Price, item.Quantity)
+		if err != nil {
+			return nil, fmt.Errorf("subscription item %s: %w", item.ID, err)
+		}
+		line := &stripe.InvoiceLineItem{
+			ID:       item.ID,
+			Amount:   amount,
+			Currency: sub.Currency,
+			Metadata: item.Metadata,
+			Period:   period,
+			Plan:     item.Plan,
+			Price:    item.Price,
+			Quantity: item.Quantity,
+		}
+		rates := item.TaxRates
+		if len(rates) == 0 {
+			rates = sub.DefaultTaxRates
+		}
+		for _, rate := range rates {
+			r := *rate
+			if r.EffectivePercentage == 0 {
+				// Manual tax rates are applied at their percentage
+				r.EffectivePercentage = r.Percentage
+			}
+			line.TaxAmounts = append(line.TaxAmounts, &stripe.InvoiceTotalTaxAmount{
+				Inclusive: r.Inclusive,
+				TaxRate:   &r,
+			})
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// subscriptionItemAmount returns the amount billed for a quantity of a price, in the
+// currency's smallest unit, calculated the way Stripe does: per unit, using the decimal
+// unit amount and the quantity transformation when set, or by tiers, in which case the
+// tiers of the price must be expanded.
+func subscriptionItemAmount(price *stripe.Price, quantity int64) (int64, error) {
+	if price.BillingScheme == stripe.PriceBillingSchemeTiered {
+		return tieredPriceAmount(price, quantity)
+	}
+	if tq := price.TransformQuantity; tq != nil && tq.DivideBy > 0 {
+		q := quantity / tq.DivideBy
+		if tq.Round == stripe.PriceTransformQuantityRoundUp && quantity%tq.DivideBy != 0 {
+			q++
+		}
+		quantity = q
+	}
+	amount := priceDecimal(price.UnitAmount, price.UnitAmountDecimal).Multiply(num.MakeAmount(quantity, 0))
+	return amount.Rescale(0).Value(), nil
+}
+
+// tieredPriceAmount returns the amount billed for a quantity of a tiered price. Volume
+// tiers price every unit with the tier the whole quantity falls in, while graduated tiers
+// price the units within each tier with that tier. The flat amount of a tier is added
+// when any unit falls in it.
+func tieredPriceAmount(price *stripe.Price, quantity int64) (int64, error) {
+	if len(price.Tiers) == 0 {
+		return 0, fmt.Errorf("missing tiers of price %s: expand items.data.price.tiers", price.ID)
+	}
+	total := num.AmountZero
+	from := int64(0)
+	for _, tier := range price.Tiers {
+		last := tier.UpTo == 0 || quantity <= tier.UpTo
+		var units int64
+		switch price.TiersMode {
+		case stripe.PriceTiersModeVolume:
+			if !last {
+				continue
+			}
+			units = quantity
+		case stripe.PriceTiersModeGraduated:
+			units = quantity - from
+			if !last {
+				units = tier.UpTo - from
+			}
+			from = tier.UpTo
+		default:
+			return 0, fmt.Errorf("unsupported tiers mode of price %s: %q", price.ID, price.TiersMode)
+		}
+		if units > 0 {
+			total = total.Add(priceDecimal(tier.UnitAmount, tier.UnitAmountDecimal).Multiply(num.MakeAmount(units, 0)))
+			total = total.Add(priceDecimal(tier.FlatAmount, tier.FlatAmountDecimal))
+		}
+		if last {
+			return total.Rescale(0).Value(), nil
+		}
+	}
+	return 0, fmt.Errorf("quantity %d exceeds the tiers of price %s", quantity, price.ID)
+}
+
+// priceDecimal returns a Stripe price amount in the currency's smallest unit, keeping
+// the precision of its decimal value when set.
+func priceDecimal(amount int64, decimal float64) num.Amount {
+	if decimal == 0 {
+		return num.MakeAmount(amount, 0)
+	}
+	return num.AmountFromFloat64(decimal, 9)
+}
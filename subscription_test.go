@@ -0,0 +1,188 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func validStripeSubscription() *stripe.Subscription {
+	return &stripe.Subscription{
+		ID:                 "sub_1QkqKWQhcl5B85YlLkS7a1bQ",
+		Object:             "subscription",
+		Created:            1735732800, // 2025-01-01 12:00 UTC
+		StartDate:          1735732800,
+		CurrentPeriodStart: 1738411200, // 2025-02-01 12:00 UTC
+		CurrentPeriodEnd:   1740830400, // 2025-03-01 12:00 UTC
+		Currency:           stripe.CurrencyEUR,
+		Customer:           validStripeCustomer(),
+		Status:             stripe.SubscriptionStatusActive,
+		DefaultTaxRates: []*stripe.TaxRate{
+			{
+				Created:    1736351225,
+				TaxType:    stripe.TaxRateTaxTypeVAT,
+				Country:    "DE",
+				Percentage: 19.0,
+			},
+		},
+		Items: &stripe.SubscriptionItemList{
+			Data: []*stripe.SubscriptionItem{
+				{
+					ID:       "si_RdU4Z1ODNVGdKd",
+					Quantity: 3,
+					Price: &stripe.Price{
+						BillingScheme: stripe.PriceBillingSchemePerUnit,
+						Currency:      stripe.CurrencyEUR,
+						UnitAmount:    1500,
+						Recurring: &stripe.PriceRecurring{
+							Interval:      stripe.PriceRecurringIntervalMonth,
+							IntervalCount: 1,
+						},
+						Product: &stripe.Product{Name: "Pro seat"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestFromSubscription(t *testing.T) {
+	account := validStripeAccount()
+	account.Country = "DE"
+
+	ord, err := goblstripe.FromSubscription(validStripeSubscription(), account)
+	require.NoError(t, err)
+	require.NoError(t, ord.Calculate())
+	require.NoError(t, ord.Validate())
+
+	assert.Equal(t, bill.OrderTypePurchase, ord.Type)
+	assert.Equal(t, "sub_1QkqKWQhcl5B85YlLkS7a1bQ", ord.Code.String())
+	assert.Equal(t, "subscription", ord.Meta[goblstripe.MetaKeyStripeDocType])
+	assert.Equal(t, "P1M", ord.Meta[goblstripe.MetaKeyStripeBillingCycle])
+	assert.Equal(t, cal.MakeDate(2025, 1, 1), ord.IssueDate)
+	require.NotNil(t, ord.Period)
+	assert.Equal(t, cal.MakeDate(2025, 2, 1), ord.Period.Start)
+	assert.Equal(t, cal.MakeDate(2025, 3, 1), ord.Period.End)
+
+	assert.Equal(t, "Test Account", ord.Supplier.Name)
+	assert.Equal(t, "Test Customer", ord.Customer.Name)
+
+	require.Len(t, ord.Lines, 1)
+	line := ord.Lines[0]
+	assert.Equal(t, "Pro seat", line.Item.Name)
+	assert.Equal(t, "3", line.Quantity.String())
+	assert.Equal(t, "15.00", line.Item.Price.String())
+	assert.Equal(t, cal.MakeDate(2025, 2, 1), line.Period.Start)
+	assert.Equal(t, tax.RateGeneral, line.Taxes[0].Rate)
+	assert.Equal(t, "53.55", ord.Totals.Payable.String())
+
+	t.Run("from latest invoice", func(t *testing.T) {
+		sub := validStripeSubscription()
+		sub.LatestInvoice = minimalStripeInvoice()
+		sub.Items.Data[0].Price.Recurring.IntervalCount = 3
+		ord, err := goblstripe.FromSubscription(sub, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "Test Account", ord.Supplier.Name)
+		assert.Equal(t, "P3M", ord.Meta[goblstripe.MetaKeyStripeBillingCycle])
+	})
+
+	t.Run("item amounts", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			qty   int64
+			price func(p *stripe.Price)
+			sum   string
+		}{
+			{
+				name: "decimal unit amount",
+				qty:  3,
+				price: func(p *stripe.Price) {
+					p.UnitAmount = 0
+					p.UnitAmountDecimal = 1234.5
+				},
+				sum: "37.04",
+			},
+			{
+				name: "transform quantity",
+				qty:  25,
+				price: func(p *stripe.Price) {
+					p.TransformQuantity = &stripe.PriceTransformQuantity{
+						DivideBy: 10,
+						Round:    stripe.PriceTransformQuantityRoundUp,
+					}
+				},
+				sum: "45.00",
+			},
+			{
+				name: "graduated tiers",
+				qty:  15,
+				price: func(p *stripe.Price) {
+					p.BillingScheme = stripe.PriceBillingSchemeTiered
+					p.TiersMode = stripe.PriceTiersModeGraduated
+					p.Tiers = []*stripe.PriceTier{
+						{UpTo: 10, UnitAmount: 1000, FlatAmount: 500},
+						{UnitAmount: 800},
+					}
+				},
+				sum: "145.00",
+			},
+			{
+				name: "volume tiers",
+				qty:  15,
+				price: func(p *stripe.Price) {
+					p.BillingScheme = stripe.PriceBillingSchemeTiered
+					p.TiersMode = stripe.PriceTiersModeVolume
+					p.Tiers = []*stripe.PriceTier{
+						{UpTo: 10, UnitAmount: 1000},
+						{UnitAmount: 800, FlatAmount: 500},
+					}
+				},
+				sum: "125.00",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				sub := validStripeSubscription()
+				item := sub.Items.Data[0]
+				item.Quantity = tt.qty
+				tt.price(item.Price)
+				ord, err := goblstripe.FromSubscription(sub, account)
+				require.NoError(t, err)
+				require.NoError(t, ord.Calculate())
+				assert.Equal(t, tt.sum, ord.Lines[0].Sum.String())
+			})
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := goblstripe.FromSubscription(nil, account)
+		assert.ErrorContains(t, err, "missing subscription")
+
+		_, err = goblstripe.FromSubscription(validStripeSubscription(), nil)
+		assert.ErrorContains(t, err, "missing account country")
+
+		sub := validStripeSubscription()
+		sub.Items.Data[0].Price.BillingScheme = stripe.PriceBillingSchemeTiered
+		_, err = goblstripe.FromSubscription(sub, account)
+		assert.ErrorContains(t, err, "missing tiers")
+	})
+}
+
+func TestFromInvoiceWithSubscription(t *testing.T) {
+	doc := minimalStripeInvoice()
+	doc.Subscription = &stripe.Subscription{ID: "sub_1QkqKWQhcl5B85YlLkS7a1bQ"}
+
+	inv, err := goblstripe.FromInvoice(doc, validStripeAccount())
+	require.NoError(t, err)
+	require.NoError(t, inv.Calculate())
+
+	require.Len(t, inv.Ordering.Contracts, 1)
+	assert.Equal(t, bill.OrderTypePurchase, inv.Ordering.Contracts[0].Type)
+	assert.Equal(t, "sub_1QkqKWQhcl5B85YlLkS7a1bQ", inv.Ordering.Contracts[0].Code.String())
+}
@@ -1,10 +1,19 @@
 package goblstripe
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/catalogues/cef"
 	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/regimes/ca"
+	"github.com/invopop/gobl/regimes/in"
 	"github.com/invopop/gobl/tax"
 	"github.com/stripe/stripe-go/v81"
 )
@@ -17,12 +26,20 @@ func taxFromInvoiceTaxAmounts(taxAmounts []*stripe.InvoiceTotalTaxAmount, lines
 		return nil
 	}
 
-	// We just check the first tax
-	if !taxAmounts[0].Inclusive {
+	// GOBL supports a single category included in the prices, so the first inclusive
+	// tax is used
+	var inclusive *stripe.InvoiceTotalTaxAmount
+	for _, ta := range taxAmounts {
+		if ta.Inclusive {
+			inclusive = ta
+			break
+		}
+	}
+	if inclusive == nil {
 		return nil
 	}
 
-	cat := extractTaxCat(taxAmounts[0].TaxRate)
+	cat := extractTaxCat(inclusive.TaxRate)
 	if cat == "" {
 		cat = taxCatFromInvoiceLines(lines)
 	}
@@ -41,12 +58,20 @@ func taxFromCreditNoteTaxAmounts(taxAmounts []*stripe.CreditNoteTaxAmount, lines
 		return nil
 	}
 
-	// We just check the first tax
-	if !taxAmounts[0].Inclusive {
+	// GOBL supports a single category included in the prices, so the first inclusive
+	// tax is used
+	var inclusive *stripe.CreditNoteTaxAmount
+	for _, ta := range taxAmounts {
+		if ta.Inclusive {
+			inclusive = ta
+			break
+		}
+	}
+	if inclusive == nil {
 		return nil
 	}
 
-	cat := extractTaxCat(taxAmounts[0].TaxRate)
+	cat := extractTaxCat(inclusive.TaxRate)
 	if cat == "" {
 		cat = taxCatFromCreditNoteLines(lines)
 	}
@@ -83,6 +108,8 @@ func taxCatFromCreditNoteLines(lines []*stripe.CreditNoteLineItem) cbc.Code {
 
 // extractTaxCat extracts the tax category from a Stripe tax rate.
 // If the tax type is not set, we use the display name to determine the tax category.
+// Canadian provincial taxes (PST, QST and RST) all map to the PST category, and Indian
+// GST is split into its central, state and union territory parts.
 func extractTaxCat(taxRate *stripe.TaxRate) cbc.Code {
 	if taxRate == nil {
 		return ""
@@ -93,7 +120,16 @@ func extractTaxCat(taxRate *stripe.TaxRate) cbc.Code {
 	case stripe.TaxRateTaxTypeSalesTax:
 		return tax.CategoryST
 	case stripe.TaxRateTaxTypeGST:
+		if cat := indianGSTCat(taxRate); cat != "" {
+			return cat
+		}
 		return tax.CategoryGST
+	case stripe.TaxRateTaxTypeHST:
+		return ca.TaxCategoryHST
+	case stripe.TaxRateTaxTypePST, stripe.TaxRateTaxTypeQST, stripe.TaxRateTaxTypeRST:
+		return ca.TaxCategoryPST
+	case stripe.TaxRateTaxTypeIGST:
+		return in.TaxCategoryIGST
 	}
 
 	switch strings.ToLower(strings.TrimSpace(taxRate.DisplayName)) {
@@ -102,8 +138,438 @@ func extractTaxCat(taxRate *stripe.TaxRate) cbc.Code {
 	case "sales tax":
 		return tax.CategoryST
 	case "gst":
+		if cat := indianGSTCat(taxRate); cat != "" {
+			return cat
+		}
 		return tax.CategoryGST
+	case "hst":
+		return ca.TaxCategoryHST
+	case "pst", "qst", "rst":
+		return ca.TaxCategoryPST
+	case "igst":
+		return in.TaxCategoryIGST
+	case "cgst":
+		return in.TaxCategoryCGST
+	case "sgst":
+		return in.TaxCategorySGST
+	case "utgst":
+		return in.TaxCategoryUTGST
 	}
 
 	return cbc.Code(taxRate.DisplayName)
 }
+
+// newInvoiceSalesTaxNotes creates a tax note for each sales tax jurisdiction in the tax
+// amounts of an invoice, so the state, county, city and district taxes that are added
+// up in the line combos are still shown separately.
+func newInvoiceSalesTaxNotes(taxAmounts []*stripe.InvoiceTotalTaxAmount, curr currency.Code) []*org.Note {
+	var notes []*org.Note
+	for _, ta := range taxAmounts {
+		if n := newSalesTaxNote(ta.TaxRate, ta.Amount, curr); n != nil {
+			notes = append(notes, n)
+		}
+	}
+	return notes
+}
+
+// newCreditNoteSalesTaxNotes creates a tax note for each sales tax jurisdiction in the
+// tax amounts of a credit note.
+func newCreditNoteSalesTaxNotes(taxAmounts []*stripe.CreditNoteTaxAmount, curr currency.Code) []*org.Note {
+	var notes []*org.Note
+	for _, ta := range taxAmounts {
+		if n := newSalesTaxNote(ta.TaxRate, ta.Amount, curr); n != nil {
+			notes = append(notes, n)
+		}
+	}
+	return notes
+}
+
+// Meta keys of the tax notes of sales tax jurisdictions (see newSalesTaxNote), which
+// keep the jurisdiction and percentage of the rates added up in the line combos, so a
+// Stripe tax rate can be created again for each of them (see TaxRateRegistry.Missing).
+const (
+	MetaKeyStripeJurisdiction      = "stripe-jurisdiction"
+	MetaKeyStripeJurisdictionLevel = "stripe-jurisdiction-level"
+	MetaKeyStripeState             = "stripe-state"
+	MetaKeyStripePercentage        = "stripe-percentage"
+)
+
+// newSalesTaxNote creates the tax note of a sales tax jurisdiction, with its name,
+// state, level, percentage and amount (e.g. "LOS ANGELES (CA, city) 2.25%: 4.50"). The
+// note code is the jurisdiction level, and the jurisdiction, level, state and percentage
+// are also kept in the note meta. Stripe only reports the level of the rates it
+// calculates, so the level of manual rates is taken from their metadata. It returns nil
+// for other taxes, for rates without a jurisdiction and for jurisdictions that charged
+// no tax.
+func newSalesTaxNote(rate *stripe.TaxRate, amount int64, curr currency.Code) *org.Note {
+	if rate == nil || rate.Jurisdiction == "" || amount == 0 || extractTaxCat(rate) != tax.CategoryST {
+		return nil
+	}
+
+	level := string(rate.JurisdictionLevel)
+	if level == "" {
+		level = rate.Metadata[metaKeyJurisdictionLevel]
+	}
+	var details []string
+	if rate.State != "" {
+		details = append(details, rate.State)
+	}
+	if level != "" {
+		details = append(details, level)
+	}
+	place := rate.Jurisdiction
+	if len(details) > 0 {
+		place += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	value := rate.EffectivePercentage
+	if value == 0 {
+		value = rate.Percentage
+	}
+	percent, _ := num.PercentageFromString(strconv.FormatFloat(value, 'f', -1, 64) + "%")
+
+	meta := cbc.Meta{
+		MetaKeyStripeJurisdiction: rate.Jurisdiction,
+		MetaKeyStripePercentage:   percent.StringWithoutSymbol(),
+	}
+	if level != "" {
+		meta[MetaKeyStripeJurisdictionLevel] = level
+	}
+	if rate.State != "" {
+		meta[MetaKeyStripeState] = rate.State
+	}
+
+	return &org.Note{
+		Key:  org.NoteKeyTax,
+		Code: cbc.Code(level),
+		Text: fmt.Sprintf("%s %s: %s", place, num.MakeFormatter(".", ",").Percentage(percent), CurrencyAmount(amount, curr).String()),
+		Meta: meta,
+	}
+}
+
+// salesTaxRateParams splits a sales tax combo into the params of a Stripe tax rate for
+// each jurisdiction kept in the sales tax notes of a document (see newSalesTaxNote), so
+// the breakdown reported by Stripe is kept. It returns nil for other combos and when
+// the percentages of the jurisdictions don't add up to the one of the combo, e.g. for
+// lines exempt in some of them, which get a single combined rate instead.
+func salesTaxRateParams(combo *tax.Combo, pricesInclude cbc.Code, regimeDef *tax.RegimeDef, notes []*org.Note) []*stripe.TaxRateParams {
+	if combo == nil || combo.Category != tax.CategoryST || combo.Percent == nil {
+		return nil
+	}
+	var list []*stripe.TaxRateParams
+	total := num.AmountZero
+	for _, n := range notes {
+		if n == nil || n.Meta[MetaKeyStripeJurisdiction] == "" {
+			continue
+		}
+		percent, err := num.PercentageFromString(n.Meta[MetaKeyStripePercentage] + "%")
+		if err != nil {
+			return nil
+		}
+		params := ToTaxRateParams(combo, pricesInclude, regimeDef)
+		if params == nil {
+			return nil
+		}
+		params.Percentage = stripe.Float64(toStripePercentage(&percent))
+		params.Jurisdiction = stripe.String(n.Meta[MetaKeyStripeJurisdiction])
+		if state := n.Meta[MetaKeyStripeState]; state != "" {
+			params.State = stripe.String(state)
+		}
+		if level := n.Meta[MetaKeyStripeJurisdictionLevel]; level != "" {
+			params.AddMetadata(metaKeyJurisdictionLevel, level)
+		}
+		total = total.MatchPrecision(percent.Amount()).Add(percent.Amount())
+		list = append(list, params)
+	}
+	if len(list) == 0 || !total.Equals(combo.Percent.Amount()) {
+		return nil
+	}
+	return list
+}
+
+// indianGSTCat returns the category of the part of the Indian GST a tax rate charges.
+// Stripe reports both the central and the state GST of intra-state supplies as GST, so
+// the part is taken from the display name or else from the jurisdiction level. It
+// returns an empty code for rates outside India.
+func indianGSTCat(taxRate *stripe.TaxRate) cbc.Code {
+	if !strings.EqualFold(taxRate.Country, l10n.IN.String()) {
+		return ""
+	}
+	switch strings.ToUpper(strings.TrimSpace(taxRate.DisplayName)) {
+	case in.TaxCategoryCGST.String():
+		return in.TaxCategoryCGST
+	case in.TaxCategorySGST.String():
+		return in.TaxCategorySGST
+	case in.TaxCategoryUTGST.String():
+		return in.TaxCategoryUTGST
+	case in.TaxCategoryIGST.String():
+		return in.TaxCategoryIGST
+	}
+	switch taxRate.JurisdictionLevel {
+	case stripe.TaxRateJurisdictionLevelCountry:
+		return in.TaxCategoryCGST
+	case stripe.TaxRateJurisdictionLevelState:
+		return in.TaxCategorySGST
+	}
+	return ""
+}
+
+// metaKeyTaxKey is the Stripe tax rate metadata key used to store the GOBL tax key
+// (e.g. reverse-charge or exempt), so rates with the same percentage but a different
+// meaning are not mixed up. The standard key is the default and never stored.
+const metaKeyTaxKey = "gobl-tax-key"
+
+// metaKeyTaxExemption is the Stripe tax rate metadata key used to store the VATEX
+// exemption code (CEF catalogue) of an untaxed GOBL combo, so the exact legal reason
+// is kept instead of the default one derived from the tax key.
+const metaKeyTaxExemption = "gobl-tax-exemption"
+
+// metaKeyJurisdictionLevel is the Stripe tax rate metadata key used to store the level
+// of a sales tax jurisdiction (e.g. state or city), which Stripe doesn't set on manual
+// rates.
+const metaKeyJurisdictionLevel = "gobl-jurisdiction-level"
+
+// Lookup map for GOBL tax category to Stripe tax type
+var taxTypeMapGOBLToStripe = map[cbc.Code]stripe.TaxRateTaxType{
+	tax.CategoryVAT:     stripe.TaxRateTaxTypeVAT,
+	tax.CategoryGST:     stripe.TaxRateTaxTypeGST,
+	tax.CategoryST:      stripe.TaxRateTaxTypeSalesTax,
+	ca.TaxCategoryHST:   stripe.TaxRateTaxTypeHST,
+	ca.TaxCategoryPST:   stripe.TaxRateTaxTypePST,
+	in.TaxCategoryCGST:  stripe.TaxRateTaxTypeGST,
+	in.TaxCategorySGST:  stripe.TaxRateTaxTypeGST,
+	in.TaxCategoryUTGST: stripe.TaxRateTaxTypeGST,
+	in.TaxCategoryIGST:  stripe.TaxRateTaxTypeIGST,
+}
+
+// ToTaxRateParams converts a GOBL tax combo into a stripe tax rate object suitable for
+// sending to the Stripe API. The rate is inclusive when the combo's category is the one
+// included in the invoice prices. Combos without a country take the regime's country.
+// Retained taxes can't be represented in Stripe, so nil is returned for them. Sales
+// tax combos add up the rates of all the jurisdictions, so they get a single rate with
+// no jurisdiction; the tax rate registry splits them again when the document keeps the
+// breakdown in its notes (see TaxRateRegistry.Missing).
+func ToTaxRateParams(combo *tax.Combo, pricesInclude cbc.Code, regimeDef *tax.RegimeDef) *stripe.TaxRateParams {
+	if combo == nil || combo.Category == "" {
+		return nil
+	}
+	if cd := regimeDef.CategoryDef(combo.Category); cd != nil && cd.Retained {
+		return nil
+	}
+
+	params := &stripe.TaxRateParams{
+		DisplayName: stripe.String(taxRateDisplayName(combo.Category)),
+		Inclusive:   stripe.Bool(pricesInclude != "" && pricesInclude == combo.Category),
+		Percentage:  stripe.Float64(toStripePercentage(combo.Percent)),
+	}
+
+	country := combo.Country
+	if country == "" && regimeDef != nil {
+		country = regimeDef.Country
+	}
+	if country != "" {
+		params.Country = stripe.String(country.String())
+	}
+
+	if tt, ok := taxTypeMapGOBLToStripe[combo.Category]; ok {
+		params.TaxType = stripe.String(string(tt))
+	}
+
+	if combo.Key != "" && combo.Key != tax.KeyStandard {
+		params.Metadata = map[string]string{
+			metaKeyTaxKey: combo.Key.String(),
+		}
+		if code := combo.Ext.Get(cef.ExtKeyVATEX); code != "" {
+			params.Metadata[metaKeyTaxExemption] = code.String()
+		}
+	}
+
+	return params
+}
+
+// taxRateDisplayName returns the name shown to customers for the Stripe tax rates of a
+// GOBL tax category, which is the category code except for sales tax.
+func taxRateDisplayName(cat cbc.Code) string {
+	if cat == tax.CategoryST {
+		return "Sales Tax"
+	}
+	return cat.String()
+}
+
+// toStripePercentage converts a GOBL percentage into the float used by Stripe tax rates.
+// A missing percentage (e.g. exempt or reverse charge combos) is sent as 0%.
+func toStripePercentage(p *num.Percentage) float64 {
+	if p == nil {
+		return 0
+	}
+	f, _ := strconv.ParseFloat(p.StringWithoutSymbol(), 64)
+	return f
+}
+
+// TaxRateRegistry keeps track of the tax rates defined in a Stripe account, so that
+// GOBL tax combos can be matched against them instead of creating duplicate rates.
+type TaxRateRegistry struct {
+	rates []*stripe.TaxRate
+}
+
+// NewTaxRateRegistry creates a tax rate registry from a list of existing Stripe tax
+// rates, typically the result of listing the tax rates of the account.
+func NewTaxRateRegistry(rates []*stripe.TaxRate) *TaxRateRegistry {
+	r := new(TaxRateRegistry)
+	for _, rate := range rates {
+		r.Add(rate)
+	}
+	return r
+}
+
+// Add registers a Stripe tax rate, such as one just created from the params returned
+// by Lookup. Inactive rates are ignored as they can't be applied to new invoices.
+func (r *TaxRateRegistry) Add(rate *stripe.TaxRate) {
+	if rate == nil || !rate.Active {
+		return
+	}
+	r.rates = append(r.rates, rate)
+}
+
+// Lookup returns the ID of the registered tax rate that matches the GOBL tax combo or,
+// when there is none, the params to create a new one. Both are empty for combos that
+// can't be represented as a Stripe tax rate.
+func (r *TaxRateRegistry) Lookup(combo *tax.Combo, pricesInclude cbc.Code, regimeDef *tax.RegimeDef) (string, *stripe.TaxRateParams) {
+	params := ToTaxRateParams(combo, pricesInclude, regimeDef)
+	if params == nil {
+		return "", nil
+	}
+	if id := r.find(params); id != "" {
+		return id, nil
+	}
+	return "", params
+}
+
+// lookupAll returns the IDs of the registered tax rates of a GOBL tax combo and the
+// params of the ones missing. Sales tax combos get a rate per jurisdiction kept in the
+// notes of the document (see salesTaxRateParams), and the rest a single one as in
+// Lookup.
+func (r *TaxRateRegistry) lookupAll(combo *tax.Combo, pricesInclude cbc.Code, regimeDef *tax.RegimeDef, notes []*org.Note) ([]string, []*stripe.TaxRateParams) {
+	list := salesTaxRateParams(combo, pricesInclude, regimeDef, notes)
+	if list == nil {
+		if p := ToTaxRateParams(combo, pricesInclude, regimeDef); p != nil {
+			list = []*stripe.TaxRateParams{p}
+		}
+	}
+	var ids []string
+	var missing []*stripe.TaxRateParams
+	for _, params := range list {
+		if id := r.find(params); id != "" {
+			ids = append(ids, id)
+		} else {
+			missing = append(missing, params)
+		}
+	}
+	return ids, missing
+}
+
+// find returns the ID of the registered tax rate that matches the params, if any.
+func (r *TaxRateRegistry) find(params *stripe.TaxRateParams) string {
+	for _, rate := range r.rates {
+		if taxRateMatches(rate, params) {
+			return rate.ID
+		}
+	}
+	return ""
+}
+
+// Missing returns the params of the tax rates used in a GOBL invoice that are not yet
+// registered, without duplicates. They need to be created in Stripe and added to the
+// registry before converting the invoice. Sales tax combos get a rate per jurisdiction
+// kept in the notes of the invoice (see FromInvoice).
+func (r *TaxRateRegistry) Missing(inv *bill.Invoice) []*stripe.TaxRateParams {
+	regimeDef := regimeFromGOBLInvoice(inv)
+	pricesInclude := pricesIncludeFromInvoice(inv)
+	var missing []*stripe.TaxRateParams
+	for _, line := range inv.Lines {
+		if line == nil {
+			continue
+		}
+		for _, combo := range line.Taxes {
+			_, list := r.lookupAll(combo, pricesInclude, regimeDef, inv.Notes)
+			for _, params := range list {
+				found := false
+				for _, p := range missing {
+					if taxRateMatches(taxRateFromParams(p), params) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					missing = append(missing, params)
+				}
+			}
+		}
+	}
+	return missing
+}
+
+// taxRateIDs returns the IDs of the registered tax rates for a GOBL tax set. It fails
+// when any of the combos has no matching tax rate in the registry.
+func (r *TaxRateRegistry) taxRateIDs(set tax.Set, pricesInclude cbc.Code, regimeDef *tax.RegimeDef, notes []*org.Note) ([]*string, error) {
+	var ids []*string
+	for _, combo := range set {
+		found, missing := r.lookupAll(combo, pricesInclude, regimeDef, notes)
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("missing Stripe tax rate for %s %.4g%%", combo.Category, stripe.Float64Value(missing[0].Percentage))
+		}
+		for _, id := range found {
+			ids = append(ids, stripe.String(id))
+		}
+	}
+	return ids, nil
+}
+
+// taxRateMatches checks if an existing Stripe tax rate is equivalent to the params of
+// a new one. The tax category is compared instead of the raw tax type so that rates
+// identified only by their display name (e.g. "IVA") are also matched.
+func taxRateMatches(rate *stripe.TaxRate, params *stripe.TaxRateParams) bool {
+	if !rate.Active {
+		return false
+	}
+	if rate.Inclusive != stripe.BoolValue(params.Inclusive) {
+		return false
+	}
+	if !percentFromFloat(rate.Percentage).Equals(*percentFromFloat(stripe.Float64Value(params.Percentage))) {
+		return false
+	}
+	if !strings.EqualFold(rate.Country, stripe.StringValue(params.Country)) {
+		return false
+	}
+	if extractTaxCat(rate) != extractTaxCat(taxRateFromParams(params)) {
+		return false
+	}
+	if rate.Jurisdiction != stripe.StringValue(params.Jurisdiction) || rate.State != stripe.StringValue(params.State) {
+		return false
+	}
+	return rate.Metadata[metaKeyTaxKey] == params.Metadata[metaKeyTaxKey] &&
+		rate.Metadata[metaKeyTaxExemption] == params.Metadata[metaKeyTaxExemption]
+}
+
+// taxRateFromParams builds the Stripe tax rate that would be created from the params.
+func taxRateFromParams(params *stripe.TaxRateParams) *stripe.TaxRate {
+	return &stripe.TaxRate{
+		Active:       true,
+		Country:      stripe.StringValue(params.Country),
+		DisplayName:  stripe.StringValue(params.DisplayName),
+		Inclusive:    stripe.BoolValue(params.Inclusive),
+		Jurisdiction: stripe.StringValue(params.Jurisdiction),
+		Metadata:     params.Metadata,
+		Percentage:   stripe.Float64Value(params.Percentage),
+		State:        stripe.StringValue(params.State),
+		TaxType:      stripe.TaxRateTaxType(stripe.StringValue(params.TaxType)),
+	}
+}
+
+// pricesIncludeFromInvoice returns the tax category included in the prices of a GOBL invoice.
+func pricesIncludeFromInvoice(inv *bill.Invoice) cbc.Code {
+	if inv.Tax == nil {
+		return ""
+	}
+	return inv.Tax.PricesInclude
+}
@@ -4,6 +4,10 @@ import (
 	"testing"
 
 	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -555,3 +559,247 @@ func TestExtractTaxCat(t *testing.T) {
 		assert.Equal(t, "Unknown Tax", string(gi.Tax.PricesInclude))
 	})
 }
+
+func TestToTaxRateParams(t *testing.T) {
+	regimeDef := tax.RegimeDefFor(l10n.ES)
+
+	t.Run("exclusive VAT", func(t *testing.T) {
+		combo := &tax.Combo{
+			Category: tax.CategoryVAT,
+			Rate:     tax.RateGeneral,
+			Percent:  num.NewPercentage(210, 3),
+		}
+		params := goblstripe.ToTaxRateParams(combo, "", regimeDef)
+		require.NotNil(t, params)
+
+		assert.Equal(t, "VAT", stripe.StringValue(params.DisplayName))
+		assert.Equal(t, string(stripe.TaxRateTaxTypeVAT), stripe.StringValue(params.TaxType))
+		assert.Equal(t, "ES", stripe.StringValue(params.Country))
+		assert.Equal(t, 21.0, stripe.Float64Value(params.Percentage))
+		assert.False(t, stripe.BoolValue(params.Inclusive))
+		assert.Nil(t, params.Metadata)
+	})
+
+	t.Run("inclusive with foreign country", func(t *testing.T) {
+		combo := &tax.Combo{
+			Category: tax.CategoryVAT,
+			Country:  "PT",
+			Percent:  num.NewPercentage(23, 2),
+		}
+		params := goblstripe.ToTaxRateParams(combo, tax.CategoryVAT, regimeDef)
+
+		assert.Equal(t, "PT", stripe.StringValue(params.Country))
+		assert.Equal(t, 23.0, stripe.Float64Value(params.Percentage))
+		assert.True(t, stripe.BoolValue(params.Inclusive))
+	})
+
+	t.Run("reverse charge", func(t *testing.T) {
+		combo := &tax.Combo{
+			Category: tax.CategoryVAT,
+			Key:      tax.KeyReverseCharge,
+		}
+		params := goblstripe.ToTaxRateParams(combo, "", regimeDef)
+
+		assert.Equal(t, 0.0, stripe.Float64Value(params.Percentage))
+		assert.Equal(t, "reverse-charge", params.Metadata["gobl-tax-key"])
+	})
+
+	t.Run("exempt with exemption code", func(t *testing.T) {
+		combo := &tax.Combo{
+			Category: tax.CategoryVAT,
+			Key:      tax.KeyExempt,
+			Ext:      tax.Extensions{"cef-vatex": "VATEX-EU-143"},
+		}
+		params := goblstripe.ToTaxRateParams(combo, "", regimeDef)
+
+		assert.Equal(t, "exempt", params.Metadata["gobl-tax-key"])
+		assert.Equal(t, "VATEX-EU-143", params.Metadata["gobl-tax-exemption"])
+	})
+
+	t.Run("decimal percentage", func(t *testing.T) {
+		combo := &tax.Combo{
+			Category: tax.CategoryST,
+			Country:  "US",
+			Percent:  num.NewPercentage(725, 4),
+		}
+		params := goblstripe.ToTaxRateParams(combo, "", tax.RegimeDefFor(l10n.US))
+
+		assert.Equal(t, 7.25, stripe.Float64Value(params.Percentage))
+		assert.Equal(t, string(stripe.TaxRateTaxTypeSalesTax), stripe.StringValue(params.TaxType))
+	})
+
+	t.Run("Indian CGST", func(t *testing.T) {
+		combo := &tax.Combo{
+			Category: "CGST",
+			Country:  "IN",
+			Percent:  num.NewPercentage(9, 2),
+		}
+		params := goblstripe.ToTaxRateParams(combo, "", tax.RegimeDefFor(l10n.IN))
+
+		assert.Equal(t, "CGST", stripe.StringValue(params.DisplayName))
+		assert.Equal(t, string(stripe.TaxRateTaxTypeGST), stripe.StringValue(params.TaxType))
+	})
+
+	t.Run("category without tax type", func(t *testing.T) {
+		combo := &tax.Combo{
+			Category: "IGIC",
+			Percent:  num.NewPercentage(7, 2),
+		}
+		params := goblstripe.ToTaxRateParams(combo, "", regimeDef)
+
+		assert.Equal(t, "IGIC", stripe.StringValue(params.DisplayName))
+		assert.Nil(t, params.TaxType)
+	})
+
+	t.Run("retained tax", func(t *testing.T) {
+		combo := &tax.Combo{
+			Category: "IRPF",
+			Percent:  num.NewPercentage(15, 2),
+		}
+		assert.Nil(t, goblstripe.ToTaxRateParams(combo, "", regimeDef))
+	})
+
+	t.Run("nil combo", func(t *testing.T) {
+		assert.Nil(t, goblstripe.ToTaxRateParams(nil, "", regimeDef))
+	})
+}
+
+func TestTaxRateRegistry(t *testing.T) {
+	regimeDef := tax.RegimeDefFor(l10n.DE)
+	existing := []*stripe.TaxRate{
+		{
+			ID:          "txr_inactive",
+			Active:      false,
+			DisplayName: "VAT",
+			TaxType:     stripe.TaxRateTaxTypeVAT,
+			Country:     "DE",
+			Percentage:  19.0,
+		},
+		{
+			ID:          "txr_vat_de",
+			Active:      true,
+			DisplayName: "MwSt.",
+			TaxType:     stripe.TaxRateTaxTypeVAT,
+			Country:     "DE",
+			Percentage:  19.0,
+		},
+		{
+			ID:          "txr_vat_de_incl",
+			Active:      true,
+			DisplayName: "VAT",
+			TaxType:     stripe.TaxRateTaxTypeVAT,
+			Country:     "DE",
+			Percentage:  19.0,
+			Inclusive:   true,
+		},
+		{
+			ID:          "txr_iva_es",
+			Active:      true,
+			DisplayName: "IVA",
+			Country:     "ES",
+			Percentage:  21.0,
+		},
+	}
+	reg := goblstripe.NewTaxRateRegistry(existing)
+
+	t.Run("matches by tax type", func(t *testing.T) {
+		combo := &tax.Combo{Category: tax.CategoryVAT, Percent: num.NewPercentage(19, 2)}
+		id, params := reg.Lookup(combo, "", regimeDef)
+		assert.Equal(t, "txr_vat_de", id)
+		assert.Nil(t, params)
+	})
+
+	t.Run("matches inclusive", func(t *testing.T) {
+		combo := &tax.Combo{Category: tax.CategoryVAT, Percent: num.NewPercentage(19, 2)}
+		id, _ := reg.Lookup(combo, tax.CategoryVAT, regimeDef)
+		assert.Equal(t, "txr_vat_de_incl", id)
+	})
+
+	t.Run("matches by display name", func(t *testing.T) {
+		combo := &tax.Combo{Category: tax.CategoryVAT, Country: "ES", Percent: num.NewPercentage(21, 2)}
+		id, _ := reg.Lookup(combo, "", regimeDef)
+		assert.Equal(t, "txr_iva_es", id)
+	})
+
+	t.Run("new rate", func(t *testing.T) {
+		combo := &tax.Combo{Category: tax.CategoryVAT, Percent: num.NewPercentage(7, 2)}
+		id, params := reg.Lookup(combo, "", regimeDef)
+		assert.Empty(t, id)
+		require.NotNil(t, params)
+		assert.Equal(t, 7.0, stripe.Float64Value(params.Percentage))
+	})
+
+	t.Run("key must match", func(t *testing.T) {
+		r := goblstripe.NewTaxRateRegistry([]*stripe.TaxRate{
+			{ID: "txr_zero", Active: true, TaxType: stripe.TaxRateTaxTypeVAT, Country: "DE"},
+		})
+		combo := &tax.Combo{Category: tax.CategoryVAT, Key: tax.KeyReverseCharge}
+		id, params := r.Lookup(combo, "", regimeDef)
+		assert.Empty(t, id)
+		require.NotNil(t, params)
+
+		r.Add(&stripe.TaxRate{
+			ID:         "txr_rc",
+			Active:     true,
+			TaxType:    stripe.TaxRateTaxTypeVAT,
+			Country:    "DE",
+			Metadata:   params.Metadata,
+			Percentage: 0,
+		})
+		id, params = r.Lookup(combo, "", regimeDef)
+		assert.Equal(t, "txr_rc", id)
+		assert.Nil(t, params)
+	})
+
+	t.Run("missing rates without duplicates", func(t *testing.T) {
+		inv := validGOBLInvoice()
+		inv.Lines[0].Taxes[0].Percent = num.NewPercentage(19, 2)
+		inv.Lines = append(inv.Lines,
+			&bill.Line{
+				Quantity: num.MakeAmount(1, 0),
+				Item:     &org.Item{Name: "Reduced", Price: num.NewAmount(100, 2)},
+				Taxes:    tax.Set{{Category: tax.CategoryVAT, Percent: num.NewPercentage(7, 2)}},
+			},
+			&bill.Line{
+				Quantity: num.MakeAmount(1, 0),
+				Item:     &org.Item{Name: "Reduced again", Price: num.NewAmount(200, 2)},
+				Taxes:    tax.Set{{Category: tax.CategoryVAT, Percent: num.NewPercentage(7, 2)}},
+			},
+		)
+		missing := reg.Missing(inv)
+		require.Len(t, missing, 1)
+		assert.Equal(t, 7.0, stripe.Float64Value(missing[0].Percentage))
+	})
+}
+
+func TestToInvoiceWithTaxRateRegistry(t *testing.T) {
+	inv := validGOBLInvoice()
+	require.NoError(t, inv.Calculate())
+
+	reg := goblstripe.NewTaxRateRegistry(nil)
+	_, _, err := goblstripe.ToInvoice(inv, goblstripe.WithTaxRateRegistry(reg))
+	assert.ErrorContains(t, err, "missing Stripe tax rate for VAT 19%")
+
+	missing := reg.Missing(inv)
+	require.Len(t, missing, 1)
+	reg.Add(&stripe.TaxRate{
+		ID:          "txr_new",
+		Active:      true,
+		DisplayName: stripe.StringValue(missing[0].DisplayName),
+		TaxType:     stripe.TaxRateTaxType(stripe.StringValue(missing[0].TaxType)),
+		Country:     stripe.StringValue(missing[0].Country),
+		Percentage:  stripe.Float64Value(missing[0].Percentage),
+		Inclusive:   stripe.BoolValue(missing[0].Inclusive),
+	})
+
+	_, items, err := goblstripe.ToInvoice(inv, goblstripe.WithTaxRateRegistry(reg))
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, []*string{stripe.String("txr_new")}, items[0].TaxRates)
+
+	cn := validGOBLCreditNote()
+	require.NoError(t, cn.Calculate())
+	params, err := goblstripe.ToCreditNote(cn, nil, goblstripe.WithTaxRateRegistry(reg))
+	require.NoError(t, err)
+	assert.Equal(t, []*string{stripe.String("txr_new")}, params.Lines[0].TaxRates)
+}
@@ -0,0 +1,331 @@
+package goblstripe
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// TaxTransactionOption is a functional option for FromTaxTransaction.
+type TaxTransactionOption func(*taxTransactionOptions)
+
+type taxTransactionOptions struct {
+	customer          *stripe.Customer
+	original          *stripe.TaxTransaction
+	originalReference string
+}
+
+// WithTaxTransactionCustomer provides the Stripe customer of a tax transaction, whose
+// name and email complete the customer details recorded in the transaction, which
+// only include the address and tax IDs.
+func WithTaxTransactionCustomer(cus *stripe.Customer) TaxTransactionOption {
+	return func(o *taxTransactionOptions) {
+		o.customer = cus
+	}
+}
+
+// WithOriginalTaxTransaction provides the original transaction of a reversal, whose
+// reference and tax date identify the invoice credited by the reversal. When its line
+// items are expanded, reversal lines are matched with the tax calculation through the
+// line they reverse, so they may have references of their own.
+func WithOriginalTaxTransaction(tx *stripe.TaxTransaction) TaxTransactionOption {
+	return func(o *taxTransactionOptions) {
+		o.original = tx
+	}
+}
+
+// WithOriginalTaxTransactionReference provides the reference of the original transaction
+// of a reversal, for when the transaction itself is not at hand. The credited invoice is
+// then referenced without an issue date.
+func WithOriginalTaxTransactionReference(ref string) TaxTransactionOption {
+	return func(o *taxTransactionOptions) {
+		o.originalReference = ref
+	}
+}
+
+// FromTaxTransaction converts a Stripe Tax transaction, recorded by a billing engine
+// outside of Stripe Billing, into a GOBL invoice, or into a credit note for reversal
+// transactions. The transaction reference is used as the invoice number.
+//
+// Transaction line items don't include the tax breakdown, so the tax calculation the
+// transaction was created from (or, for reversals, the calculation of the original
+// transaction) must be provided with its line items expanded. Its lines are matched
+// with the transaction lines by reference, and the taxes of each jurisdiction are
+// converted with the same rate lookup as invoice taxes. Lines without tax don't need
+// a calculation. Tax transactions don't carry the account country, so the account is
+// required to determine the regime and the supplier.
+//
+// Reversals only record the ID of the original transaction, while the invoice it was
+// converted into is numbered with its reference, so the original transaction or its
+// reference must be provided with WithOriginalTaxTransaction or
+// WithOriginalTaxTransactionReference.
+func FromTaxTransaction(tx *stripe.TaxTransaction, calc *stripe.TaxCalculation, account *stripe.Account, opts ...TaxTransactionOption) (*bill.Invoice, error) {
+	var options taxTransactionOptions
+	for _, o := range opts {
+		if o != nil {
+			o(&options)
+		}
+	}
+	if tx == nil {
+		return nil, fmt.Errorf("missing tax transaction")
+	}
+	if tx.LineItems == nil {
+		return nil, fmt.Errorf("tax transaction %s has no expanded line items", tx.ID)
+	}
+	regimeDef, err := regimeFromAccount(account)
+	if err != nil {
+		return nil, err
+	}
+	reversal := tx.Type == stripe.TaxTransactionTypeReversal
+	if reversal && options.original == nil && options.originalReference == "" {
+		return nil, fmt.Errorf("missing original transaction of reversal %s", tx.ID)
+	}
+
+	doc, err := newInvoiceFromTaxTransaction(tx, calc, account, &options)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := FromInvoice(doc, account)
+	if err != nil {
+		return inv, err
+	}
+	inv.Meta[MetaKeyStripeDocType] = StripeDocTypeTaxTransaction
+
+	// References come from the external billing engine, so they aren't split like
+	// Stripe invoice numbers
+	inv.Series = ""
+	inv.Code = cbc.Code(tx.Reference)
+
+	if reversal {
+		inv.Type = bill.InvoiceTypeCreditNote
+		inv.Preceding = []*org.DocumentRef{newPrecedingFromTaxTransaction(&options, regimeDef)}
+	}
+
+	return inv, nil
+}
+
+// newPrecedingFromTaxTransaction creates the reference to the invoice of the original
+// transaction of a reversal, which is numbered with the transaction reference (see
+// FromTaxTransaction) and issued on its tax date.
+func newPrecedingFromTaxTransaction(options *taxTransactionOptions, regimeDef *tax.RegimeDef) *org.DocumentRef {
+	docRef := &org.DocumentRef{
+		Type: bill.InvoiceTypeStandard,
+		Code: cbc.Code(options.originalReference),
+	}
+	if tx := options.original; tx != nil {
+		docRef.Code = cbc.Code(tx.Reference)
+		issued := tx.TaxDate
+		if issued == 0 {
+			issued = tx.Created
+		}
+		if issued != 0 {
+			docRef.IssueDate = newDateFromTS(issued, regimeDef.TimeLocation())
+		}
+	}
+	return docRef
+}
+
+// newInvoiceFromTaxTransaction builds the Stripe invoice equivalent to a tax transaction,
+// so it can be converted with the invoice logic. Reversals have negative amounts, which
+// are turned positive as they are converted into credit notes.
+func newInvoiceFromTaxTransaction(tx *stripe.TaxTransaction, calc *stripe.TaxCalculation, account *stripe.Account, options *taxTransactionOptions) (*stripe.Invoice, error) {
+	sign := int64(1)
+	if tx.Type == stripe.TaxTransactionTypeReversal {
+		sign = -1
+	}
+	taxDate := tx.TaxDate
+	if taxDate == 0 {
+		taxDate = tx.Created
+	}
+
+	doc := &stripe.Invoice{
+		ID:             tx.ID,
+		AccountCountry: account.Country,
+		Created:        tx.Created,
+		EffectiveAt:    taxDate,
+		PeriodStart:    taxDate,
+		PeriodEnd:      taxDate,
+		Currency:       tx.Currency,
+		Metadata:       tx.Metadata,
+		Lines:          &stripe.InvoiceLineItemList{},
+	}
+
+	if cus := options.customer; cus != nil {
+		doc.CustomerName = cus.Name
+		doc.CustomerEmail = cus.Email
+	}
+	if cd := tx.CustomerDetails; cd != nil {
+		doc.CustomerAddress = cd.Address
+		switch cd.TaxabilityOverride {
+		case stripe.TaxTransactionCustomerDetailsTaxabilityOverrideReverseCharge:
+			exempt := stripe.CustomerTaxExemptReverse
+			doc.CustomerTaxExempt = &exempt
+		case stripe.TaxTransactionCustomerDetailsTaxabilityOverrideCustomerExempt:
+			exempt := stripe.CustomerTaxExemptExempt
+			doc.CustomerTaxExempt = &exempt
+		}
+		for _, id := range cd.TaxIDs {
+			idType := stripe.TaxIDType(id.Type)
+			doc.CustomerTaxIDs = append(doc.CustomerTaxIDs, &stripe.InvoiceCustomerTaxID{
+				Type:  &idType,
+				Value: id.Value,
+			})
+		}
+	}
+
+	for _, li := range tx.LineItems.Data {
+		line := &stripe.InvoiceLineItem{
+			ID:          li.ID,
+			Amount:      sign * li.Amount,
+			Currency:    tx.Currency,
+			Description: li.Reference,
+			Metadata:    li.Metadata,
+			Quantity:    li.Quantity,
+		}
+		inclusive := li.TaxBehavior == stripe.TaxTransactionLineItemTaxBehaviorInclusive
+		if li.AmountTax != 0 {
+			cl, err := findTaxCalculationLine(calc, calculationReference(li, options.original))
+			if err != nil {
+				return nil, fmt.Errorf("missing tax breakdown for line %s of tax transaction %s: %w", li.Reference, tx.ID, err)
+			}
+			for _, b := range cl.TaxBreakdown {
+				if b.TaxRateDetails == nil || b.Jurisdiction == nil {
+					continue
+				}
+				taxAmount, err := newTaxAmountFromBreakdown(
+					b.Amount, b.TaxableAmount, string(b.TaxabilityReason),
+					string(b.TaxRateDetails.TaxType), b.TaxRateDetails.PercentageDecimal,
+					taxJurisdiction{
+						country: b.Jurisdiction.Country,
+						state:   b.Jurisdiction.State,
+						name:    b.Jurisdiction.DisplayName,
+						level:   string(b.Jurisdiction.Level),
+					},
+					inclusive, taxDate,
+				)
+				if err != nil {
+					return nil, err
+				}
+				line.TaxAmounts = append(line.TaxAmounts, taxAmount)
+			}
+		}
+		doc.Lines.Data = append(doc.Lines.Data, line)
+		doc.Total += line.Amount
+		if !inclusive {
+			doc.Total += sign * li.AmountTax
+		}
+	}
+
+	if sc := tx.ShippingCost; sc != nil && sc.Amount != 0 {
+		inclusive := sc.TaxBehavior == stripe.TaxTransactionShippingCostTaxBehaviorInclusive
+		line := &stripe.InvoiceLineItem{
+			Amount:      sign * sc.Amount,
+			Currency:    tx.Currency,
+			Description: "Shipping",
+			Quantity:    1,
+		}
+		if sc.AmountTax != 0 {
+			// The shipping breakdown is only populated in the calculation
+			if calc == nil || calc.ShippingCost == nil {
+				return nil, fmt.Errorf("missing tax breakdown for shipping of tax transaction %s", tx.ID)
+			}
+			for _, b := range calc.ShippingCost.TaxBreakdown {
+				if b.TaxRateDetails == nil || b.Jurisdiction == nil {
+					continue
+				}
+				taxAmount, err := newTaxAmountFromBreakdown(
+					b.Amount, b.TaxableAmount, string(b.TaxabilityReason),
+					string(b.TaxRateDetails.TaxType), b.TaxRateDetails.PercentageDecimal,
+					taxJurisdiction{
+						country: b.Jurisdiction.Country,
+						state:   b.Jurisdiction.State,
+						name:    b.Jurisdiction.DisplayName,
+						level:   string(b.Jurisdiction.Level),
+					},
+					inclusive, taxDate,
+				)
+				if err != nil {
+					return nil, err
+				}
+				line.TaxAmounts = append(line.TaxAmounts, taxAmount)
+			}
+		}
+		doc.Lines.Data = append(doc.Lines.Data, line)
+		doc.Total += line.Amount
+		if !inclusive {
+			doc.Total += sign * sc.AmountTax
+		}
+	}
+
+	doc.TotalTaxAmounts = lineTaxAmounts(doc.Lines.Data)
+	return doc, nil
+}
+
+// calculationReference returns the reference of the tax calculation line of a transaction
+// line. Reversal lines may have references of their own, so they take the reference of
+// the line they reverse when the original transaction is given with its line items.
+func calculationReference(li *stripe.TaxTransactionLineItem, original *stripe.TaxTransaction) string {
+	if li.Reversal == nil || original == nil || original.LineItems == nil {
+		return li.Reference
+	}
+	for _, ol := range original.LineItems.Data {
+		if ol.ID == li.Reversal.OriginalLineItem {
+			return ol.Reference
+		}
+	}
+	return li.Reference
+}
+
+// findTaxCalculationLine returns the line of a tax calculation with the given reference.
+func findTaxCalculationLine(calc *stripe.TaxCalculation, reference string) (*stripe.TaxCalculationLineItem, error) {
+	if calc == nil || calc.LineItems == nil {
+		return nil, fmt.Errorf("no tax calculation with expanded line items")
+	}
+	for _, cl := range calc.LineItems.Data {
+		if cl.Reference == reference {
+			return cl, nil
+		}
+	}
+	return nil, fmt.Errorf("no line with reference %q in tax calculation %s", reference, calc.ID)
+}
+
+// taxJurisdiction holds the jurisdiction of an entry of a Stripe Tax breakdown.
+type taxJurisdiction struct {
+	country string
+	state   string
+	name    string
+	level   string
+}
+
+// newTaxAmountFromBreakdown creates the invoice tax amount equivalent to an entry of a
+// Stripe Tax breakdown, with a tax rate built from the breakdown details, so it can be
+// converted like the taxes of invoices.
+func newTaxAmountFromBreakdown(amount, taxableAmount int64, reason, taxType, percentage string, jurisdiction taxJurisdiction, inclusive bool, taxDate int64) (*stripe.InvoiceTotalTaxAmount, error) {
+	percent, err := strconv.ParseFloat(percentage, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tax percentage %q: %w", percentage, err)
+	}
+	return &stripe.InvoiceTotalTaxAmount{
+		Amount:           amount,
+		Inclusive:        inclusive,
+		TaxabilityReason: stripe.InvoiceTotalTaxAmountTaxabilityReason(reason),
+		TaxableAmount:    taxableAmount,
+		TaxRate: &stripe.TaxRate{
+			Country:             jurisdiction.country,
+			State:               jurisdiction.state,
+			Jurisdiction:        jurisdiction.name,
+			JurisdictionLevel:   stripe.TaxRateJurisdictionLevel(jurisdiction.level),
+			Created:             taxDate,
+			EffectivePercentage: percent,
+			Inclusive:           inclusive,
+			Percentage:          percent,
+			TaxType:             stripe.TaxRateTaxType(taxType),
+		},
+	}, nil
+}
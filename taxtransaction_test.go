@@ -0,0 +1,193 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func validStripeTaxTransaction() *stripe.TaxTransaction {
+	return &stripe.TaxTransaction{
+		ID:        "tax_1QkqKWQhcl5B85YlGnO9RrTd",
+		Object:    "tax.transaction",
+		Type:      stripe.TaxTransactionTypeTransaction,
+		Reference: "ORD-2025-0042",
+		Created:   1737738363,
+		TaxDate:   1737738363,
+		Currency:  stripe.CurrencyEUR,
+		CustomerDetails: &stripe.TaxTransactionCustomerDetails{
+			Address: &stripe.Address{
+				City:       "Berlin",
+				Country:    "DE",
+				Line1:      "Unter den Linden 1",
+				PostalCode: "10117",
+			},
+			AddressSource:      stripe.TaxTransactionCustomerDetailsAddressSourceBilling,
+			TaxabilityOverride: stripe.TaxTransactionCustomerDetailsTaxabilityOverrideNone,
+			TaxIDs: []*stripe.TaxTransactionCustomerDetailsTaxID{
+				{Type: stripe.TaxTransactionCustomerDetailsTaxIDTypeEUVAT, Value: "DE282741168"},
+			},
+		},
+		LineItems: &stripe.TaxTransactionLineItemList{
+			Data: []*stripe.TaxTransactionLineItem{
+				{
+					ID:          "tax_li_RdUmBpLiQAzaB1",
+					Amount:      1000,
+					AmountTax:   190,
+					Quantity:    2,
+					Reference:   "Consulting hours",
+					TaxBehavior: stripe.TaxTransactionLineItemTaxBehaviorExclusive,
+					TaxCode:     "txcd_10000000",
+					Type:        stripe.TaxTransactionLineItemTypeTransaction,
+				},
+			},
+		},
+	}
+}
+
+func validStripeTaxCalculation() *stripe.TaxCalculation {
+	return &stripe.TaxCalculation{
+		ID:       "taxcalc_1QkqKVQhcl5B85Yl4IeMT0Ge",
+		Currency: stripe.CurrencyEUR,
+		LineItems: &stripe.TaxCalculationLineItemList{
+			Data: []*stripe.TaxCalculationLineItem{
+				{
+					ID:          "tax_li_RdUmD0R3oTOVfw",
+					Amount:      1000,
+					AmountTax:   190,
+					Quantity:    2,
+					Reference:   "Consulting hours",
+					TaxBehavior: stripe.TaxCalculationLineItemTaxBehaviorExclusive,
+					TaxBreakdown: []*stripe.TaxCalculationLineItemTaxBreakdown{
+						{
+							Amount: 190,
+							Jurisdiction: &stripe.TaxCalculationLineItemTaxBreakdownJurisdiction{
+								Country:     "DE",
+								DisplayName: "Germany",
+								Level:       stripe.TaxCalculationLineItemTaxBreakdownJurisdictionLevelCountry,
+							},
+							Sourcing:         stripe.TaxCalculationLineItemTaxBreakdownSourcingDestination,
+							TaxabilityReason: stripe.TaxCalculationLineItemTaxBreakdownTaxabilityReasonStandardRated,
+							TaxableAmount:    1000,
+							TaxRateDetails: &stripe.TaxCalculationLineItemTaxBreakdownTaxRateDetails{
+								DisplayName:       "VAT",
+								PercentageDecimal: "19.0",
+								TaxType:           stripe.TaxCalculationLineItemTaxBreakdownTaxRateDetailsTaxTypeVAT,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestFromTaxTransaction(t *testing.T) {
+	account := validStripeAccount()
+	account.Country = "DE"
+	customer := goblstripe.WithTaxTransactionCustomer(&stripe.Customer{
+		ID:    "cus_RdUmqOIsDzXeRv",
+		Name:  "Acme GmbH",
+		Email: "billing@acme.example",
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		inv, err := goblstripe.FromTaxTransaction(validStripeTaxTransaction(), validStripeTaxCalculation(), account, customer)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, bill.InvoiceTypeStandard, inv.Type)
+		assert.Equal(t, "ORD-2025-0042", inv.Code.String())
+		assert.Equal(t, "tax_transaction", inv.Meta[goblstripe.MetaKeyStripeDocType])
+		assert.Equal(t, "Acme GmbH", inv.Customer.Name)
+		assert.Equal(t, "282741168", inv.Customer.TaxID.Code.String())
+
+		require.Len(t, inv.Lines, 1)
+		assert.Equal(t, "Consulting hours", inv.Lines[0].Item.Name)
+		assert.Equal(t, "10.00", inv.Lines[0].Item.Price.String())
+		require.Len(t, inv.Lines[0].Taxes, 1)
+		assert.Equal(t, tax.CategoryVAT, inv.Lines[0].Taxes[0].Category)
+		assert.Equal(t, "19%", inv.Lines[0].Taxes[0].Percent.String())
+		assert.Equal(t, "11.90", inv.Totals.Payable.String())
+	})
+
+	newReversal := func() *stripe.TaxTransaction {
+		tx := validStripeTaxTransaction()
+		tx.ID = "tax_1QkqLAQhcl5B85YlA7mmXbAq"
+		tx.Type = stripe.TaxTransactionTypeReversal
+		tx.Reference = "ORD-2025-0042-refund"
+		tx.Created = 1737824763 // 2025-01-25
+		tx.TaxDate = 1737824763
+		tx.Reversal = &stripe.TaxTransactionReversal{OriginalTransaction: "tax_1QkqKWQhcl5B85YlGnO9RrTd"}
+		li := tx.LineItems.Data[0]
+		li.ID = "tax_li_RdUnX4bYtZ2mQe"
+		li.Amount = -1000
+		li.AmountTax = -190
+		li.Type = stripe.TaxTransactionLineItemTypeReversal
+		li.Reversal = &stripe.TaxTransactionLineItemReversal{OriginalLineItem: "tax_li_RdUmBpLiQAzaB1"}
+		return tx
+	}
+
+	t.Run("reversal", func(t *testing.T) {
+		original := goblstripe.WithOriginalTaxTransaction(validStripeTaxTransaction())
+		inv, err := goblstripe.FromTaxTransaction(newReversal(), validStripeTaxCalculation(), account, customer, original)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, bill.InvoiceTypeCreditNote, inv.Type)
+		assert.Equal(t, "ORD-2025-0042-refund", inv.Code.String())
+		require.Len(t, inv.Preceding, 1)
+		assert.Equal(t, "ORD-2025-0042", inv.Preceding[0].Code.String())
+		require.NotNil(t, inv.Preceding[0].IssueDate)
+		assert.Equal(t, cal.MakeDate(2025, 1, 24), *inv.Preceding[0].IssueDate)
+		assert.Equal(t, "11.90", inv.Totals.Payable.String())
+	})
+
+	t.Run("reversal lines with their own reference", func(t *testing.T) {
+		tx := newReversal()
+		tx.LineItems.Data[0].Reference = "Consulting hours refund"
+
+		original := goblstripe.WithOriginalTaxTransaction(validStripeTaxTransaction())
+		inv, err := goblstripe.FromTaxTransaction(tx, validStripeTaxCalculation(), account, customer, original)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		assert.Equal(t, "11.90", inv.Totals.Payable.String())
+
+		// Without the original line items, the reference must match the calculation
+		_, err = goblstripe.FromTaxTransaction(tx, validStripeTaxCalculation(), account, customer,
+			goblstripe.WithOriginalTaxTransactionReference("ORD-2025-0042"))
+		assert.ErrorContains(t, err, `no line with reference "Consulting hours refund"`)
+	})
+
+	t.Run("reversal with the original reference", func(t *testing.T) {
+		original := goblstripe.WithOriginalTaxTransactionReference("ORD-2025-0042")
+		inv, err := goblstripe.FromTaxTransaction(newReversal(), validStripeTaxCalculation(), account, customer, original)
+		require.NoError(t, err)
+
+		require.Len(t, inv.Preceding, 1)
+		assert.Equal(t, "ORD-2025-0042", inv.Preceding[0].Code.String())
+		assert.Nil(t, inv.Preceding[0].IssueDate)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := goblstripe.FromTaxTransaction(nil, nil, account)
+		assert.ErrorContains(t, err, "missing tax transaction")
+
+		_, err = goblstripe.FromTaxTransaction(validStripeTaxTransaction(), nil, account)
+		assert.ErrorContains(t, err, "missing tax breakdown")
+
+		_, err = goblstripe.FromTaxTransaction(validStripeTaxTransaction(), validStripeTaxCalculation(), nil)
+		assert.ErrorContains(t, err, "missing account country")
+
+		_, err = goblstripe.FromTaxTransaction(newReversal(), validStripeTaxCalculation(), account)
+		assert.ErrorContains(t, err, "missing original transaction")
+	})
+}
@@ -0,0 +1,138 @@
+package goblstripe
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/schema"
+	"github.com/stripe/stripe-go/v81"
+)
+
+// cancellationCodeSuffix is appended to the number of a cancelled invoice to get the
+// code of the credit note that cancels it.
+const cancellationCodeSuffix = "-CANCEL"
+
+// Reasons given in the credit notes that cancel voided and uncollectible invoices.
+const (
+	cancellationReasonVoided        = "Invoice voided"
+	cancellationReasonUncollectible = "Invoice marked as uncollectible"
+)
+
+// VoidedInvoiceOption is a functional option for FromVoidedInvoice.
+type VoidedInvoiceOption func(*voidedInvoiceOptions)
+
+type voidedInvoiceOptions struct {
+	correction    []schema.Option
+	uncollectible bool
+}
+
+// WithCorrectionOptions provides additional GOBL correction options, such as the stamps
+// of the original invoice or regime extensions, required by some regimes to correct an
+// invoice.
+func WithCorrectionOptions(opts ...schema.Option) VoidedInvoiceOption {
+	return func(o *voidedInvoiceOptions) {
+		o.correction = append(o.correction, opts...)
+	}
+}
+
+// WithUncollectible allows invoices marked as uncollectible to be cancelled too. An
+// uncollectible invoice is a bad debt rather than a cancellation, and may still be
+// paid, so whether it is cancelled depends on how the regime treats bad debts.
+func WithUncollectible() VoidedInvoiceOption {
+	return func(o *voidedInvoiceOptions) {
+		o.uncollectible = true
+	}
+}
+
+// FromVoidedInvoice converts a finalized Stripe invoice that was voided into a GOBL
+// credit note for its full amount, which cancels the original invoice. Invoices marked
+// as uncollectible are only cancelled with WithUncollectible. The credit note is issued
+// on the date the invoice was voided or marked as uncollectible, and is built with the
+// GOBL correction logic so the requirements of the regime (allowed correction types,
+// reasons, stamps and extensions) are enforced. Its code is the invoice number followed
+// by "-CANCEL".
+func FromVoidedInvoice(doc *stripe.Invoice, account *stripe.Account, opts ...VoidedInvoiceOption) (*bill.Invoice, error) {
+	var options voidedInvoiceOptions
+	for _, o := range opts {
+		if o != nil {
+			o(&options)
+		}
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("missing invoice")
+	}
+
+	var reason string
+	var cancelled int64
+	switch doc.Status {
+	case stripe.InvoiceStatusVoid:
+		reason = cancellationReasonVoided
+		if doc.StatusTransitions != nil {
+			cancelled = doc.StatusTransitions.VoidedAt
+		}
+	case stripe.InvoiceStatusUncollectible:
+		if !options.uncollectible {
+			return nil, fmt.Errorf("invoice %s is uncollectible, which only cancels it with WithUncollectible", doc.ID)
+		}
+		reason = cancellationReasonUncollectible
+		if doc.StatusTransitions != nil {
+			cancelled = doc.StatusTransitions.MarkedUncollectibleAt
+		}
+	default:
+		return nil, fmt.Errorf("invoice %s is %s, only void or uncollectible invoices can be cancelled", doc.ID, doc.Status)
+	}
+	if doc.Number == "" {
+		return nil, fmt.Errorf("invoice %s was never finalized", doc.ID)
+	}
+
+	regimeDef, err := regimeFromInvoice(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := FromInvoice(doc, account)
+	if err != nil {
+		return inv, err
+	}
+
+	// Nothing remains to be paid for a cancelled invoice
+	inv.Payment = nil
+
+	// The totals of the original invoice are needed to copy its taxes when the regime
+	// requires them
+	if err := inv.Calculate(); err != nil {
+		return inv, err
+	}
+
+	if cancelled == 0 {
+		cancelled = doc.Created
+	}
+	correction := []schema.Option{
+		bill.Credit,
+		bill.WithReason(reason),
+		bill.WithIssueDate(*newDateFromTS(cancelled, regimeDef.TimeLocation())),
+	}
+	if err := inv.Correct(append(correction, options.correction...)...); err != nil {
+		return inv, fmt.Errorf("correcting invoice %s: %w", doc.ID, err)
+	}
+
+	// Keep the stamps, extensions and taxes added by the correction, but reference the
+	// invoice as issued in Stripe
+	pre := newPrecedingFromInvoice(doc, reason, regimeDef)
+	if len(inv.Preceding) > 0 {
+		corrected := inv.Preceding[0]
+		pre.UUID = corrected.UUID
+		pre.Stamps = corrected.Stamps
+		pre.Ext = corrected.Ext
+		pre.Tax = corrected.Tax
+	}
+	inv.Preceding = []*org.DocumentRef{pre}
+
+	inv.Series = ""
+	inv.Code = cbc.Code(doc.Number + cancellationCodeSuffix)
+	inv.Meta[MetaKeyStripeDocType] = StripeDocTypeInvoiceCancellation
+
+	return inv, nil
+}
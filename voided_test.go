@@ -0,0 +1,82 @@
+package goblstripe_test
+
+import (
+	"testing"
+
+	goblstripe "github.com/invopop/gobl.stripe"
+	"github.com/invopop/gobl/bill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v81"
+)
+
+func voidedStripeInvoice() *stripe.Invoice {
+	doc := completeStripeInvoice()
+	doc.Number = "INV-0042"
+	doc.Status = stripe.InvoiceStatusVoid
+	doc.AmountPaid = 0
+	doc.AmountRemaining = 0
+	doc.StatusTransitions = &stripe.InvoiceStatusTransitions{
+		FinalizedAt: 1737738363,
+		VoidedAt:    1738281600, // 2025-01-31
+	}
+	return doc
+}
+
+func TestFromVoidedInvoice(t *testing.T) {
+	t.Run("voided", func(t *testing.T) {
+		inv, err := goblstripe.FromVoidedInvoice(voidedStripeInvoice(), nil)
+		require.NoError(t, err)
+		require.NoError(t, inv.Calculate())
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, bill.InvoiceTypeCreditNote, inv.Type)
+		assert.Equal(t, "INV-0042-CANCEL", inv.Code.String())
+		assert.Empty(t, inv.Series)
+		assert.Equal(t, "2025-01-31", inv.IssueDate.String())
+		assert.Equal(t, "invoice_cancellation", inv.Meta[goblstripe.MetaKeyStripeDocType])
+		assert.Nil(t, inv.Payment)
+
+		require.Len(t, inv.Preceding, 1)
+		pre := inv.Preceding[0]
+		assert.Equal(t, "INV", pre.Series.String())
+		assert.Equal(t, "0042", pre.Code.String())
+		assert.Equal(t, "2024-01-01", pre.IssueDate.String())
+		assert.Equal(t, "Invoice voided", pre.Reason)
+		assert.Equal(t, "226.09", inv.Totals.Payable.String())
+	})
+
+	t.Run("uncollectible", func(t *testing.T) {
+		doc := voidedStripeInvoice()
+		doc.Status = stripe.InvoiceStatusUncollectible
+		doc.StatusTransitions.VoidedAt = 0
+		doc.StatusTransitions.MarkedUncollectibleAt = 1738368000 // 2025-02-01
+
+		_, err := goblstripe.FromVoidedInvoice(doc, nil)
+		assert.ErrorContains(t, err, "uncollectible")
+
+		inv, err := goblstripe.FromVoidedInvoice(doc, nil, goblstripe.WithUncollectible(), goblstripe.WithCorrectionOptions(bill.WithCopyTax()))
+		require.NoError(t, err)
+		require.NoError(t, inv.Validate())
+
+		assert.Equal(t, "2025-02-01", inv.IssueDate.String())
+		require.Len(t, inv.Preceding, 1)
+		assert.Equal(t, "Invoice marked as uncollectible", inv.Preceding[0].Reason)
+		assert.NotNil(t, inv.Preceding[0].Tax)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := goblstripe.FromVoidedInvoice(nil, nil)
+		assert.ErrorContains(t, err, "missing invoice")
+
+		doc := voidedStripeInvoice()
+		doc.Status = stripe.InvoiceStatusPaid
+		_, err = goblstripe.FromVoidedInvoice(doc, nil)
+		assert.ErrorContains(t, err, "only void or uncollectible invoices")
+
+		doc = voidedStripeInvoice()
+		doc.Number = ""
+		_, err = goblstripe.FromVoidedInvoice(doc, nil)
+		assert.ErrorContains(t, err, "never finalized")
+	})
+}